@@ -2,8 +2,12 @@ package quantum
 
 import (
 	"errors"
+	"fmt"
 	"io"
 	"net"
+	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/doubledutch/lager"
@@ -23,23 +27,130 @@ type Acceptor interface {
 	io.Closer
 }
 
-// ListenAndServe is a common function for listening on a port and accepting connections
+// ListenAndServe is a common function for listening on a port and accepting
+// connections. Errors from Listen are returned as is.
 func ListenAndServe(a Acceptor, port string, lgr lager.Lager) error {
-	netaddr, err := net.ResolveTCPAddr("tcp", port)
+	ln, err := Listen(port)
 	if err != nil {
 		a.Close()
-		return ErrInvalidAddr
-	}
-	ln, err := net.ListenTCP("tcp", netaddr)
-	if err != nil {
-		a.Close()
-		return ErrListen
+		return err
 	}
 	lgr.Infof("Listening on %s", port)
+
+	return Serve(a, ln, lgr)
+}
+
+// Listen listens on spec, which is one of:
+//
+//	host:port          a TCP address, e.g. ":8500"
+//	tcp://host:port    also tcp4:// and tcp6://
+//	unix:/path         a Unix domain socket, also unix:///path
+//	fd://N             an inherited listener, e.g. from systemd socket activation
+//
+// Sockets left by agents that didn't shut down cleanly are replaced.
+//
+// Errors wrap ErrInvalidAddr or ErrListen with the underlying cause, so
+// compare them with errors.Is rather than ==.
+func Listen(spec string) (net.Listener, error) {
+	network, address := SplitNetwork(spec)
+
+	switch network {
+	case "fd":
+		fd, err := strconv.Atoi(address)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidAddr, err)
+		}
+		f := os.NewFile(uintptr(fd), "fd"+address)
+		defer f.Close()
+
+		ln, err := net.FileListener(f)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrListen, err)
+		}
+		return ln, nil
+	case "unix":
+		addr, err := net.ResolveUnixAddr(network, address)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidAddr, err)
+		}
+		ln, err := net.ListenUnix(network, addr)
+		if err != nil && removeStaleSocket(address) {
+			ln, err = net.ListenUnix(network, addr)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrListen, err)
+		}
+		return ln, nil
+	default:
+		addr, err := net.ResolveTCPAddr(network, address)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidAddr, err)
+		}
+		ln, err := net.ListenTCP(network, addr)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrListen, err)
+		}
+		return ln, nil
+	}
+}
+
+// removeStaleSocket removes the socket at path left by an agent that didn't
+// shut down cleanly, returning whether it did. Sockets accepting
+// connections are left alone.
+func removeStaleSocket(path string) bool {
+	info, err := os.Lstat(path)
+	if err != nil || info.Mode()&os.ModeSocket == 0 {
+		return false
+	}
+
+	conn, err := net.Dial("unix", path)
+	if err == nil {
+		conn.Close()
+		return false
+	}
+	return os.Remove(path) == nil
+}
+
+// SplitNetwork splits an address into its network and the address within
+// it, so "unix:/tmp/agent.sock" is ("unix", "/tmp/agent.sock"). Addresses
+// without a network are TCP.
+func SplitNetwork(address string) (string, string) {
+	for _, network := range []string{"tcp", "tcp4", "tcp6", "unix", "fd"} {
+		if rest := strings.TrimPrefix(address, network+"://"); rest != address {
+			return network, rest
+		}
+	}
+
+	if strings.HasPrefix(address, "unix:") {
+		return "unix", strings.TrimPrefix(address, "unix:")
+	}
+	return "tcp", address
+}
+
+// deadliner is a net.Listener whose Accept can time out
+type deadliner interface {
+	SetDeadline(time.Time) error
+}
+
+// Serve accepts connections from ln for a until a shuts down, then closes ln
+func Serve(a Acceptor, ln net.Listener, lgr lager.Lager) error {
+	defer ln.Close()
+
+	dl, ok := ln.(deadliner)
+	if !ok {
+		// Without deadlines, closing ln unblocks Accept
+		go func() {
+			<-a.IsShutdown()
+			ln.Close()
+		}()
+	}
+
 RECV_LOOP:
 	for {
-		// Set a deadline so we can check for shutdown
-		ln.SetDeadline(time.Now().Add(500 * time.Millisecond))
+		if dl != nil {
+			// Set a deadline so we can check for shutdown
+			dl.SetDeadline(time.Now().Add(500 * time.Millisecond))
+		}
 		select {
 		case <-a.IsShutdown():
 			a.Close()
@@ -0,0 +1,117 @@
+package quantum
+
+import (
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSplitNetwork(t *testing.T) {
+	tests := []struct {
+		address, network, addr string
+	}{
+		{":8500", "tcp", ":8500"},
+		{"tcp://127.0.0.1:8500", "tcp", "127.0.0.1:8500"},
+		{"tcp6://[::1]:8500", "tcp6", "[::1]:8500"},
+		{"unix:/tmp/agent.sock", "unix", "/tmp/agent.sock"},
+		{"unix:///tmp/agent.sock", "unix", "/tmp/agent.sock"},
+		{"fd://3", "fd", "3"},
+	}
+
+	for _, test := range tests {
+		network, addr := SplitNetwork(test.address)
+		if network != test.network || addr != test.addr {
+			t.Fatalf("%s: expected %s %s, got %s %s", test.address, test.network, test.addr, network, addr)
+		}
+	}
+}
+
+func TestListenUnix(t *testing.T) {
+	dir, err := ioutil.TempDir("", "quantum-listen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "agent.sock")
+
+	ln, err := Listen("unix:" + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	address := DialAddress(ln.Addr(), "")
+	if address != "unix:"+path {
+		t.Fatalf("wrong dial address: %s", address)
+	}
+	if _, err := TCPPort(ln.Addr()); err != ErrNotTCP {
+		t.Fatalf("expected not TCP, got %v", err)
+	}
+
+	network, addr := SplitNetwork(address)
+	conn, err := net.Dial(network, addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+}
+
+func TestListenUnixStale(t *testing.T) {
+	dir, err := ioutil.TempDir("", "quantum-listen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "agent.sock")
+
+	// Agents that don't shut down cleanly leave their socket behind
+	stale, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.SetUnlinkOnClose(false)
+	stale.Close()
+
+	ln, err := Listen("unix:" + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	// Sockets in use aren't removed
+	if _, err := Listen("unix:" + path); !errors.Is(err, ErrListen) {
+		t.Fatalf("expected listen error, got %v", err)
+	}
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+}
+
+func TestListenTCP(t *testing.T) {
+	ln, err := Listen("tcp://127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	port, err := TCPPort(ln.Addr())
+	if err != nil || port == 0 {
+		t.Fatalf("expected bound port, got %d %v", port, err)
+	}
+	if address := DialAddress(ln.Addr(), "example.com"); address != ln.Addr().String() {
+		t.Fatalf("expected bound IP, got %s", address)
+	}
+
+	if address := DialAddress(&net.TCPAddr{Port: 8500}, "example.com"); address != "example.com:8500" {
+		t.Fatalf("expected host, got %s", address)
+	}
+
+	if _, err := Listen("fd://stdin"); !errors.Is(err, ErrInvalidAddr) {
+		t.Fatalf("expected invalid address, got %v", err)
+	}
+}
@@ -0,0 +1,36 @@
+package quantum
+
+import (
+	"errors"
+	"net"
+	"strconv"
+)
+
+// ErrNotTCP = a registrator requires agents to listen on TCP
+var ErrNotTCP = errors.New("Address Not TCP")
+
+// TCPPort returns the port of addr, or ErrNotTCP if addr isn't a TCP address
+func TCPPort(addr net.Addr) (int, error) {
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return 0, ErrNotTCP
+	}
+	return tcpAddr.Port, nil
+}
+
+// DialAddress returns the address clients dial to reach an agent bound to
+// addr. Unspecified IPs, such as those of ":8500", are replaced with host,
+// and Unix domain sockets are prefixed with "unix:".
+func DialAddress(addr net.Addr, host string) string {
+	switch addr := addr.(type) {
+	case *net.TCPAddr:
+		if addr.IP != nil && !addr.IP.IsUnspecified() {
+			host = addr.IP.String()
+		}
+		return net.JoinHostPort(host, strconv.Itoa(addr.Port))
+	case *net.UnixAddr:
+		return "unix:" + addr.Name
+	default:
+		return addr.String()
+	}
+}
@@ -1,6 +1,7 @@
 package quantum
 
 import (
+	"errors"
 	"os"
 
 	"github.com/doubledutch/lager"
@@ -11,13 +12,38 @@ import (
 type Agent interface {
 	Acceptor
 	Registry
+	HealthChecker
 	Start() error
 }
 
+// ErrAtCapacity describes an agent running as many jobs as it can. Such
+// agents are busy rather than unhealthy, they accept jobs again once a job
+// completes.
+var ErrAtCapacity = errors.New("Agent is at capacity")
+
+// HealthChecker reports the health of an agent. Healthy returns nil when
+// the agent can accept jobs, or the reason it can't, ErrAtCapacity while
+// it's busy.
+type HealthChecker interface {
+	Healthy() error
+}
+
 // AgentConn is a connection created on an agent
 type AgentConn interface {
 	mux.Server
 	Logs() chan string
 	Signals() chan os.Signal
+	// Stdin receives the stdin of the client, see StdinReader
+	Stdin() chan []byte
+	// WindowSizes receives the terminal size changes of the client
+	WindowSizes() chan WindowSize
+	// Files receives the chunks of files uploaded by the client
+	Files() chan FileChunk
+	// Workspace is the directory allocated to the job, see Workspaces.
+	// It's empty when the agent doesn't allocate workspaces.
+	Workspace() string
+	// Handshake is the handshake negotiated with the client, nil if the
+	// connection wasn't negotiated
+	Handshake() *Handshake
 	Lager() lager.Lager
 }
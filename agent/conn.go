@@ -19,10 +19,26 @@ type Conn struct {
 	OutCh chan string
 
 	// Receivers
-	SigCh     chan os.Signal
-	RequestCh chan quantum.Request
+	SigCh      chan os.Signal
+	StdinCh    chan []byte
+	ResizeCh   chan quantum.WindowSize
+	FileCh     chan quantum.FileChunk
+	RequestCh  chan quantum.Request
+	EnvelopeCh chan quantum.Envelope
+
+	// Workspaces allocates the workspace of the job, if set
+	Workspaces *quantum.Workspaces
+
+	workspace string
+	handshake *quantum.Handshake
+	limiter   limiter
+	lgr       lager.Lager
+}
 
-	lgr lager.Lager
+// limiter limits the jobs run at once across connections
+type limiter interface {
+	acquire() error
+	release()
 }
 
 // NewConn returns a new Connection connected to the specified io.ReadWriter
@@ -37,10 +53,14 @@ func NewConn(conn net.Conn, config *quantum.ConnConfig) (*Conn, error) {
 	}
 
 	ac := &Conn{
-		Server:    srv,
-		OutCh:     make(chan string, 1),
-		SigCh:     make(chan os.Signal, 1),
-		RequestCh: make(chan quantum.Request, 1),
+		Server:     srv,
+		OutCh:      make(chan string, 1),
+		SigCh:      make(chan os.Signal, 1),
+		StdinCh:    make(chan []byte, 1),
+		ResizeCh:   make(chan quantum.WindowSize, 1),
+		FileCh:     make(chan quantum.FileChunk, 1),
+		RequestCh:  make(chan quantum.Request, 1),
+		EnvelopeCh: make(chan quantum.Envelope, 1),
 
 		lgr: config.Lager,
 	}
@@ -49,41 +69,164 @@ func NewConn(conn net.Conn, config *quantum.ConnConfig) (*Conn, error) {
 	sigR := mux.NewSignalReceiver(ac.SigCh, config.Pool)
 	srv.Receive(mux.SignalType, sigR)
 
-	requestR := quantum.NewRequestReceiver(ac.RequestCh)
+	stdinR := config.Pool.NewReceiver(ac.StdinCh)
+	srv.Receive(quantum.StdinType, stdinR)
+
+	resizeR := config.Pool.NewReceiver(ac.ResizeCh)
+	srv.Receive(quantum.WindowSizeType, resizeR)
+
+	fileR := config.Pool.NewReceiver(ac.FileCh)
+	srv.Receive(quantum.FileChunkType, fileR)
+
+	requestR := quantum.NewRequestReceiver(ac.RequestCh, config.Pool)
 	srv.Receive(quantum.RequestType, requestR)
 
+	envelopeR := config.Pool.NewReceiver(ac.EnvelopeCh)
+	srv.Receive(quantum.EnvelopeType, envelopeR)
+
 	go srv.Recv()
 
 	return ac, nil
 }
 
+// Negotiate negotiates the handshake of the agent name with the client on
+// conn, then returns a new Connection using the negotiated codec. Clients
+// predating handshakes are served with config, without a handshake.
+func Negotiate(conn net.Conn, config *quantum.ConnConfig, name string) (*Conn, error) {
+	if config == nil {
+		config = quantum.DefaultConnConfig()
+	}
+
+	conn, hello, err := quantum.DetectHello(conn, config)
+	if err != nil {
+		return nil, err
+	}
+	if !hello {
+		ac, err := NewConn(conn, config)
+		if err != nil {
+			return nil, err
+		}
+		// The original protocol has no capabilities
+		ac.handshake = &quantum.Handshake{}
+		return ac, nil
+	}
+
+	handshake, err := quantum.AgentHandshake(conn, config, name)
+	if err != nil {
+		return nil, err
+	}
+
+	ac, err := NewConn(conn, config.Negotiated(handshake))
+	if err != nil {
+		return nil, err
+	}
+	ac.handshake = handshake
+	return ac, nil
+}
+
+// Send sends v to the client, failing with quantum.ErrCapabilityUnsupported
+// when the client doesn't support messages of type t
+func (conn *Conn) Send(t uint8, v interface{}) error {
+	if err := conn.handshake.Allows(t); err != nil {
+		return err
+	}
+	return conn.Server.Send(t, v)
+}
+
 // Signals returns the signals channel of the connection
 func (conn *Conn) Signals() chan os.Signal {
 	return conn.SigCh
 }
 
+// Stdin returns the stdin channel of the connection
+func (conn *Conn) Stdin() chan []byte {
+	return conn.StdinCh
+}
+
+// WindowSizes returns the window size channel of the connection
+func (conn *Conn) WindowSizes() chan quantum.WindowSize {
+	return conn.ResizeCh
+}
+
+// Files returns the channel of files uploaded to the connection
+func (conn *Conn) Files() chan quantum.FileChunk {
+	return conn.FileCh
+}
+
 // Logs returns the logs channel of the connection
 func (conn *Conn) Logs() chan string {
 	return conn.OutCh
 }
 
+// Workspace returns the workspace allocated to the job of the connection
+func (conn *Conn) Workspace() string {
+	return conn.workspace
+}
+
+// Handshake returns the handshake negotiated with the client
+func (conn *Conn) Handshake() *quantum.Handshake {
+	return conn.handshake
+}
+
 // Lager returns the Lager of the connection, allowing jobs
 // to log to the agent
 func (conn *Conn) Lager() lager.Lager {
 	return conn.lgr
 }
 
+// Done sends err as a quantum.Error, preserving its code for the client,
+// then completes the connection.
+func (conn *Conn) Done(err error) {
+	// Clients without typed errors get the message of err from Done
+	if err != nil && conn.handshake.Allows(quantum.ErrorType) == nil {
+		if sendErr := quantum.SendError(conn, err); sendErr != nil {
+			conn.lgr.Errorf("Error sending error: %s\n", sendErr)
+		}
+	}
+	conn.Server.Done(err)
+}
+
 // Serve processes a connection and serves the response
 func (conn *Conn) Serve(reg quantum.Registry) {
 	conn.Done(conn.serve(reg))
 }
 
-func (conn *Conn) serve(reg quantum.Registry) (err error) {
-	var request quantum.Request
+func (conn *Conn) serve(reg quantum.Registry) error {
 	select {
-	case request = <-conn.RequestCh:
+	case request, ok := <-conn.RequestCh:
+		if ok {
+			return conn.runJob(reg, request, conn, &conn.workspace)
+		}
+	case envelope, ok := <-conn.EnvelopeCh:
+		if ok {
+			return conn.serveSession(reg, envelope)
+		}
 	case <-conn.IsShutdown():
-		return errors.New("connection shutdown")
+	}
+	return errors.New("connection shutdown")
+}
+
+// runJob runs the job of request on ac, in a workspace stored in workspace
+func (conn *Conn) runJob(reg quantum.Registry, request quantum.Request, ac quantum.AgentConn, workspace *string) (err error) {
+	if conn.limiter != nil {
+		if err = conn.limiter.acquire(); err != nil {
+			conn.lgr.Errorf("Rejecting job: %s\n", err)
+			return
+		}
+		defer conn.limiter.release()
+	}
+
+	if conn.Workspaces != nil {
+		if *workspace, err = conn.Workspaces.Create(request.Type); err != nil {
+			conn.lgr.Errorf("Error creating workspace: %s\n", err)
+			return
+		}
+		// Released after recovering, so panics count as failures
+		defer func() {
+			if releaseErr := conn.Workspaces.Release(*workspace, err); releaseErr != nil {
+				conn.lgr.Errorf("Error releasing workspace: %s\n", releaseErr)
+			}
+		}()
 	}
 
 	defer func() {
@@ -102,7 +245,7 @@ func (conn *Conn) serve(reg quantum.Registry) (err error) {
 	}
 
 	conn.lgr.Debugf("running job: %s", err)
-	err = job.Run(conn)
+	err = job.Run(ac)
 	conn.lgr.Infof("job completed: %s", err)
 	return
 }
@@ -6,6 +6,7 @@ import (
 	"os"
 	"os/signal"
 	"strconv"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -15,32 +16,65 @@ import (
 
 var (
 	// ErrUnexpectedError describes the error when a job panics
-	ErrUnexpectedError = errors.New("Job exited with unexpected error")
+	ErrUnexpectedError = quantum.ErrJobPanicked
+	// ErrDraining describes an agent that is shutting down
+	ErrDraining = errors.New("Agent is draining")
+	// ErrAtCapacity describes an agent running Config.Concurrency jobs
+	ErrAtCapacity = quantum.ErrAtCapacity
 )
 
 // Config encapsulates configuration for an agent
 type Config struct {
 	*quantum.ConnConfig
 
+	// Port is the TCP address to listen on, such as ":8500".
+	//
+	// Deprecated: use Listen.
 	Port string
+	// Listen is the spec of the listener, see quantum.Listen, defaulting
+	// to Port
+	Listen string
+	// Listener accepts connections instead of listening on Listen
+	Listener net.Listener
+	// Name is sent to clients in handshakes, defaulting to the hostname
+	Name string
+	// Labels are advertised by Registrators implementing quantum.LabeledRegistrator
+	Labels quantum.Labels
+	// Concurrency limits the number of jobs run at once, 0 is unlimited
+	Concurrency int
 
 	Registry    quantum.Registry
 	Registrator quantum.Registrator
+
+	// Workspaces allocates a workspace for each job, defaulting to
+	// quantum.NewWorkspaces("")
+	Workspaces *quantum.Workspaces
+	// CollectInterval is how often old workspaces are collected, defaulting
+	// to quantum.DefaultWorkspaceCollectInterval
+	CollectInterval time.Duration
 }
 
 // Agent routes job requests to jobs, and runs the jobs with the request
 type Agent struct {
 	*quantum.ConnConfig
 
-	port  string
-	done  chan struct{}
-	sigCh chan os.Signal
+	listen      string
+	listener    net.Listener
+	name        string
+	labels      quantum.Labels
+	concurrency int32
+	active      int32
+	done        chan struct{}
+	sigCh       chan os.Signal
+
+	workspaces      *quantum.Workspaces
+	collectInterval time.Duration
 
 	quantum.Registry
 	registrator quantum.Registrator
 }
 
-// New creates a new Agent with the specified port
+// New creates a new Agent
 func New(config *Config) quantum.Agent {
 	if config == nil {
 		config = new(Config)
@@ -58,6 +92,22 @@ func New(config *Config) quantum.Agent {
 		config.Registrator = inmemory.NewRegistrator()
 	}
 
+	if config.Listen == "" {
+		config.Listen = config.Port
+	}
+
+	if config.Name == "" {
+		config.Name, _ = os.Hostname()
+	}
+
+	if config.Workspaces == nil {
+		config.Workspaces = quantum.NewWorkspaces("")
+	}
+
+	if config.CollectInterval == 0 {
+		config.CollectInterval = quantum.DefaultWorkspaceCollectInterval
+	}
+
 	if config.Timeout == 0 {
 		config.Timeout = 100 * time.Millisecond
 	}
@@ -68,9 +118,16 @@ func New(config *Config) quantum.Agent {
 		Registry:    config.Registry,
 		registrator: config.Registrator,
 
-		port:  config.Port,
-		done:  make(chan struct{}),
-		sigCh: make(chan os.Signal, 1),
+		listen:      config.Listen,
+		listener:    config.Listener,
+		name:        config.Name,
+		labels:      config.Labels,
+		concurrency: int32(config.Concurrency),
+		done:        make(chan struct{}),
+		sigCh:       make(chan os.Signal, 1),
+
+		workspaces:      config.Workspaces,
+		collectInterval: config.CollectInterval,
 	}
 }
 
@@ -81,13 +138,57 @@ func (a *Agent) Accept(ln net.Listener) error {
 		return err
 	}
 
-	conn, err := NewConn(netConn, a.ConnConfig)
+	// Handshakes wait for the client, so they don't block accepting
+	go a.serve(netConn)
+	return nil
+}
+
+// serve negotiates with the client on netConn, then serves its requests
+func (a *Agent) serve(netConn net.Conn) {
+	conn, err := Negotiate(netConn, a.ConnConfig, a.name)
 	if err != nil {
 		a.Lager.Errorf("Error creating agent conn: %s", err)
-		return err
+		netConn.Close()
+		return
+	}
+	conn.Workspaces = a.workspaces
+	conn.limiter = a
+
+	conn.Serve(a)
+}
+
+// acquire counts a job, failing with ErrAtCapacity while the agent is
+// running Config.Concurrency jobs
+func (a *Agent) acquire() error {
+	for {
+		active := atomic.LoadInt32(&a.active)
+		if a.concurrency > 0 && active >= a.concurrency {
+			return ErrAtCapacity
+		}
+		if atomic.CompareAndSwapInt32(&a.active, active, active+1) {
+			return nil
+		}
+	}
+}
+
+// release uncounts a completed job
+func (a *Agent) release() {
+	atomic.AddInt32(&a.active, -1)
+}
+
+// Healthy returns ErrDraining once the agent is shutting down, and
+// ErrAtCapacity while it's running Config.Concurrency jobs.
+func (a *Agent) Healthy() error {
+	select {
+	case <-a.done:
+		return ErrDraining
+	default:
+	}
+
+	if a.concurrency > 0 && atomic.LoadInt32(&a.active) >= a.concurrency {
+		return ErrAtCapacity
 	}
 
-	go conn.Serve(a)
 	return nil
 }
 
@@ -102,7 +203,8 @@ func (a *Agent) Close() error {
 	return a.registrator.Deregister()
 }
 
-// Start starts the agent by setting up the signal listener and listening on port.
+// Start starts the agent by setting up the signal listener, listening, and
+// registering the address it's bound to.
 func (a *Agent) Start() error {
 	// Listen for signals, wire up done
 
@@ -123,18 +225,54 @@ func (a *Agent) Start() error {
 		}
 	}()
 
+	ln := a.listener
+	if ln == nil {
+		var err error
+		if ln, err = quantum.Listen(a.listen); err != nil {
+			a.Lager.Errorf("Failed to listen on %s: %s\n", a.listen, err)
+			return err
+		}
+	}
+	a.Lager.Infof("Listening on %s", ln.Addr())
+
 	a.Lager.Debugf("Registering")
-	if err := a.registrator.Register(NewPort(a.port).Int(), a); err != nil {
+	if lr, ok := a.registrator.(quantum.LabeledRegistrator); ok && a.labels != nil {
+		lr.SetLabels(a.labels)
+	}
+	if err := a.registrator.Register(ln.Addr(), a); err != nil {
 		a.Lager.Errorf("Failed to announce services: %s\n", err)
+		ln.Close()
 		return err
 	}
 
+	go a.collect()
+
 	// Blocks
-	a.Lager.Debugf("ListenAndServe block")
-	return quantum.ListenAndServe(a, a.port, a.Lager)
+	a.Lager.Debugf("Serve block")
+	return quantum.Serve(a, ln, a.Lager)
+}
+
+// collect collects old workspaces until the agent shuts down
+func (a *Agent) collect() {
+	ticker := time.NewTicker(a.collectInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := a.workspaces.Collect(); err != nil {
+			a.Lager.Errorf("Error collecting workspaces: %s\n", err)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-a.done:
+			return
+		}
+	}
 }
 
-// Port holds a port in the form :XXXX
+// Port holds a port in the form :XXXX.
+//
+// Deprecated: registrators are given the address agents are bound to.
 type Port struct {
 	Value string
 }
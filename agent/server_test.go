@@ -2,8 +2,14 @@ package agent
 
 import (
 	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
 	"strconv"
+	"syscall"
 	"testing"
+	"time"
 
 	"github.com/doubledutch/quantum"
 	"github.com/mitchellh/multistep"
@@ -79,3 +85,100 @@ func TestPort(t *testing.T) {
 		t.Fatal("wrong port int")
 	}
 }
+
+func TestHealthy(t *testing.T) {
+	a := New(&Config{Concurrency: 1}).(*Agent)
+
+	if err := a.Healthy(); err != nil {
+		t.Fatal(err)
+	}
+
+	a.active = 1
+	if err := a.Healthy(); err != ErrAtCapacity {
+		t.Fatal("expected at capacity")
+	}
+
+	close(a.done)
+	if err := a.Healthy(); err != ErrDraining {
+		t.Fatal("expected draining")
+	}
+}
+
+// testRegistrator records the addresses agents register
+type testRegistrator struct {
+	addrs chan net.Addr
+}
+
+func (r *testRegistrator) Register(addr net.Addr, reg quantum.Registry) error {
+	r.addrs <- addr
+	return nil
+}
+
+func (r *testRegistrator) Deregister() error {
+	return nil
+}
+
+func TestStart(t *testing.T) {
+	dir, err := ioutil.TempDir("", "quantum-agent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		config  *Config
+		network string
+	}{
+		{&Config{Listen: "tcp://127.0.0.1:0"}, "tcp"},
+		{&Config{Listen: "unix:" + filepath.Join(dir, "agent.sock")}, "unix"},
+		{&Config{Listener: ln}, "tcp"},
+	}
+
+	for _, test := range tests {
+		registrator := &testRegistrator{addrs: make(chan net.Addr, 1)}
+		test.config.Registrator = registrator
+		a := New(test.config).(*Agent)
+
+		errCh := make(chan error, 1)
+		go func() {
+			errCh <- a.Start()
+		}()
+
+		var addr net.Addr
+		select {
+		case addr = <-registrator.addrs:
+		case err := <-errCh:
+			t.Fatalf("%s: %v", test.network, err)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s: agent didn't register", test.network)
+		}
+
+		if addr.Network() != test.network {
+			t.Fatalf("expected %s address, got %s", test.network, addr)
+		}
+		if test.config.Listener != nil && addr.String() != ln.Addr().String() {
+			t.Fatalf("expected listener address %s, got %s", ln.Addr(), addr)
+		}
+		if port, err := quantum.TCPPort(addr); test.network == "tcp" && (err != nil || port == 0) {
+			t.Fatalf("expected bound port, got %d %v", port, err)
+		}
+
+		// The registered address reaches the agent
+		network, address := quantum.SplitNetwork(quantum.DialAddress(addr, "127.0.0.1"))
+		conn, err := net.Dial(network, address)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.Close()
+
+		a.sigCh <- syscall.SIGINT
+		if err := <-errCh; err != nil {
+			t.Fatal(err)
+		}
+	}
+}
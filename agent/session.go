@@ -0,0 +1,252 @@
+package agent
+
+import (
+	"os"
+	"sync"
+
+	"github.com/doubledutch/lager"
+	"github.com/doubledutch/mux"
+	"github.com/doubledutch/quantum"
+)
+
+// serveSession runs the job of each stream the client opens, until the
+// connection shuts down. envelope is the first message of the session.
+func (conn *Conn) serveSession(reg quantum.Registry, envelope quantum.Envelope) error {
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	streams := make(map[uint64]*stream)
+
+	defer func() {
+		// Running jobs see their connection shut down, like jobs of
+		// single job connections
+		mu.Lock()
+		for _, s := range streams {
+			s.close()
+		}
+		mu.Unlock()
+		wg.Wait()
+	}()
+
+	for ok := true; ok; envelope, ok = <-conn.EnvelopeCh {
+		mu.Lock()
+		s := streams[envelope.Stream]
+		mu.Unlock()
+
+		if s != nil {
+			// Waits for the stream to catch up when it's backlogged
+			if !s.queue.Push(envelope, conn.IsShutdown()) {
+				conn.lgr.Debugf("Dropping message for closed stream: %d\n", envelope.Stream)
+			}
+			continue
+		}
+
+		if envelope.Type != quantum.RequestType {
+			conn.lgr.Debugf("Dropping message for unknown stream: %d\n", envelope.Stream)
+			continue
+		}
+
+		var request quantum.Request
+		if err := envelope.Decode(conn.Pool(), &request); err != nil {
+			conn.lgr.Errorf("Error decoding request: %s\n", err)
+			continue
+		}
+
+		s = newStream(conn, envelope.Stream)
+		mu.Lock()
+		streams[s.id] = s
+		mu.Unlock()
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := conn.runJob(reg, request, s, &s.workspace)
+
+			mu.Lock()
+			delete(streams, s.id)
+			mu.Unlock()
+			s.Done(err)
+		}()
+	}
+
+	return nil
+}
+
+// stream is the quantum.AgentConn of a job run within a session
+type stream struct {
+	conn *Conn
+	id   uint64
+
+	outCh    chan string
+	sigCh    chan os.Signal
+	stdinCh  chan []byte
+	resizeCh chan quantum.WindowSize
+	fileCh   chan quantum.FileChunk
+
+	queue     *quantum.EnvelopeQueue
+	workspace string
+	shutdown  chan struct{}
+	closeOnce sync.Once
+}
+
+func newStream(conn *Conn, id uint64) *stream {
+	s := &stream{
+		conn:     conn,
+		id:       id,
+		outCh:    make(chan string, 1),
+		sigCh:    make(chan os.Signal, 1),
+		stdinCh:  make(chan []byte, 1),
+		resizeCh: make(chan quantum.WindowSize, 1),
+		fileCh:   make(chan quantum.FileChunk, 1),
+		queue:    quantum.NewEnvelopeQueue(quantum.DefaultStreamBacklog),
+		shutdown: make(chan struct{}),
+	}
+	go s.deliver()
+	return s
+}
+
+// deliver delivers the queued messages of the stream until it's stopped,
+// then closes its signals, like the signals of single job connections
+func (s *stream) deliver() {
+	defer close(s.sigCh)
+	for {
+		envelope, ok := s.queue.Pop()
+		if !ok {
+			return
+		}
+		s.receive(envelope)
+	}
+}
+
+// receive routes a message from the client to the job
+func (s *stream) receive(envelope quantum.Envelope) {
+	pool := s.conn.Pool()
+
+	var err error
+	switch envelope.Type {
+	case mux.SignalType:
+		var sig os.Signal
+		if sig, err = envelope.DecodeSignal(pool); err == nil {
+			select {
+			case s.sigCh <- sig:
+			case <-s.shutdown:
+			}
+		}
+	case quantum.StdinType:
+		var data []byte
+		if err = envelope.Decode(pool, &data); err == nil {
+			select {
+			case s.stdinCh <- data:
+			case <-s.shutdown:
+			}
+		}
+	case quantum.WindowSizeType:
+		var size quantum.WindowSize
+		if err = envelope.Decode(pool, &size); err == nil {
+			select {
+			case s.resizeCh <- size:
+			case <-s.shutdown:
+			}
+		}
+	case quantum.FileChunkType:
+		var chunk quantum.FileChunk
+		if err = envelope.Decode(pool, &chunk); err == nil {
+			select {
+			case s.fileCh <- chunk:
+			case <-s.shutdown:
+			}
+		}
+	}
+
+	if err != nil {
+		s.conn.lgr.Errorf("Error decoding message for stream %d: %s\n", s.id, err)
+	}
+}
+
+// close shuts down the stream once the job completes or the connection
+// shuts down
+func (s *stream) close() {
+	s.closeOnce.Do(func() {
+		close(s.shutdown)
+		s.queue.Close()
+	})
+}
+
+// Send sends v to the client within the stream, failing with
+// quantum.ErrCapabilityUnsupported when the client doesn't support
+// messages of type t
+func (s *stream) Send(t uint8, v interface{}) error {
+	if err := s.conn.handshake.Allows(t); err != nil {
+		return err
+	}
+
+	envelope, err := quantum.NewEnvelope(s.conn.Pool(), s.id, t, v)
+	if err != nil {
+		return err
+	}
+	return s.conn.Send(quantum.EnvelopeType, envelope)
+}
+
+// Receive is a no-op, the session routes messages to streams
+func (s *stream) Receive(t uint8, r mux.Receiver) {}
+
+// Recv is a no-op, the session receives for its streams
+func (s *stream) Recv() {}
+
+// IsShutdown returns a chan closed once the job completes or the
+// connection shuts down
+func (s *stream) IsShutdown() chan struct{} {
+	return s.shutdown
+}
+
+// Pool returns the Pool of the connection
+func (s *stream) Pool() mux.Pool {
+	return s.conn.Pool()
+}
+
+// Done sends the result of the job to the client, completing the stream
+func (s *stream) Done(err error) {
+	if sendErr := s.Send(quantum.StreamDoneType, quantum.NewStreamResult(err)); sendErr != nil {
+		s.conn.lgr.Errorf("Error sending result: %s\n", sendErr)
+	}
+	s.close()
+}
+
+// Logs returns the logs channel of the stream
+func (s *stream) Logs() chan string {
+	return s.outCh
+}
+
+// Signals returns the signals channel of the stream
+func (s *stream) Signals() chan os.Signal {
+	return s.sigCh
+}
+
+// Stdin returns the stdin channel of the stream
+func (s *stream) Stdin() chan []byte {
+	return s.stdinCh
+}
+
+// WindowSizes returns the window size channel of the stream
+func (s *stream) WindowSizes() chan quantum.WindowSize {
+	return s.resizeCh
+}
+
+// Files returns the channel of files uploaded to the stream
+func (s *stream) Files() chan quantum.FileChunk {
+	return s.fileCh
+}
+
+// Workspace returns the workspace allocated to the job of the stream
+func (s *stream) Workspace() string {
+	return s.workspace
+}
+
+// Handshake returns the handshake of the connection
+func (s *stream) Handshake() *quantum.Handshake {
+	return s.conn.handshake
+}
+
+// Lager returns the Lager of the connection
+func (s *stream) Lager() lager.Lager {
+	return s.conn.lgr
+}
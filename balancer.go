@@ -0,0 +1,324 @@
+package quantum
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-multierror"
+)
+
+// BalanceStrategy names a Balancer implementation
+type BalanceStrategy string
+
+const (
+	// FirstStrategy dials candidates in the order they were resolved
+	FirstStrategy = BalanceStrategy("first")
+	// RoundRobinStrategy rotates through candidates
+	RoundRobinStrategy = BalanceStrategy("round-robin")
+	// RandomStrategy dials candidates in a random order
+	RandomStrategy = BalanceStrategy("random")
+	// LeastOutstandingStrategy prefers candidates running the fewest jobs
+	LeastOutstandingStrategy = BalanceStrategy("least-outstanding")
+	// ConsistentHashStrategy maps ResolveRequest.Key to the same candidate
+	ConsistentHashStrategy = BalanceStrategy("consistent-hash")
+
+	// hashReplicas is the number of points each candidate has on the hash ring
+	hashReplicas = 64
+)
+
+// Candidate is the address of an agent that can serve a ResolveRequest
+type Candidate struct {
+	Address string
+	Agent   string
+}
+
+// CandidateResolver resolves every candidate of a ResolveRequest, without
+// dialing them.
+type CandidateResolver interface {
+	Candidates(request ResolveRequest) ([]Candidate, error)
+}
+
+// Balancer orders candidates by preference
+type Balancer interface {
+	Balance(request ResolveRequest, candidates []Candidate) []Candidate
+}
+
+// Tracker is implemented by Balancers that track outstanding jobs.
+// Start is called when a candidate is dialed, Done when its ClientConn closes.
+type Tracker interface {
+	Start(address string)
+	Done(address string)
+}
+
+// NewBalancer creates the Balancer for strategy. The empty strategy
+// is FirstStrategy.
+func NewBalancer(strategy BalanceStrategy) (Balancer, error) {
+	switch strategy {
+	case "", FirstStrategy:
+		return new(FirstBalancer), nil
+	case RoundRobinStrategy:
+		return new(RoundRobinBalancer), nil
+	case RandomStrategy:
+		return NewRandomBalancer(), nil
+	case LeastOutstandingStrategy:
+		return NewLeastOutstandingBalancer(), nil
+	case ConsistentHashStrategy:
+		return new(ConsistentHashBalancer), nil
+	}
+
+	return nil, fmt.Errorf("unknown balance strategy: %s", strategy)
+}
+
+// FirstBalancer keeps candidates in the order they were resolved
+type FirstBalancer struct{}
+
+// Balance returns candidates unchanged
+func (b *FirstBalancer) Balance(request ResolveRequest, candidates []Candidate) []Candidate {
+	return candidates
+}
+
+// RoundRobinBalancer starts each Balance at the next candidate
+type RoundRobinBalancer struct {
+	mu   sync.Mutex
+	next int
+}
+
+// Balance rotates candidates
+func (b *RoundRobinBalancer) Balance(request ResolveRequest, candidates []Candidate) []Candidate {
+	if len(candidates) == 0 {
+		return candidates
+	}
+
+	b.mu.Lock()
+	start := b.next % len(candidates)
+	b.next++
+	b.mu.Unlock()
+
+	return append(candidates[start:len(candidates):len(candidates)], candidates[:start]...)
+}
+
+// RandomBalancer shuffles candidates
+type RandomBalancer struct {
+	mu  sync.Mutex
+	rnd *rand.Rand
+}
+
+// NewRandomBalancer creates a RandomBalancer
+func NewRandomBalancer() *RandomBalancer {
+	return &RandomBalancer{
+		rnd: rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// Balance shuffles candidates
+func (b *RandomBalancer) Balance(request ResolveRequest, candidates []Candidate) []Candidate {
+	b.mu.Lock()
+	perm := b.rnd.Perm(len(candidates))
+	b.mu.Unlock()
+
+	balanced := make([]Candidate, len(candidates))
+	for i, j := range perm {
+		balanced[i] = candidates[j]
+	}
+	return balanced
+}
+
+// LeastOutstandingBalancer orders candidates by the number of jobs they're
+// running through this balancer.
+type LeastOutstandingBalancer struct {
+	mu          sync.Mutex
+	outstanding map[string]int
+}
+
+// NewLeastOutstandingBalancer creates a LeastOutstandingBalancer
+func NewLeastOutstandingBalancer() *LeastOutstandingBalancer {
+	return &LeastOutstandingBalancer{
+		outstanding: make(map[string]int),
+	}
+}
+
+// Balance sorts candidates by outstanding jobs, keeping resolved order for ties
+func (b *LeastOutstandingBalancer) Balance(request ResolveRequest, candidates []Candidate) []Candidate {
+	balanced := make([]Candidate, len(candidates))
+	copy(balanced, candidates)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	sort.SliceStable(balanced, func(i, j int) bool {
+		return b.outstanding[balanced[i].Address] < b.outstanding[balanced[j].Address]
+	})
+	return balanced
+}
+
+// Start records an outstanding job on address
+func (b *LeastOutstandingBalancer) Start(address string) {
+	b.mu.Lock()
+	b.outstanding[address]++
+	b.mu.Unlock()
+}
+
+// Done records a finished job on address
+func (b *LeastOutstandingBalancer) Done(address string) {
+	b.mu.Lock()
+	if b.outstanding[address] <= 1 {
+		delete(b.outstanding, address)
+	} else {
+		b.outstanding[address]--
+	}
+	b.mu.Unlock()
+}
+
+// Outstanding returns the number of outstanding jobs on address
+func (b *LeastOutstandingBalancer) Outstanding(address string) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.outstanding[address]
+}
+
+// ConsistentHashBalancer places candidates on a hash ring and orders them by
+// walking the ring from the hash of ResolveRequest.Key, so the same key
+// prefers the same candidate while the candidate set is stable.
+type ConsistentHashBalancer struct{}
+
+type ringPoint struct {
+	hash  uint32
+	index int
+}
+
+// Balance orders candidates starting at the ring position of request.Key
+func (b *ConsistentHashBalancer) Balance(request ResolveRequest, candidates []Candidate) []Candidate {
+	ring := make([]ringPoint, 0, len(candidates)*hashReplicas)
+	for i, candidate := range candidates {
+		for r := 0; r < hashReplicas; r++ {
+			ring = append(ring, ringPoint{
+				hash:  hash(candidate.Address + "#" + strconv.Itoa(r)),
+				index: i,
+			})
+		}
+	}
+	sort.Slice(ring, func(i, j int) bool { return ring[i].hash < ring[j].hash })
+
+	key := hash(request.Key)
+	start := sort.Search(len(ring), func(i int) bool { return ring[i].hash >= key })
+
+	balanced := make([]Candidate, 0, len(candidates))
+	seen := make(map[int]bool, len(candidates))
+	for i := 0; i < len(ring) && len(balanced) < len(candidates); i++ {
+		point := ring[(start+i)%len(ring)]
+		if seen[point.index] {
+			continue
+		}
+		seen[point.index] = true
+		balanced = append(balanced, candidates[point.index])
+	}
+	return balanced
+}
+
+func hash(s string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(s))
+	return h.Sum32()
+}
+
+// BalancedClientResolver implements ClientResolver by balancing the
+// candidates of a CandidateResolver.
+type BalancedClientResolver struct {
+	Resolver CandidateResolver
+	Balancer Balancer
+	Client   Client
+	Timeout  time.Duration
+}
+
+// NewBalancedClientResolver creates a BalancedClientResolver using the
+// strategy of config.
+func NewBalancedClientResolver(resolver CandidateResolver, client Client, config *ClientResolverConfig) (*BalancedClientResolver, error) {
+	if config == nil {
+		config = new(ClientResolverConfig)
+	}
+
+	balancer, err := NewBalancer(config.Strategy)
+	if err != nil {
+		return nil, err
+	}
+
+	return &BalancedClientResolver{
+		Resolver: resolver,
+		Balancer: balancer,
+		Client:   client,
+		Timeout:  config.DialTimeout,
+	}, nil
+}
+
+// Resolve dials the candidates of request in balanced order, returning the
+// first ClientConn to connect.
+func (r *BalancedClientResolver) Resolve(request ResolveRequest) (ClientConn, error) {
+	candidates, err := r.Resolver.Candidates(request)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(candidates) == 0 {
+		return nil, NoAgentsFromRequest(request)
+	}
+
+	var result error
+	for _, candidate := range r.Balancer.Balance(request, candidates) {
+		conn, err := r.dial(candidate.Address)
+		if err != nil {
+			result = multierror.Append(result, fmt.Errorf("%s: %s", candidate.Address, err))
+			continue
+		}
+
+		tracker, ok := r.Balancer.(Tracker)
+		if !ok {
+			return conn, nil
+		}
+
+		tracker.Start(candidate.Address)
+		return &trackedConn{
+			ClientConn: conn,
+			address:    candidate.Address,
+			tracker:    tracker,
+		}, nil
+	}
+
+	return nil, result
+}
+
+func (r *BalancedClientResolver) dial(address string) (ClientConn, error) {
+	if r.Timeout == 0 {
+		return r.Client.Dial(address)
+	}
+
+	return r.Client.DialTimeout(address, r.Timeout)
+}
+
+// trackedConn notifies a Tracker once its job is done
+type trackedConn struct {
+	ClientConn
+
+	address string
+	tracker Tracker
+	once    sync.Once
+}
+
+func (c *trackedConn) Run(request Request) error {
+	defer c.done()
+	return c.ClientConn.Run(request)
+}
+
+func (c *trackedConn) Close() error {
+	defer c.done()
+	return c.ClientConn.Close()
+}
+
+func (c *trackedConn) done() {
+	c.once.Do(func() {
+		c.tracker.Done(c.address)
+	})
+}
@@ -0,0 +1,148 @@
+package quantum
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+type testCandidateResolver []Candidate
+
+func (r testCandidateResolver) Candidates(request ResolveRequest) ([]Candidate, error) {
+	return r, nil
+}
+
+func testCandidates(n int) []Candidate {
+	candidates := make([]Candidate, n)
+	for i := range candidates {
+		candidates[i] = Candidate{Address: "agent" + strconv.Itoa(i)}
+	}
+	return candidates
+}
+
+func TestNewBalancer(t *testing.T) {
+	strategies := []BalanceStrategy{"", FirstStrategy, RoundRobinStrategy,
+		RandomStrategy, LeastOutstandingStrategy, ConsistentHashStrategy}
+	for _, strategy := range strategies {
+		if _, err := NewBalancer(strategy); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := NewBalancer("unknown"); err == nil {
+		t.Fatal("expected unknown strategy error")
+	}
+}
+
+func TestRoundRobinBalancer(t *testing.T) {
+	b := new(RoundRobinBalancer)
+	candidates := testCandidates(3)
+
+	for i := 0; i < 6; i++ {
+		balanced := b.Balance(ResolveRequest{}, candidates)
+		if len(balanced) != 3 {
+			t.Fatal("expected 3 candidates")
+		}
+		if balanced[0] != candidates[i%3] {
+			t.Fatalf("expected %s first, got %s", candidates[i%3].Address, balanced[0].Address)
+		}
+	}
+}
+
+func TestRandomBalancer(t *testing.T) {
+	balanced := NewRandomBalancer().Balance(ResolveRequest{}, testCandidates(5))
+
+	seen := make(map[string]bool)
+	for _, candidate := range balanced {
+		seen[candidate.Address] = true
+	}
+	if len(seen) != 5 {
+		t.Fatal("expected every candidate once")
+	}
+}
+
+func TestLeastOutstandingBalancer(t *testing.T) {
+	b := NewLeastOutstandingBalancer()
+	candidates := testCandidates(3)
+
+	b.Start("agent0")
+	b.Start("agent0")
+	b.Start("agent1")
+
+	balanced := b.Balance(ResolveRequest{}, candidates)
+	if balanced[0].Address != "agent2" || balanced[1].Address != "agent1" || balanced[2].Address != "agent0" {
+		t.Fatalf("wrong order: %v", balanced)
+	}
+
+	b.Done("agent0")
+	b.Done("agent0")
+	if b.Outstanding("agent0") != 0 {
+		t.Fatal("expected no outstanding jobs")
+	}
+}
+
+func TestConsistentHashBalancer(t *testing.T) {
+	b := new(ConsistentHashBalancer)
+	candidates := testCandidates(5)
+
+	first := b.Balance(ResolveRequest{Key: "build-42"}, candidates)
+	if len(first) != 5 {
+		t.Fatal("expected 5 candidates")
+	}
+
+	for i := 0; i < 10; i++ {
+		if b.Balance(ResolveRequest{Key: "build-42"}, candidates)[0] != first[0] {
+			t.Fatal("expected the same candidate for the same key")
+		}
+	}
+
+	// Removing another candidate keeps the key on its candidate
+	var remaining []Candidate
+	for _, candidate := range candidates {
+		if candidate != first[1] {
+			remaining = append(remaining, candidate)
+		}
+	}
+	if b.Balance(ResolveRequest{Key: "build-42"}, remaining)[0] != first[0] {
+		t.Fatal("expected the key to stay on its candidate")
+	}
+}
+
+func TestBalancedClientResolver(t *testing.T) {
+	client := &testClient{
+		delays: map[string]time.Duration{"agent1": 0},
+	}
+
+	r, err := NewBalancedClientResolver(testCandidateResolver(testCandidates(2)), client, &ClientResolverConfig{
+		Strategy: LeastOutstandingStrategy,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := r.Resolve(ResolveRequest{Type: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b := r.Balancer.(*LeastOutstandingBalancer)
+	if b.Outstanding("agent1") != 1 {
+		t.Fatal("expected an outstanding job")
+	}
+
+	conn.Run(Request{})
+	if b.Outstanding("agent1") != 0 {
+		t.Fatal("expected no outstanding jobs")
+	}
+}
+
+func TestBalancedClientResolverNoAgents(t *testing.T) {
+	r, err := NewBalancedClientResolver(testCandidateResolver(nil), &testClient{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := r.Resolve(ResolveRequest{Type: "test"}); !IsNoAgentsErr(err) {
+		t.Fatal("expected no agents error")
+	}
+}
@@ -0,0 +1,169 @@
+package quantum
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-multierror"
+)
+
+// DefaultBroadcastParallelism is the default number of agents a Broadcaster
+// runs a request on at once
+const DefaultBroadcastParallelism = 8
+
+// AgentLog is a log of the agent identified by Agent
+type AgentLog struct {
+	Agent string
+	Log   string
+}
+
+// BroadcastResults maps the identity of each agent to the error of its run
+type BroadcastResults map[string]error
+
+// Err returns the errors of failed runs, or nil if every run succeeded
+func (r BroadcastResults) Err() error {
+	agents := make([]string, 0, len(r))
+	for agent, err := range r {
+		if err != nil {
+			agents = append(agents, agent)
+		}
+	}
+	sort.Strings(agents)
+
+	var result error
+	for _, agent := range agents {
+		result = multierror.Append(result, fmt.Errorf("%s: %w", agent, r[agent]))
+	}
+	return result
+}
+
+// Broadcaster runs a request on every agent resolved for a ResolveRequest
+type Broadcaster struct {
+	Resolver CandidateResolver
+	Client   Client
+
+	// Parallelism limits the agents running the request at once
+	Parallelism int
+	// DialTimeout is the timeout of each dial
+	DialTimeout time.Duration
+	// Logs receives the logs of every agent, discarded if nil
+	Logs chan<- AgentLog
+}
+
+// NewBroadcaster creates a Broadcaster with default parallelism
+func NewBroadcaster(resolver CandidateResolver, client Client) *Broadcaster {
+	return &Broadcaster{
+		Resolver:    resolver,
+		Client:      client,
+		Parallelism: DefaultBroadcastParallelism,
+	}
+}
+
+// Broadcast runs request on every candidate of resolve. Runs are identified
+// by agent name and address, as agent@address, or by address for unnamed
+// agents. The returned error is only set when no agent could be resolved.
+func (b *Broadcaster) Broadcast(resolve ResolveRequest, request Request) (BroadcastResults, error) {
+	candidates, err := b.Resolver.Candidates(resolve)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(candidates) == 0 {
+		return nil, NoAgentsFromRequest(resolve)
+	}
+
+	parallelism := b.Parallelism
+	if parallelism <= 0 {
+		parallelism = DefaultBroadcastParallelism
+	}
+
+	var mu sync.Mutex
+	results := make(BroadcastResults, len(candidates))
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, parallelism)
+	for _, candidate := range candidates {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(candidate Candidate) {
+			defer wg.Done()
+			err := b.run(candidate, request)
+			<-sem
+
+			mu.Lock()
+			results[candidateID(candidate)] = err
+			mu.Unlock()
+		}(candidate)
+	}
+	wg.Wait()
+
+	return results, nil
+}
+
+// run runs request on candidate, forwarding its logs
+func (b *Broadcaster) run(candidate Candidate, request Request) error {
+	var conn ClientConn
+	var err error
+	if b.DialTimeout == 0 {
+		conn, err = b.Client.Dial(candidate.Address)
+	} else {
+		conn, err = b.Client.DialTimeout(candidate.Address, b.DialTimeout)
+	}
+	if err != nil {
+		return err
+	}
+
+	id := candidateID(candidate)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case log, ok := <-conn.Logs():
+				if !ok {
+					return
+				}
+				b.log(id, log)
+			case <-conn.IsShutdown():
+				b.drainLogs(id, conn)
+				return
+			}
+		}
+	}()
+
+	err = conn.Run(request)
+	<-done
+	return err
+}
+
+// drainLogs forwards the logs buffered in conn after shutdown
+func (b *Broadcaster) drainLogs(id string, conn ClientConn) {
+	for {
+		select {
+		case log, ok := <-conn.Logs():
+			if !ok {
+				return
+			}
+			b.log(id, log)
+		default:
+			return
+		}
+	}
+}
+
+func (b *Broadcaster) log(id, log string) {
+	if b.Logs != nil {
+		b.Logs <- AgentLog{Agent: id, Log: log}
+	}
+}
+
+// candidateID identifies candidate by name and address, or address if
+// unnamed. Agents may have a candidate for each of their addresses.
+func candidateID(candidate Candidate) string {
+	if candidate.Agent != "" {
+		return candidate.Agent + "@" + candidate.Address
+	}
+	return candidate.Address
+}
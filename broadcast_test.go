@@ -0,0 +1,168 @@
+package quantum
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/doubledutch/mux"
+)
+
+// broadcastConn logs its address and returns err when run
+type broadcastConn struct {
+	mux.Client
+	client   *broadcastClient
+	address  string
+	logCh    chan string
+	shutdown chan struct{}
+}
+
+func (c *broadcastConn) Run(request Request) error {
+	active := atomic.AddInt32(&c.client.active, 1)
+	for {
+		peak := atomic.LoadInt32(&c.client.peak)
+		if active <= peak || atomic.CompareAndSwapInt32(&c.client.peak, peak, active) {
+			break
+		}
+	}
+
+	c.logCh <- "hello from " + c.address
+	time.Sleep(10 * time.Millisecond)
+
+	atomic.AddInt32(&c.client.active, -1)
+	close(c.shutdown)
+	return c.client.errs[c.address]
+}
+
+func (c *broadcastConn) Logs() <-chan string {
+	return c.logCh
+}
+
+func (c *broadcastConn) Signals() chan<- os.Signal {
+	return nil
+}
+
+func (c *broadcastConn) Stdin() chan<- []byte {
+	return nil
+}
+
+func (c *broadcastConn) WindowSizes() chan<- WindowSize {
+	return nil
+}
+
+func (c *broadcastConn) Uploads() chan<- FileChunk {
+	return nil
+}
+
+func (c *broadcastConn) Files() <-chan FileChunk {
+	return nil
+}
+
+func (c *broadcastConn) Handshake() *Handshake {
+	return nil
+}
+
+func (c *broadcastConn) IsShutdown() chan struct{} {
+	return c.shutdown
+}
+
+func (c *broadcastConn) Close() error {
+	return nil
+}
+
+type broadcastClient struct {
+	errs   map[string]error
+	active int32
+	peak   int32
+}
+
+func (c *broadcastClient) Dial(address string) (ClientConn, error) {
+	return &broadcastConn{
+		client:   c,
+		address:  address,
+		logCh:    make(chan string, 1),
+		shutdown: make(chan struct{}),
+	}, nil
+}
+
+func (c *broadcastClient) DialTimeout(address string, timeout time.Duration) (ClientConn, error) {
+	return c.Dial(address)
+}
+
+func TestBroadcast(t *testing.T) {
+	candidates := testCandidates(10)
+	client := &broadcastClient{
+		errs: map[string]error{
+			candidates[3].Address: errors.New("failed"),
+		},
+	}
+
+	logCh := make(chan AgentLog, len(candidates))
+	b := NewBroadcaster(testCandidateResolver(candidates), client)
+	b.Parallelism = 2
+	b.Logs = logCh
+
+	results, err := b.Broadcast(ResolveRequest{Type: "test"}, NewRequest("test", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(results) != len(candidates) {
+		t.Fatalf("expected %d results, got %d", len(candidates), len(results))
+	}
+	if results[candidates[3].Address] == nil {
+		t.Fatal("expected failed agent to have an error")
+	}
+	if err := results.Err(); err == nil || !strings.Contains(err.Error(), candidates[3].Address+": failed") {
+		t.Fatalf("expected failure of %s, got %v", candidates[3].Address, err)
+	}
+
+	if peak := atomic.LoadInt32(&client.peak); peak > 2 {
+		t.Fatalf("expected at most 2 concurrent runs, got %d", peak)
+	}
+
+	close(logCh)
+	// Unnamed agents are identified by address
+	seen := make(map[string]bool)
+	for log := range logCh {
+		seen[log.Agent] = true
+	}
+	if len(seen) != len(candidates) {
+		t.Fatalf("expected logs of %d agents, got %d", len(candidates), len(seen))
+	}
+}
+
+func TestBroadcastNoAgents(t *testing.T) {
+	b := NewBroadcaster(testCandidateResolver(nil), &broadcastClient{})
+	if _, err := b.Broadcast(ResolveRequest{Type: "test"}, NewRequest("test", "")); !IsNoAgentsErr(err) {
+		t.Fatalf("expected no agents err, got %v", err)
+	}
+}
+
+func TestBroadcastAgentAddresses(t *testing.T) {
+	candidates := []Candidate{
+		{Agent: "agent", Address: "10.0.0.1:8000"},
+		{Agent: "agent", Address: "10.0.0.1:8001"},
+	}
+	client := &broadcastClient{
+		errs: map[string]error{
+			candidates[1].Address: errors.New("failed"),
+		},
+	}
+
+	b := NewBroadcaster(testCandidateResolver(candidates), client)
+	results, err := b.Broadcast(ResolveRequest{Type: "test"}, NewRequest("test", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(results) != 2 {
+		t.Fatalf("expected a result for each address, got %v", results)
+	}
+	if err := results.Err(); err == nil || !strings.Contains(err.Error(), "agent@10.0.0.1:8001: failed") {
+		t.Fatalf("expected failure of agent@10.0.0.1:8001, got %v", err)
+	}
+}
@@ -0,0 +1,221 @@
+package quantum
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hashicorp/go-multierror"
+)
+
+const (
+	// DefaultCacheTTL is the default time candidates are cached
+	DefaultCacheTTL = 30 * time.Second
+	// DefaultNegativeCacheTTL is the default time empty resolutions are cached
+	DefaultNegativeCacheTTL = 5 * time.Second
+)
+
+// CacheStats are the counters of a CachingClientResolver
+type CacheStats struct {
+	Hits   uint64
+	Misses uint64
+}
+
+// CachingClientResolver decorates a CandidateResolver, caching the
+// candidates of each ResolveRequest. Resolutions without candidates are
+// cached for NegativeTTL. Addresses that fail to dial are removed from
+// the cache. Concurrent misses of a request share a single resolution.
+type CachingClientResolver struct {
+	// Accessed atomically, first for 64-bit alignment
+	hits   uint64
+	misses uint64
+
+	Resolver CandidateResolver
+	Client   Client
+
+	// TTL is the time candidates are cached
+	TTL time.Duration
+	// NegativeTTL is the time resolutions without candidates are cached
+	NegativeTTL time.Duration
+	// DialTimeout is the timeout of each dial
+	DialTimeout time.Duration
+
+	mu      sync.Mutex
+	entries map[string]*cacheEntry
+	calls   map[string]*cacheCall
+	swept   time.Time
+}
+
+type cacheEntry struct {
+	candidates []Candidate
+	expires    time.Time
+}
+
+// cacheCall is a resolution in progress, done once it completes
+type cacheCall struct {
+	done       chan struct{}
+	candidates []Candidate
+	err        error
+}
+
+// NewCachingClientResolver creates a CachingClientResolver with default TTLs
+func NewCachingClientResolver(resolver CandidateResolver, client Client) *CachingClientResolver {
+	return &CachingClientResolver{
+		Resolver:    resolver,
+		Client:      client,
+		TTL:         DefaultCacheTTL,
+		NegativeTTL: DefaultNegativeCacheTTL,
+		entries:     make(map[string]*cacheEntry),
+		calls:       make(map[string]*cacheCall),
+	}
+}
+
+// Resolve dials the cached candidates of request in order, returning the
+// first ClientConn to connect.
+func (r *CachingClientResolver) Resolve(request ResolveRequest) (ClientConn, error) {
+	candidates, err := r.Candidates(request)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(candidates) == 0 {
+		return nil, NoAgentsFromRequest(request)
+	}
+
+	var result error
+	for _, candidate := range candidates {
+		var conn ClientConn
+		if r.DialTimeout == 0 {
+			conn, err = r.Client.Dial(candidate.Address)
+		} else {
+			conn, err = r.Client.DialTimeout(candidate.Address, r.DialTimeout)
+		}
+
+		if err == nil {
+			return conn, nil
+		}
+
+		result = multierror.Append(result, fmt.Errorf("%s: %s", candidate.Address, err))
+		r.Invalidate(request, candidate.Address)
+	}
+
+	return nil, result
+}
+
+// Candidates returns the cached candidates of request, resolving them with
+// Resolver when they're missing or expired.
+func (r *CachingClientResolver) Candidates(request ResolveRequest) ([]Candidate, error) {
+	key := cacheKey(request)
+	now := time.Now()
+
+	r.mu.Lock()
+	if r.entries == nil {
+		r.entries = make(map[string]*cacheEntry)
+	}
+	if r.calls == nil {
+		r.calls = make(map[string]*cacheCall)
+	}
+	r.sweep(now)
+
+	if entry, ok := r.entries[key]; ok && now.Before(entry.expires) {
+		candidates := entry.candidates
+		r.mu.Unlock()
+		atomic.AddUint64(&r.hits, 1)
+		return candidates, nil
+	}
+
+	atomic.AddUint64(&r.misses, 1)
+	if call, ok := r.calls[key]; ok {
+		r.mu.Unlock()
+		<-call.done
+		return call.candidates, call.err
+	}
+
+	call := &cacheCall{done: make(chan struct{})}
+	r.calls[key] = call
+	r.mu.Unlock()
+
+	call.candidates, call.err = r.Resolver.Candidates(request)
+
+	ttl := r.TTL
+	if len(call.candidates) == 0 {
+		ttl = r.NegativeTTL
+	}
+
+	r.mu.Lock()
+	delete(r.calls, key)
+	// Errors aren't cached, the next request retries
+	if call.err == nil {
+		r.entries[key] = &cacheEntry{
+			candidates: call.candidates,
+			expires:    now.Add(ttl),
+		}
+	}
+	r.mu.Unlock()
+	close(call.done)
+
+	return call.candidates, call.err
+}
+
+// sweep removes expired entries, at most once per TTL. r.mu must be held.
+func (r *CachingClientResolver) sweep(now time.Time) {
+	if now.Sub(r.swept) < r.TTL {
+		return
+	}
+	r.swept = now
+
+	for key, entry := range r.entries {
+		if !now.Before(entry.expires) {
+			delete(r.entries, key)
+		}
+	}
+}
+
+// Invalidate removes address from the cached candidates of request
+func (r *CachingClientResolver) Invalidate(request ResolveRequest, address string) {
+	key := cacheKey(request)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	entry, ok := r.entries[key]
+	if !ok {
+		return
+	}
+
+	remaining := make([]Candidate, 0, len(entry.candidates))
+	for _, candidate := range entry.candidates {
+		if candidate.Address != address {
+			remaining = append(remaining, candidate)
+		}
+	}
+
+	// Resolve again rather than negatively caching our own invalidations
+	if len(remaining) == 0 {
+		delete(r.entries, key)
+		return
+	}
+	entry.candidates = remaining
+}
+
+// Purge removes every cached resolution
+func (r *CachingClientResolver) Purge() {
+	r.mu.Lock()
+	r.entries = make(map[string]*cacheEntry)
+	r.mu.Unlock()
+}
+
+// Stats returns the hit and miss counts of the cache
+func (r *CachingClientResolver) Stats() CacheStats {
+	return CacheStats{
+		Hits:   atomic.LoadUint64(&r.hits),
+		Misses: atomic.LoadUint64(&r.misses),
+	}
+}
+
+// cacheKey identifies the candidates of a ResolveRequest. Key only affects
+// balancing, not which candidates match, so it is not part of the key.
+func cacheKey(request ResolveRequest) string {
+	return request.Type + "\x00" + request.Agent + "\x00" + request.Selector.String()
+}
@@ -0,0 +1,153 @@
+package quantum
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// countingResolver counts resolutions of its candidates
+type countingResolver struct {
+	mu         sync.Mutex
+	candidates []Candidate
+	count      int
+}
+
+func (r *countingResolver) Candidates(request ResolveRequest) ([]Candidate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.count++
+	return r.candidates, nil
+}
+
+func TestCachingClientResolver(t *testing.T) {
+	resolver := &countingResolver{candidates: testCandidates(2)}
+	r := NewCachingClientResolver(resolver, &testClient{})
+
+	for i := 0; i < 3; i++ {
+		candidates, err := r.Candidates(ResolveRequest{Type: "test"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(candidates) != 2 {
+			t.Fatal("expected 2 candidates")
+		}
+	}
+
+	if resolver.count != 1 {
+		t.Fatalf("expected 1 resolution, got %d", resolver.count)
+	}
+
+	if stats := r.Stats(); stats.Hits != 2 || stats.Misses != 1 {
+		t.Fatalf("wrong stats: %+v", stats)
+	}
+
+	// Requests are cached separately
+	r.Candidates(ResolveRequest{Type: "test", Agent: "one"})
+	if resolver.count != 2 {
+		t.Fatal("expected another resolution")
+	}
+}
+
+func TestCachingClientResolverTTL(t *testing.T) {
+	resolver := &countingResolver{}
+	r := NewCachingClientResolver(resolver, &testClient{})
+	r.NegativeTTL = 10 * time.Millisecond
+
+	if _, err := r.Resolve(ResolveRequest{Type: "test"}); !IsNoAgentsErr(err) {
+		t.Fatal("expected no agents error")
+	}
+	r.Resolve(ResolveRequest{Type: "test"})
+	if resolver.count != 1 {
+		t.Fatal("expected negative resolution to be cached")
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	r.Resolve(ResolveRequest{Type: "test"})
+	if resolver.count != 2 {
+		t.Fatal("expected negative resolution to expire")
+	}
+}
+
+func TestCachingClientResolverInvalidate(t *testing.T) {
+	resolver := &countingResolver{candidates: testCandidates(2)}
+	client := &testClient{
+		delays: map[string]time.Duration{"agent1": 0},
+	}
+	r := NewCachingClientResolver(resolver, client)
+
+	// agent0 fails to dial, and is invalidated
+	conn, err := r.Resolve(ResolveRequest{Type: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conn.(*testClientConn).address != "agent1" {
+		t.Fatal("expected agent1")
+	}
+
+	candidates, _ := r.Candidates(ResolveRequest{Type: "test"})
+	if len(candidates) != 1 || candidates[0].Address != "agent1" {
+		t.Fatalf("expected agent0 to be invalidated: %v", candidates)
+	}
+	if resolver.count != 1 {
+		t.Fatal("expected a single resolution")
+	}
+}
+
+func TestCachingClientResolverEvicts(t *testing.T) {
+	resolver := &countingResolver{candidates: testCandidates(1)}
+	r := NewCachingClientResolver(resolver, &testClient{})
+	r.TTL = 10 * time.Millisecond
+
+	r.Candidates(ResolveRequest{Type: "expired"})
+	time.Sleep(20 * time.Millisecond)
+	r.Candidates(ResolveRequest{Type: "test"})
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.entries[cacheKey(ResolveRequest{Type: "expired"})]; ok || len(r.entries) != 1 {
+		t.Fatalf("expected expired entry to be evicted, got %d entries", len(r.entries))
+	}
+}
+
+// blockingResolver resolves its candidates once release closes
+type blockingResolver struct {
+	countingResolver
+	started chan struct{}
+	release chan struct{}
+}
+
+func (r *blockingResolver) Candidates(request ResolveRequest) ([]Candidate, error) {
+	r.started <- struct{}{}
+	<-r.release
+	return r.countingResolver.Candidates(request)
+}
+
+func TestCachingClientResolverSharesMisses(t *testing.T) {
+	resolver := &blockingResolver{
+		countingResolver: countingResolver{candidates: testCandidates(2)},
+		started:          make(chan struct{}, 10),
+		release:          make(chan struct{}),
+	}
+	r := NewCachingClientResolver(resolver, &testClient{})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if candidates, err := r.Candidates(ResolveRequest{Type: "test"}); err != nil || len(candidates) != 2 {
+				t.Errorf("expected 2 candidates, got %v, %v", candidates, err)
+			}
+		}()
+	}
+
+	<-resolver.started
+	time.Sleep(20 * time.Millisecond)
+	close(resolver.release)
+	wg.Wait()
+
+	if resolver.count != 1 {
+		t.Fatalf("expected 1 resolution, got %d", resolver.count)
+	}
+}
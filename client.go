@@ -19,4 +19,17 @@ type ClientConn interface {
 	Run(request Request) error
 	Logs() <-chan string
 	Signals() chan<- os.Signal
+	// Stdin sends stdin to the job, an empty message ends stdin
+	Stdin() chan<- []byte
+	// WindowSizes sends terminal size changes to the job
+	WindowSizes() chan<- WindowSize
+	// Uploads sends chunks of files to the job, see SendFile, ResumeFile and
+	// ChanSender
+	Uploads() chan<- FileChunk
+	// Files receives the chunks of files sent by the job, see ReceiveFile
+	Files() <-chan FileChunk
+	// Handshake is the handshake negotiated with the agent, nil if the
+	// connection wasn't negotiated
+	Handshake() *Handshake
+	Close() error
 }
@@ -3,6 +3,7 @@ package client
 import (
 	"net"
 	"os"
+	"sync"
 
 	"github.com/doubledutch/lager"
 	"github.com/doubledutch/mux"
@@ -13,10 +14,18 @@ import (
 type Conn struct {
 	mux.Client
 
-	lgr lager.Lager
-
-	logCh chan string
-	sigCh chan os.Signal
+	lgr     lager.Lager
+	netConn net.Conn
+
+	logCh     chan string
+	sigCh     chan os.Signal
+	stdinCh   chan []byte
+	resizeCh  chan quantum.WindowSize
+	uploadCh  chan quantum.FileChunk
+	fileCh    chan quantum.FileChunk
+	errCh     chan quantum.Error
+	handshake *quantum.Handshake
+	closeOnce sync.Once
 }
 
 // NewConn returns a new Connection connected to the specified io.ReadWriter
@@ -31,21 +40,68 @@ func NewConn(conn net.Conn, config *quantum.ConnConfig) (quantum.ClientConn, err
 		return nil, err
 	}
 	cc := &Conn{
-		Client: client,
-		lgr:    config.Lager,
-		logCh:  make(chan string, 1),
-		sigCh:  make(chan os.Signal, 1),
+		Client:   client,
+		lgr:      config.Lager,
+		netConn:  conn,
+		logCh:    make(chan string, 1),
+		sigCh:    make(chan os.Signal, 1),
+		stdinCh:  make(chan []byte, 1),
+		resizeCh: make(chan quantum.WindowSize, 1),
+		uploadCh: make(chan quantum.FileChunk, 1),
+		fileCh:   make(chan quantum.FileChunk, 1),
+		errCh:    make(chan quantum.Error, 1),
 	}
 
 	// Send up receiver for logs
 	logR := cc.Pool().NewReceiver(cc.logCh)
 	client.Receive(mux.LogType, logR)
 
+	fileR := cc.Pool().NewReceiver(cc.fileCh)
+	client.Receive(quantum.FileChunkType, fileR)
+
+	// Typed errors arrive before the connection is done
+	errR := cc.Pool().NewReceiver(cc.errCh)
+	client.Receive(quantum.ErrorType, errR)
+
 	go client.Recv()
 
 	return cc, nil
 }
 
+// Negotiate negotiates a handshake with the agent on conn, then returns
+// a new Connection using the negotiated codec
+func Negotiate(conn net.Conn, config *quantum.ConnConfig) (quantum.ClientConn, error) {
+	if config == nil {
+		config = quantum.DefaultConnConfig()
+	}
+
+	handshake, err := quantum.ClientHandshake(conn, config)
+	if err != nil {
+		return nil, err
+	}
+
+	cc, err := NewConn(conn, config.Negotiated(handshake))
+	if err != nil {
+		return nil, err
+	}
+	cc.(*Conn).handshake = handshake
+	return cc, nil
+}
+
+// Handshake returns the handshake negotiated with the agent
+func (c *Conn) Handshake() *quantum.Handshake {
+	return c.handshake
+}
+
+// Send sends v to the agent, failing with quantum.ErrCapabilityUnsupported
+// when the agent doesn't support messages of type t
+func (c *Conn) Send(t uint8, v interface{}) error {
+	if err := c.handshake.Allows(t); err != nil {
+		return err
+	}
+	return c.Client.Send(t, v)
+}
+
 // Logs provides the logs that the client receives
 func (c *Conn) Logs() <-chan string {
 	return c.logCh
@@ -56,6 +112,26 @@ func (c *Conn) Signals() chan<- os.Signal {
 	return c.sigCh
 }
 
+// Stdin provides a way to send stdin to the other end
+func (c *Conn) Stdin() chan<- []byte {
+	return c.stdinCh
+}
+
+// WindowSizes provides a way to send terminal size changes to the other end
+func (c *Conn) WindowSizes() chan<- quantum.WindowSize {
+	return c.resizeCh
+}
+
+// Uploads provides a way to send files to the other end
+func (c *Conn) Uploads() chan<- quantum.FileChunk {
+	return c.uploadCh
+}
+
+// Files provides the files that the client receives
+func (c *Conn) Files() <-chan quantum.FileChunk {
+	return c.fileCh
+}
+
 // Run sends the Request to the server on the other send
 // and waits for the response.
 func (c *Conn) Run(request quantum.Request) error {
@@ -78,15 +154,52 @@ func (c *Conn) Run(request quantum.Request) error {
 		}
 	}()
 
+	// Stdin, window sizes and uploads aren't closed by Close, since callers
+	// may still be sending, forwarding stops at shutdown instead
+	go func() {
+		for {
+			select {
+			case data := <-c.stdinCh:
+				c.Send(quantum.StdinType, data)
+			case size := <-c.resizeCh:
+				c.Send(quantum.WindowSizeType, size)
+			case chunk := <-c.uploadCh:
+				c.Send(quantum.FileChunkType, chunk)
+			case <-c.IsShutdown():
+				return
+			}
+		}
+	}()
+
 	c.lgr.Debugf("Waiting")
 	err := c.Wait()
 	c.Close()
+	return c.typedErr(err)
+}
+
+// typedErr returns the quantum.Error received for err, if any
+func (c *Conn) typedErr(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	select {
+	case e, ok := <-c.errCh:
+		if ok {
+			return &e
+		}
+	default:
+	}
+
 	return err
 }
 
 // Close closes ClientConn
-func (c *Conn) Close() error {
+func (c *Conn) Close() (err error) {
 	// We need to close senders, receivers are closed by mux.Client
-	close(c.sigCh)
-	return nil
+	c.closeOnce.Do(func() {
+		close(c.sigCh)
+		err = c.netConn.Close()
+	})
+	return
 }
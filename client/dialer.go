@@ -23,23 +23,44 @@ func New(config *quantum.ConnConfig) quantum.Client {
 	}
 }
 
-// Dial connects to the address and returns quantum.ClientConn
+// Dial connects to the address and returns quantum.ClientConn. Addresses
+// are host:port, or unix:/path for agents listening on Unix domain sockets.
 func (c *Client) Dial(address string) (quantum.ClientConn, error) {
-	netConn, err := net.Dial("tcp", address)
+	network, address := quantum.SplitNetwork(address)
+	netConn, err := net.Dial(network, address)
 	if err != nil {
-		return nil, err
+		return nil, dialErr(err)
 	}
 
-	return NewConn(netConn, c.ConnConfig)
+	return c.negotiate(netConn)
 }
 
 // DialTimeout connects to the address and returns quantum.ClientConn, timing out
 // after time
 func (c *Client) DialTimeout(address string, time time.Duration) (quantum.ClientConn, error) {
-	netConn, err := net.DialTimeout("tcp", address, time)
+	network, address := quantum.SplitNetwork(address)
+	netConn, err := net.DialTimeout(network, address, time)
+	if err != nil {
+		return nil, dialErr(err)
+	}
+
+	return c.negotiate(netConn)
+}
+
+// negotiate negotiates with the agent on netConn, closing it on failure
+func (c *Client) negotiate(netConn net.Conn) (quantum.ClientConn, error) {
+	conn, err := Negotiate(netConn, c.ConnConfig)
 	if err != nil {
+		netConn.Close()
 		return nil, err
 	}
+	return conn, nil
+}
 
-	return NewConn(netConn, c.ConnConfig)
+// dialErr types dial timeouts as quantum.ErrTimeout
+func dialErr(err error) error {
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		return quantum.WrapError(quantum.CodeTimeout, err)
+	}
+	return err
 }
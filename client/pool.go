@@ -0,0 +1,117 @@
+package client
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/doubledutch/quantum"
+	"github.com/hashicorp/go-multierror"
+)
+
+// SessionPool is a quantum.Client sharing a Session per agent address, so
+// concurrent jobs on an agent share a single connection
+type SessionPool struct {
+	*quantum.ConnConfig
+
+	mu       sync.Mutex
+	sessions map[string]*Session
+}
+
+// NewSessionPool returns a new SessionPool
+func NewSessionPool(config *quantum.ConnConfig) *SessionPool {
+	if config == nil {
+		config = quantum.DefaultConnConfig()
+	}
+
+	return &SessionPool{
+		ConnConfig: config,
+		sessions:   make(map[string]*Session),
+	}
+}
+
+// Dial opens a stream on the session of address
+func (p *SessionPool) Dial(address string) (quantum.ClientConn, error) {
+	return p.DialTimeout(address, 0)
+}
+
+// DialTimeout opens a stream on the session of address, connecting to
+// address if needed, timing out after time
+func (p *SessionPool) DialTimeout(address string, time time.Duration) (quantum.ClientConn, error) {
+	session, err := p.Session(address, time)
+	if err != nil {
+		return nil, err
+	}
+	return session.Open()
+}
+
+// Session returns the session of address, connecting to address if it
+// has no open session
+func (p *SessionPool) Session(address string, timeout time.Duration) (*Session, error) {
+	if session := p.open(address); session != nil {
+		return session, nil
+	}
+
+	network, addr := quantum.SplitNetwork(address)
+	netConn, err := net.DialTimeout(network, addr, timeout)
+	if err != nil {
+		return nil, dialErr(err)
+	}
+
+	session, err := NegotiateSession(netConn, p.ConnConfig)
+	if err != nil {
+		netConn.Close()
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// Another dial may have connected first
+	if existing := p.sessions[address]; existing != nil && !isShutdown(existing) {
+		session.Close()
+		return existing, nil
+	}
+	p.sessions[address] = session
+	return session, nil
+}
+
+// open returns the open session of address, if any
+func (p *SessionPool) open(address string) *Session {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	session := p.sessions[address]
+	if session == nil {
+		return nil
+	}
+	if isShutdown(session) {
+		delete(p.sessions, address)
+		return nil
+	}
+	return session
+}
+
+// Close closes every session of the pool
+func (p *SessionPool) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var result error
+	for address, session := range p.sessions {
+		if err := session.Close(); err != nil {
+			result = multierror.Append(result, err)
+		}
+		delete(p.sessions, address)
+	}
+	return result
+}
+
+func isShutdown(session *Session) bool {
+	select {
+	case <-session.IsShutdown():
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,392 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+
+	"github.com/doubledutch/lager"
+	"github.com/doubledutch/mux"
+	"github.com/doubledutch/quantum"
+)
+
+var (
+	// ErrSessionClosed = the connection of a session shut down
+	ErrSessionClosed = errors.New("Session Closed")
+	// ErrStreamClosed = a stream was closed before its job completed
+	ErrStreamClosed = errors.New("Stream Closed")
+)
+
+// Session runs many jobs concurrently over a single connection. Each job
+// runs in its own stream, with its own logs, signals and result.
+type Session struct {
+	mux.Client
+
+	lgr     lager.Lager
+	netConn net.Conn
+
+	envelopeCh chan quantum.Envelope
+	handshake  *quantum.Handshake
+
+	mu        sync.Mutex
+	nextID    uint64
+	streams   map[uint64]*Stream
+	closeOnce sync.Once
+}
+
+// NewSession returns a new Session over conn
+func NewSession(conn net.Conn, config *quantum.ConnConfig) (*Session, error) {
+	if config == nil {
+		config = quantum.DefaultConnConfig()
+	}
+
+	client, err := config.Pool.NewClient(conn, config.ToMux())
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Session{
+		Client:     client,
+		lgr:        config.Lager,
+		netConn:    conn,
+		envelopeCh: make(chan quantum.Envelope, 1),
+		streams:    make(map[uint64]*Stream),
+	}
+
+	envelopeR := s.Pool().NewReceiver(s.envelopeCh)
+	client.Receive(quantum.EnvelopeType, envelopeR)
+
+	go client.Recv()
+	go s.route()
+
+	return s, nil
+}
+
+// NegotiateSession negotiates a handshake with the agent on conn, then
+// returns a new Session using the negotiated codec. Agents must support
+// quantum.CapabilitySessions.
+func NegotiateSession(conn net.Conn, config *quantum.ConnConfig) (*Session, error) {
+	if config == nil {
+		config = quantum.DefaultConnConfig()
+	}
+
+	handshake, err := quantum.ClientHandshake(conn, config)
+	if err != nil {
+		return nil, err
+	}
+	if !handshake.Has(quantum.CapabilitySessions) {
+		return nil, fmt.Errorf("%w: %s", quantum.ErrCapabilityUnsupported, quantum.CapabilitySessions)
+	}
+
+	s, err := NewSession(conn, config.Negotiated(handshake))
+	if err != nil {
+		return nil, err
+	}
+	s.handshake = handshake
+	return s, nil
+}
+
+// Handshake returns the handshake negotiated with the agent
+func (s *Session) Handshake() *quantum.Handshake {
+	return s.handshake
+}
+
+// Open opens a stream for a job, returning it as a quantum.ClientConn
+func (s *Session) Open() (quantum.ClientConn, error) {
+	select {
+	case <-s.IsShutdown():
+		return nil, ErrSessionClosed
+	default:
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.streams == nil {
+		return nil, ErrSessionClosed
+	}
+
+	s.nextID++
+	st := newStream(s, s.nextID)
+	s.streams[st.id] = st
+	return st, nil
+}
+
+// Close closes the connection of the session, failing its open streams
+func (s *Session) Close() (err error) {
+	s.closeOnce.Do(func() {
+		err = s.netConn.Close()
+	})
+	return
+}
+
+// route routes messages to their streams until the connection shuts down
+func (s *Session) route() {
+	for envelope := range s.envelopeCh {
+		s.mu.Lock()
+		st := s.streams[envelope.Stream]
+		s.mu.Unlock()
+
+		// Waits for the stream to catch up when it's backlogged
+		if st != nil && !st.queue.Push(envelope, s.IsShutdown()) {
+			s.lgr.Debugf("Dropping message for closed stream: %d", envelope.Stream)
+		}
+	}
+
+	s.mu.Lock()
+	streams := s.streams
+	s.streams = nil
+	s.mu.Unlock()
+
+	// Streams finish once their queued messages are delivered
+	for _, st := range streams {
+		st.queue.Close()
+	}
+}
+
+// remove removes the stream with id from the session
+func (s *Session) remove(id uint64) {
+	s.mu.Lock()
+	delete(s.streams, id)
+	s.mu.Unlock()
+}
+
+// Stream is a quantum.ClientConn running a job within a Session
+type Stream struct {
+	session *Session
+	id      uint64
+
+	logCh    chan string
+	fileCh   chan quantum.FileChunk
+	sigCh    chan os.Signal
+	stdinCh  chan []byte
+	resizeCh chan quantum.WindowSize
+	uploadCh chan quantum.FileChunk
+
+	queue      *quantum.EnvelopeQueue
+	err        error
+	done       chan struct{}
+	closed     chan struct{}
+	finishOnce sync.Once
+	closeOnce  sync.Once
+}
+
+func newStream(session *Session, id uint64) *Stream {
+	st := &Stream{
+		session:  session,
+		id:       id,
+		logCh:    make(chan string, 1),
+		fileCh:   make(chan quantum.FileChunk, 1),
+		sigCh:    make(chan os.Signal, 1),
+		stdinCh:  make(chan []byte, 1),
+		resizeCh: make(chan quantum.WindowSize, 1),
+		uploadCh: make(chan quantum.FileChunk, 1),
+		queue:    quantum.NewEnvelopeQueue(quantum.DefaultStreamBacklog),
+		done:     make(chan struct{}),
+		closed:   make(chan struct{}),
+	}
+	go st.deliver()
+	return st
+}
+
+// deliver delivers the queued messages of the stream in order until its
+// job completes, or the stream or its session closes
+func (st *Stream) deliver() {
+	for {
+		envelope, ok := st.queue.Pop()
+		if !ok {
+			break
+		}
+		if st.receive(envelope) {
+			return
+		}
+	}
+
+	select {
+	case <-st.closed:
+		st.finish(ErrStreamClosed)
+	default:
+		st.finish(ErrSessionClosed)
+	}
+}
+
+// receive routes a message from the agent to the stream, returning true
+// once the job completed
+func (st *Stream) receive(envelope quantum.Envelope) bool {
+	pool := st.Pool()
+
+	var err error
+	switch envelope.Type {
+	case mux.LogType:
+		var log string
+		if err = envelope.Decode(pool, &log); err == nil {
+			select {
+			case st.logCh <- log:
+			case <-st.closed:
+			}
+		}
+	case quantum.FileChunkType:
+		var chunk quantum.FileChunk
+		if err = envelope.Decode(pool, &chunk); err == nil {
+			select {
+			case st.fileCh <- chunk:
+			case <-st.closed:
+			}
+		}
+	case quantum.StreamDoneType:
+		var result quantum.StreamResult
+		if err = envelope.Decode(pool, &result); err == nil {
+			st.session.remove(st.id)
+			st.finish(result.Err())
+			return true
+		}
+	}
+
+	if err != nil {
+		st.session.lgr.Errorf("Error decoding message for stream %d: %s", st.id, err)
+	}
+	return false
+}
+
+// finish completes the stream with the result of its job
+func (st *Stream) finish(err error) {
+	st.finishOnce.Do(func() {
+		st.queue.Close()
+		st.err = err
+		close(st.logCh)
+		close(st.fileCh)
+		close(st.done)
+	})
+}
+
+// Send sends v to the job within the stream, failing with
+// quantum.ErrCapabilityUnsupported when the agent doesn't support
+// messages of type t
+func (st *Stream) Send(t uint8, v interface{}) error {
+	if err := st.session.handshake.Allows(t); err != nil {
+		return err
+	}
+
+	envelope, err := quantum.NewEnvelope(st.Pool(), st.id, t, v)
+	if err != nil {
+		return err
+	}
+	return st.session.Send(quantum.EnvelopeType, envelope)
+}
+
+// Receive is a no-op, the session routes messages to streams
+func (st *Stream) Receive(t uint8, r mux.Receiver) {}
+
+// Recv is a no-op, the session receives for its streams
+func (st *Stream) Recv() {}
+
+// IsShutdown returns a chan closed once the job completes
+func (st *Stream) IsShutdown() chan struct{} {
+	return st.done
+}
+
+// Pool returns the Pool of the session
+func (st *Stream) Pool() mux.Pool {
+	return st.session.Pool()
+}
+
+// Wait waits for the job to complete, returning its error
+func (st *Stream) Wait() error {
+	select {
+	case <-st.done:
+		return st.err
+	default:
+	}
+
+	select {
+	case <-st.done:
+		return st.err
+	case <-st.closed:
+		return ErrStreamClosed
+	}
+}
+
+// Handshake returns the handshake of the session
+func (st *Stream) Handshake() *quantum.Handshake {
+	return st.session.handshake
+}
+
+// Logs provides the logs of the job
+func (st *Stream) Logs() <-chan string {
+	return st.logCh
+}
+
+// Signals provides a way to send signals to the job
+func (st *Stream) Signals() chan<- os.Signal {
+	return st.sigCh
+}
+
+// Stdin provides a way to send stdin to the job
+func (st *Stream) Stdin() chan<- []byte {
+	return st.stdinCh
+}
+
+// WindowSizes provides a way to send terminal size changes to the job
+func (st *Stream) WindowSizes() chan<- quantum.WindowSize {
+	return st.resizeCh
+}
+
+// Uploads provides a way to send files to the job
+func (st *Stream) Uploads() chan<- quantum.FileChunk {
+	return st.uploadCh
+}
+
+// Files provides the files sent by the job
+func (st *Stream) Files() <-chan quantum.FileChunk {
+	return st.fileCh
+}
+
+// Run sends the Request to the agent within the stream and waits for
+// the result of the job.
+func (st *Stream) Run(request quantum.Request) error {
+	st.session.lgr.Debugf("Sending request on stream %d: %s", st.id, request)
+	if err := st.Send(quantum.RequestType, request); err != nil {
+		st.session.lgr.Errorf("Error sending request: %s", request)
+		return err
+	}
+
+	go st.forward()
+
+	err := st.Wait()
+	st.Close()
+	return err
+}
+
+// forward sends signals, stdin, window sizes and uploads to the job until
+// it completes
+func (st *Stream) forward() {
+	for {
+		select {
+		case sig, ok := <-st.sigCh:
+			if !ok {
+				return
+			}
+			st.Send(mux.SignalType, sig)
+		case data := <-st.stdinCh:
+			st.Send(quantum.StdinType, data)
+		case size := <-st.resizeCh:
+			st.Send(quantum.WindowSizeType, size)
+		case chunk := <-st.uploadCh:
+			st.Send(quantum.FileChunkType, chunk)
+		case <-st.done:
+			return
+		}
+	}
+}
+
+// Close closes the stream, leaving the session open for other streams
+func (st *Stream) Close() error {
+	st.closeOnce.Do(func() {
+		st.session.remove(st.id)
+		close(st.closed)
+		close(st.sigCh)
+		st.queue.Close()
+	})
+	return nil
+}
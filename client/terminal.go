@@ -0,0 +1,71 @@
+package client
+
+import (
+	"io"
+	"os"
+
+	"github.com/doubledutch/quantum"
+	"golang.org/x/term"
+)
+
+// RunTerminal runs request on conn as an interactive session, sending in as
+// stdin and writing logs to out as they arrive. When in is a terminal, it is
+// put in raw mode for the duration of the session, and its size is sent to
+// the job whenever it changes.
+func RunTerminal(conn quantum.ClientConn, request quantum.Request, in *os.File, out io.Writer) error {
+	fd := int(in.Fd())
+	if term.IsTerminal(fd) {
+		state, err := term.MakeRaw(fd)
+		if err != nil {
+			return err
+		}
+		defer term.Restore(fd, state)
+
+		if cols, rows, err := term.GetSize(fd); err == nil {
+			conn.WindowSizes() <- quantum.WindowSize{Rows: uint16(rows), Cols: uint16(cols)}
+		}
+
+		stop := watchWindowSize(fd, conn.WindowSizes())
+		defer stop()
+	}
+
+	// Reading in may block past the end of the session, so the copy isn't
+	// waited on
+	go quantum.CopyStdin(conn.Stdin(), in)
+
+	logsDone := make(chan struct{})
+	go func() {
+		defer close(logsDone)
+		for {
+			select {
+			case log, ok := <-conn.Logs():
+				if !ok {
+					return
+				}
+				io.WriteString(out, log)
+			case <-conn.IsShutdown():
+				drainLogs(conn, out)
+				return
+			}
+		}
+	}()
+
+	err := conn.Run(request)
+	<-logsDone
+	return err
+}
+
+// drainLogs writes the logs still buffered in conn to out
+func drainLogs(conn quantum.ClientConn, out io.Writer) {
+	for {
+		select {
+		case log, ok := <-conn.Logs():
+			if !ok {
+				return
+			}
+			io.WriteString(out, log)
+		default:
+			return
+		}
+	}
+}
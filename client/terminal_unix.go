@@ -0,0 +1,45 @@
+//go:build !windows
+// +build !windows
+
+package client
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/doubledutch/quantum"
+	"golang.org/x/term"
+)
+
+// watchWindowSize sends the size of the terminal fd to ch on SIGWINCH,
+// until the returned func is called
+func watchWindowSize(fd int, ch chan<- quantum.WindowSize) func() {
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGWINCH)
+
+	stopCh := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-sigCh:
+				cols, rows, err := term.GetSize(fd)
+				if err != nil {
+					continue
+				}
+				select {
+				case ch <- quantum.WindowSize{Rows: uint16(rows), Cols: uint16(cols)}:
+				case <-stopCh:
+					return
+				}
+			case <-stopCh:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		signal.Stop(sigCh)
+		close(stopCh)
+	}
+}
@@ -0,0 +1,11 @@
+//go:build windows
+// +build windows
+
+package client
+
+import "github.com/doubledutch/quantum"
+
+// watchWindowSize is a no-op, Windows consoles don't signal size changes
+func watchWindowSize(fd int, ch chan<- quantum.WindowSize) func() {
+	return func() {}
+}
@@ -3,7 +3,9 @@ package quantum
 import (
 	"errors"
 	"fmt"
-	"strings"
+	"time"
+
+	"github.com/hashicorp/go-multierror"
 )
 
 // TODO: Resolve with Consul or DNS
@@ -13,19 +15,34 @@ var (
 	ErrNoConfigs = errors.New("no configs provided")
 )
 
-// IsNoAgentsErr returns whether this error is a no agents responded error
+// IsNoAgentsErr returns whether this error is a no agents responded error.
+// Aggregated errors are only no agents errors when each of their errors is,
+// so a failing resolver isn't mistaken for a miss.
 func IsNoAgentsErr(err error) bool {
-	return strings.Contains(err.Error(), "no agents responded")
+	var merr *multierror.Error
+	if errors.As(err, &merr) {
+		if len(merr.Errors) == 0 {
+			return false
+		}
+		for _, e := range merr.Errors {
+			if !IsNoAgentsErr(e) {
+				return false
+			}
+		}
+		return true
+	}
+
+	return errors.Is(err, ErrNoAgents)
 }
 
 // NoAgentsErr creates an error for no agents that responded with type
 func NoAgentsErr(t string) error {
-	return fmt.Errorf("no agents responded with type %s", t)
+	return NewError(CodeNoAgents, fmt.Sprintf("no agents responded with type %s", t))
 }
 
 // NoAgentsWithNameErr creates an error for no agents that responded with type and name
 func NoAgentsWithNameErr(t string, name string) error {
-	return fmt.Errorf("no agents responded with type %s and name %s", t, name)
+	return NewError(CodeNoAgents, fmt.Sprintf("no agents responded with type %s and name %s", t, name))
 }
 
 // NoAgentsFromRequest creates an error for no agents that responded from a request
@@ -48,28 +65,155 @@ type ClientResolver interface {
 type ResolveRequest struct {
 	Agent string
 	Type  string
+	// Key is used by ConsistentHashStrategy to pick the same agent
+	Key string
+	// Selector restricts resolution to agents with matching labels
+	Selector Selector
 }
 
 // ClientResolverConfig defines the parameters for ClientResolver
 type ClientResolverConfig struct {
 	Config *Config
 	Server string
+
+	// Strategy selects the Balancer of a BalancedClientResolver, or of a
+	// MultiClientResolver balancing the candidates of its resolvers
+	Strategy    BalanceStrategy
+	DialTimeout time.Duration
 }
 
 // MultiClientResolver implements ClientResolver by trying to resolve a client
 // be iterating through provided ClientResolvers.
 type MultiClientResolver struct {
 	Resolvers []ClientResolver
+	// Parallel queries every resolver at once. Resolvers keep their priority,
+	// the conn of an earlier resolver is preferred over that of a later one.
+	Parallel bool
+
+	// Balancer balances the candidates of resolvers implementing
+	// CandidateResolver, dialing them with Client. Resolvers dial their own
+	// candidates when either is nil.
+	Balancer    Balancer
+	Client      Client
+	DialTimeout time.Duration
+}
+
+// NewMultiClientResolver creates a MultiClientResolver balancing the
+// candidates of resolvers with the strategy of config
+func NewMultiClientResolver(client Client, config *ClientResolverConfig, resolvers ...ClientResolver) (*MultiClientResolver, error) {
+	if config == nil {
+		config = new(ClientResolverConfig)
+	}
+
+	balancer, err := NewBalancer(config.Strategy)
+	if err != nil {
+		return nil, err
+	}
+
+	return &MultiClientResolver{
+		Resolvers:   resolvers,
+		Balancer:    balancer,
+		Client:      client,
+		DialTimeout: config.DialTimeout,
+	}, nil
 }
 
 // Resolve resolves a ResolveRequest by iterating through r.Resolvers
 func (r *MultiClientResolver) Resolve(request ResolveRequest) (conn ClientConn, err error) {
+	conn, _, err = r.ResolveFrom(request)
+	return conn, err
+}
+
+// ResolveFrom resolves a ResolveRequest, also returning the resolver that
+// produced the conn. If every resolver fails, the error contains the error
+// of each resolver.
+func (r *MultiClientResolver) ResolveFrom(request ResolveRequest) (ClientConn, ClientResolver, error) {
+	if r.Parallel {
+		return r.resolveParallel(request)
+	}
+
+	var result error
 	for _, resolver := range r.Resolvers {
-		conn, err = resolver.Resolve(request)
+		conn, err := r.resolve(resolver, request)
 		if err == nil {
-			break
+			return conn, resolver, nil
 		}
+		result = multierror.Append(result, resolverErr(resolver, err))
 	}
 
-	return conn, err
+	return nil, nil, r.errorOrNoAgents(request, result)
+}
+
+// resolve resolves request with resolver, balancing its candidates when
+// it's a CandidateResolver
+func (r *MultiClientResolver) resolve(resolver ClientResolver, request ResolveRequest) (ClientConn, error) {
+	candidates, ok := resolver.(CandidateResolver)
+	if !ok || r.Balancer == nil || r.Client == nil {
+		return resolver.Resolve(request)
+	}
+
+	balanced := &BalancedClientResolver{
+		Resolver: candidates,
+		Balancer: r.Balancer,
+		Client:   r.Client,
+		Timeout:  r.DialTimeout,
+	}
+	return balanced.Resolve(request)
+}
+
+type resolveResult struct {
+	conn ClientConn
+	err  error
+}
+
+func (r *MultiClientResolver) resolveParallel(request ResolveRequest) (ClientConn, ClientResolver, error) {
+	resultChs := make([]chan resolveResult, len(r.Resolvers))
+	for i, resolver := range r.Resolvers {
+		resultChs[i] = make(chan resolveResult, 1)
+		go func(resolver ClientResolver, resultCh chan resolveResult) {
+			conn, err := r.resolve(resolver, request)
+			resultCh <- resolveResult{conn: conn, err: err}
+		}(resolver, resultChs[i])
+	}
+
+	var result error
+	for i, resultCh := range resultChs {
+		res := <-resultCh
+		if res.err == nil {
+			// Close the conns of lower priority resolvers as they arrive
+			go closeResults(resultChs[i+1:])
+			return res.conn, r.Resolvers[i], nil
+		}
+		result = multierror.Append(result, resolverErr(r.Resolvers[i], res.err))
+	}
+
+	return nil, nil, r.errorOrNoAgents(request, result)
+}
+
+func closeResults(resultChs []chan resolveResult) {
+	for _, resultCh := range resultChs {
+		if res := <-resultCh; res.err == nil {
+			res.conn.Close()
+		}
+	}
+}
+
+func (r *MultiClientResolver) errorOrNoAgents(request ResolveRequest, err error) error {
+	if err == nil {
+		return NoAgentsFromRequest(request)
+	}
+	return err
+}
+
+// ResolverName returns the name of resolver for diagnostics, using String
+// if resolver implements fmt.Stringer.
+func ResolverName(resolver ClientResolver) string {
+	if s, ok := resolver.(fmt.Stringer); ok {
+		return s.String()
+	}
+	return fmt.Sprintf("%T", resolver)
+}
+
+func resolverErr(resolver ClientResolver, err error) error {
+	return fmt.Errorf("%s: %w", ResolverName(resolver), err)
 }
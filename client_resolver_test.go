@@ -1,6 +1,11 @@
 package quantum
 
-import "testing"
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
 
 func TestNoAgentsErr(t *testing.T) {
 	err := NoAgentsErr("test")
@@ -50,3 +55,135 @@ func TestNoAgentsFromRequestWithAgent(t *testing.T) {
 		t.Fatal("wrong error message")
 	}
 }
+
+type testResolver struct {
+	name  string
+	delay time.Duration
+	conn  ClientConn
+	err   error
+}
+
+func (r *testResolver) Resolve(request ResolveRequest) (ClientConn, error) {
+	time.Sleep(r.delay)
+	return r.conn, r.err
+}
+
+func (r *testResolver) String() string {
+	return r.name
+}
+
+func TestMultiClientResolverErrors(t *testing.T) {
+	r := &MultiClientResolver{
+		Resolvers: []ClientResolver{
+			&testResolver{name: "consul", err: errors.New("connection refused")},
+			&testResolver{name: "inmemory", err: NoAgentsErr("test")},
+		},
+	}
+
+	_, resolver, err := r.ResolveFrom(ResolveRequest{Type: "test"})
+	if err == nil || resolver != nil {
+		t.Fatal("expected err")
+	}
+
+	msg := err.Error()
+	if !strings.Contains(msg, "consul: connection refused") || !strings.Contains(msg, "inmemory: no agents responded") {
+		t.Fatalf("expected errors of both resolvers, got %s", msg)
+	}
+}
+
+func TestMultiClientResolverFrom(t *testing.T) {
+	conn := &testClientConn{}
+	second := &testResolver{name: "second", conn: conn}
+	r := &MultiClientResolver{
+		Resolvers: []ClientResolver{
+			&testResolver{name: "first", err: errors.New("failed")},
+			second,
+		},
+	}
+
+	actual, resolver, err := r.ResolveFrom(ResolveRequest{Type: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if actual != conn || resolver != second {
+		t.Fatalf("expected conn from second, got %s", ResolverName(resolver))
+	}
+}
+
+func TestMultiClientResolverParallel(t *testing.T) {
+	slow := &testClientConn{}
+	fast := &testClientConn{}
+	r := &MultiClientResolver{
+		Parallel: true,
+		Resolvers: []ClientResolver{
+			&testResolver{name: "failed", err: errors.New("failed")},
+			&testResolver{name: "slow", delay: 50 * time.Millisecond, conn: slow},
+			&testResolver{name: "fast", conn: fast},
+		},
+	}
+
+	conn, resolver, err := r.ResolveFrom(ResolveRequest{Type: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Priority wins over speed
+	if conn != slow || ResolverName(resolver) != "slow" {
+		t.Fatalf("expected conn from slow, got %s", ResolverName(resolver))
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for !fast.isClosed() {
+		if time.Now().After(deadline) {
+			t.Fatal("expected lower priority conn to be closed")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestMultiClientResolverEmpty(t *testing.T) {
+	r := &MultiClientResolver{}
+	if _, err := r.Resolve(ResolveRequest{Type: "test"}); !IsNoAgentsErr(err) {
+		t.Fatalf("expected no agents err, got %v", err)
+	}
+}
+
+// testBackend resolves candidates, but can't dial them itself
+type testBackend struct {
+	testCandidateResolver
+}
+
+func (r *testBackend) Resolve(request ResolveRequest) (ClientConn, error) {
+	return nil, errors.New("unbalanced")
+}
+
+func TestMultiClientResolverStrategy(t *testing.T) {
+	client := &testClient{
+		delays: map[string]time.Duration{"agent0": 0, "agent1": 0},
+	}
+	backend := &testBackend{testCandidates(2)}
+	r, err := NewMultiClientResolver(client, &ClientResolverConfig{Strategy: RoundRobinStrategy}, backend)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var addresses []string
+	for i := 0; i < 2; i++ {
+		conn, resolver, err := r.ResolveFrom(ResolveRequest{Type: "test"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resolver != backend {
+			t.Fatal("expected conn from backend")
+		}
+		addresses = append(addresses, conn.(*testClientConn).address)
+	}
+
+	if addresses[0] == addresses[1] {
+		t.Fatalf("expected candidates to be balanced, got %v", addresses)
+	}
+
+	if _, err := NewMultiClientResolver(client, &ClientResolverConfig{Strategy: "unknown"}); err == nil {
+		t.Fatal("expected unknown strategy error")
+	}
+}
@@ -0,0 +1,68 @@
+// Package json implements a mux.Pool encoding messages as JSON, so agents
+// can be used by clients written in other languages.
+//
+// After the handshake (see quantum.ClientHandshake), each message is a JSON
+// header holding its type, followed by the JSON message, e.g. a request:
+//
+//	{"Type":67}
+//	{"Type":"build","Data":"eyJicmFuY2giOiJtYXN0ZXIifQ=="}
+//
+// Byte slices are base64 strings, as with encoding/json.
+package json
+
+import (
+	"encoding/json"
+	"io"
+	"net"
+
+	"github.com/doubledutch/mux"
+)
+
+// Name is the name of the codec in handshakes
+const Name = "json"
+
+// Pool is a mux.Pool encoding messages as JSON
+type Pool struct{}
+
+// Name returns the name of the codec
+func (p *Pool) Name() string {
+	return Name
+}
+
+// NewEncoder returns an Encoder writing JSON values to w
+func (p *Pool) NewEncoder(w io.Writer) mux.Encoder {
+	return json.NewEncoder(w)
+}
+
+// NewDecoder returns a Decoder reading JSON values from r
+func (p *Pool) NewDecoder(r io.Reader) mux.Decoder {
+	return decoder{json.NewDecoder(r)}
+}
+
+// NewClient returns a mux.Client using the pool
+func (p *Pool) NewClient(conn net.Conn, config *mux.Config) (mux.Client, error) {
+	return mux.NewClient(conn, p, config)
+}
+
+// NewServer returns a mux.Server using the pool
+func (p *Pool) NewServer(conn net.Conn, config *mux.Config) (mux.Server, error) {
+	return mux.NewServer(conn, p, config)
+}
+
+// NewReceiver returns a mux.Receiver sending values to ch
+func (p *Pool) NewReceiver(ch interface{}) mux.Receiver {
+	return mux.NewReceiver(ch, p)
+}
+
+type decoder struct {
+	*json.Decoder
+}
+
+// Decode decodes the next value into v, discarding it if v is nil
+func (d decoder) Decode(v interface{}) error {
+	if v == nil {
+		var discard json.RawMessage
+		return d.Decoder.Decode(&discard)
+	}
+	return d.Decoder.Decode(v)
+}
@@ -0,0 +1,37 @@
+package json
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/doubledutch/quantum"
+)
+
+func TestPool(t *testing.T) {
+	pool := new(Pool)
+
+	var buf bytes.Buffer
+	enc := pool.NewEncoder(&buf)
+	request := quantum.NewRequest("build", `{"branch":"master"}`)
+	for _, v := range []interface{}{request, "skipped", request} {
+		if err := enc.Encode(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dec := pool.NewDecoder(&buf)
+	var first, second quantum.Request
+	if err := dec.Decode(&first); err != nil {
+		t.Fatal(err)
+	}
+	if err := dec.Decode(nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := dec.Decode(&second); err != nil {
+		t.Fatal(err)
+	}
+
+	if first.Type != "build" || string(second.Data) != `{"branch":"master"}` {
+		t.Fatalf("unexpected requests: %+v, %+v", first, second)
+	}
+}
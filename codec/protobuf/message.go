@@ -0,0 +1,358 @@
+package protobuf
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/doubledutch/quantum"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Fields of Message, see quantum.proto
+const (
+	headerField       = protowire.Number(1)
+	textField         = protowire.Number(2)
+	numberField       = protowire.Number(3)
+	dataField         = protowire.Number(4)
+	requestField      = protowire.Number(5)
+	errorField        = protowire.Number(6)
+	windowSizeField   = protowire.Number(7)
+	fileChunkField    = protowire.Number(8)
+	envelopeField     = protowire.Number(9)
+	streamResultField = protowire.Number(10)
+)
+
+// marshal encodes v as a Message
+func marshal(v interface{}) ([]byte, error) {
+	if rv := reflect.ValueOf(v); rv.Kind() == reflect.Ptr && !rv.IsNil() {
+		v = rv.Elem().Interface()
+	}
+
+	var m message
+	switch v := v.(type) {
+	case string:
+		return m.appendBytes(textField, []byte(v)), nil
+	case int:
+		m = protowire.AppendTag(m, numberField, protowire.VarintType)
+		return protowire.AppendVarint(m, protowire.EncodeZigZag(int64(v))), nil
+	case []byte:
+		return m.appendBytes(dataField, v), nil
+	case quantum.Request:
+		return m.appendBytes(requestField, marshalRequest(v)), nil
+	case quantum.Error:
+		return m.appendBytes(errorField, marshalError(v)), nil
+	case quantum.WindowSize:
+		return m.appendBytes(windowSizeField, marshalWindowSize(v)), nil
+	case quantum.FileChunk:
+		return m.appendBytes(fileChunkField, marshalFileChunk(v)), nil
+	case quantum.Envelope:
+		return m.appendBytes(envelopeField, marshalEnvelope(v)), nil
+	case quantum.StreamResult:
+		return m.appendBytes(streamResultField, marshalStreamResult(v)), nil
+	}
+
+	if field, ok := headerType(reflect.ValueOf(v)); ok {
+		var header message
+		header = header.uint(1, field.Uint())
+		return m.appendBytes(headerField, header), nil
+	}
+	return nil, fmt.Errorf("%w: %T", ErrUnsupportedType, v)
+}
+
+// unmarshal decodes the Message in b into v
+func unmarshal(b []byte, v interface{}) error {
+	num, f, err := parseValue(b)
+	if err != nil {
+		return err
+	}
+
+	expect := func(n protowire.Number) error {
+		if num != n {
+			return fmt.Errorf("%w: field %d for %T", ErrUnexpectedMessage, num, v)
+		}
+		return nil
+	}
+
+	switch v := v.(type) {
+	case *string:
+		if err := expect(textField); err != nil {
+			return err
+		}
+		*v = string(f.b)
+	case *int:
+		if err := expect(numberField); err != nil {
+			return err
+		}
+		*v = int(protowire.DecodeZigZag(f.v))
+	case *[]byte:
+		if err := expect(dataField); err != nil {
+			return err
+		}
+		*v = f.b
+	case *quantum.Request:
+		if err := expect(requestField); err != nil {
+			return err
+		}
+		return unmarshalRequest(f.b, v)
+	case *quantum.Error:
+		if err := expect(errorField); err != nil {
+			return err
+		}
+		return unmarshalError(f.b, v)
+	case *quantum.WindowSize:
+		if err := expect(windowSizeField); err != nil {
+			return err
+		}
+		return unmarshalWindowSize(f.b, v)
+	case *quantum.FileChunk:
+		if err := expect(fileChunkField); err != nil {
+			return err
+		}
+		return unmarshalFileChunk(f.b, v)
+	case *quantum.Envelope:
+		if err := expect(envelopeField); err != nil {
+			return err
+		}
+		return unmarshalEnvelope(f.b, v)
+	case *quantum.StreamResult:
+		if err := expect(streamResultField); err != nil {
+			return err
+		}
+		return unmarshalStreamResult(f.b, v)
+	default:
+		rv := reflect.ValueOf(v)
+		if rv.Kind() != reflect.Ptr || rv.IsNil() {
+			return fmt.Errorf("%w: %T", ErrUnsupportedType, v)
+		}
+		field, ok := headerType(rv.Elem())
+		if !ok {
+			return fmt.Errorf("%w: %T", ErrUnsupportedType, v)
+		}
+		if err := expect(headerField); err != nil {
+			return err
+		}
+		header, err := parse(f.b)
+		if err != nil {
+			return err
+		}
+		field.SetUint(header[1].v)
+	}
+	return nil
+}
+
+// headerType returns the Type field of a mux header, a struct holding
+// only the type of the message following it
+func headerType(v reflect.Value) (reflect.Value, bool) {
+	if v.Kind() != reflect.Struct || v.NumField() != 1 {
+		return reflect.Value{}, false
+	}
+	field := v.Field(0)
+	if v.Type().Field(0).Name != "Type" || field.Kind() != reflect.Uint8 {
+		return reflect.Value{}, false
+	}
+	return field, true
+}
+
+func marshalRequest(r quantum.Request) message {
+	var m message
+	return m.bytes(1, []byte(r.Type)).bytes(2, r.Data)
+}
+
+func unmarshalRequest(b []byte, r *quantum.Request) error {
+	m, err := parse(b)
+	if err != nil {
+		return err
+	}
+	*r = quantum.Request{
+		Type: string(m[1].b),
+		Data: m[2].b,
+	}
+	return nil
+}
+
+func marshalError(e quantum.Error) message {
+	var m message
+	return m.uint(1, uint64(e.Code)).bytes(2, []byte(e.Message))
+}
+
+func unmarshalError(b []byte, e *quantum.Error) error {
+	m, err := parse(b)
+	if err != nil {
+		return err
+	}
+	*e = quantum.Error{
+		Code:    quantum.ErrorCode(int64(m[1].v)),
+		Message: string(m[2].b),
+	}
+	return nil
+}
+
+func marshalWindowSize(s quantum.WindowSize) message {
+	var m message
+	return m.uint(1, uint64(s.Rows)).uint(2, uint64(s.Cols))
+}
+
+func unmarshalWindowSize(b []byte, s *quantum.WindowSize) error {
+	m, err := parse(b)
+	if err != nil {
+		return err
+	}
+	*s = quantum.WindowSize{
+		Rows: uint16(m[1].v),
+		Cols: uint16(m[2].v),
+	}
+	return nil
+}
+
+func marshalFileChunk(c quantum.FileChunk) message {
+	var m message
+	return m.bytes(1, []byte(c.Name)).
+		uint(2, uint64(c.Offset)).
+		bytes(3, c.Data).
+		bool(4, c.EOF).
+		bytes(5, []byte(c.Checksum)).
+		bool(6, c.Resume)
+}
+
+func unmarshalFileChunk(b []byte, c *quantum.FileChunk) error {
+	m, err := parse(b)
+	if err != nil {
+		return err
+	}
+	*c = quantum.FileChunk{
+		Name:     string(m[1].b),
+		Offset:   int64(m[2].v),
+		Data:     m[3].b,
+		EOF:      m[4].v != 0,
+		Checksum: string(m[5].b),
+		Resume:   m[6].v != 0,
+	}
+	return nil
+}
+
+func marshalEnvelope(e quantum.Envelope) message {
+	var m message
+	return m.uint(1, e.Stream).uint(2, uint64(e.Type)).bytes(3, e.Data)
+}
+
+func unmarshalEnvelope(b []byte, e *quantum.Envelope) error {
+	m, err := parse(b)
+	if err != nil {
+		return err
+	}
+	*e = quantum.Envelope{
+		Stream: m[1].v,
+		Type:   uint8(m[2].v),
+		Data:   m[3].b,
+	}
+	return nil
+}
+
+func marshalStreamResult(r quantum.StreamResult) message {
+	var m message
+	m = m.bool(1, r.Failed)
+	if r.Failed {
+		m = m.appendBytes(2, marshalError(r.Error))
+	}
+	return m
+}
+
+func unmarshalStreamResult(b []byte, r *quantum.StreamResult) error {
+	m, err := parse(b)
+	if err != nil {
+		return err
+	}
+	*r = quantum.StreamResult{Failed: m[1].v != 0}
+	if f, ok := m[2]; ok {
+		return unmarshalError(f.b, &r.Error)
+	}
+	return nil
+}
+
+// message is an encoded protobuf message. Fields holding their zero value
+// are omitted, as in proto3.
+type message []byte
+
+func (m message) uint(num protowire.Number, v uint64) message {
+	if v == 0 {
+		return m
+	}
+	m = protowire.AppendTag(m, num, protowire.VarintType)
+	return protowire.AppendVarint(m, v)
+}
+
+func (m message) bool(num protowire.Number, v bool) message {
+	if !v {
+		return m
+	}
+	return m.uint(num, 1)
+}
+
+func (m message) bytes(num protowire.Number, b []byte) message {
+	if len(b) == 0 {
+		return m
+	}
+	return m.appendBytes(num, b)
+}
+
+// appendBytes appends b even when it's empty, for fields of a oneof and
+// nested messages
+func (m message) appendBytes(num protowire.Number, b []byte) message {
+	m = protowire.AppendTag(m, num, protowire.BytesType)
+	return protowire.AppendBytes(m, b)
+}
+
+// parseValue decodes the field set in the value oneof of the Message in b
+func parseValue(b []byte) (protowire.Number, field, error) {
+	msg, err := parse(b)
+	if err != nil {
+		return 0, field{}, err
+	}
+	if len(msg) != 1 {
+		return 0, field{}, fmt.Errorf("%w: %d values", ErrUnexpectedMessage, len(msg))
+	}
+
+	for num, f := range msg {
+		return num, f, nil
+	}
+	return 0, field{}, nil
+}
+
+// field is the value of a decoded field, v for varints and b otherwise
+type field struct {
+	v uint64
+	b []byte
+}
+
+// parse decodes the fields of the message in b. Later fields replace
+// earlier fields of the same number, fields of other wire types are
+// skipped.
+func parse(b []byte) (map[protowire.Number]field, error) {
+	fields := make(map[protowire.Number]field)
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		var f field
+		switch typ {
+		case protowire.VarintType:
+			f.v, n = protowire.ConsumeVarint(b)
+		case protowire.BytesType:
+			f.b, n = protowire.ConsumeBytes(b)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		if typ == protowire.VarintType || typ == protowire.BytesType {
+			fields[num] = f
+		}
+	}
+	return fields, nil
+}
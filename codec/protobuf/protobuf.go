@@ -0,0 +1,111 @@
+// Package protobuf implements a mux.Pool encoding messages as protocol
+// buffers, so agents can be used by clients written in other languages.
+//
+// Messages follow the schema of quantum.proto. After the handshake (see
+// quantum.ClientHandshake), each message is a Message prefixed by its
+// varint encoded size. Each message is a header holding its type, followed
+// by the message itself, e.g. a request:
+//
+//	Message{header: Header{type: 67}}
+//	Message{request: Request{type: "build", data: "{\"branch\":\"master\"}"}}
+package protobuf
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+
+	"github.com/doubledutch/mux"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Name is the name of the codec in handshakes
+const Name = "protobuf"
+
+// MaxMessageSize is the size of the largest message decoded
+const MaxMessageSize = 64 << 20
+
+var (
+	// ErrUnsupportedType = the value has no message in the schema
+	ErrUnsupportedType = errors.New("Unsupported Type")
+	// ErrUnexpectedMessage = the message doesn't hold the decoded value
+	ErrUnexpectedMessage = errors.New("Unexpected Message")
+	// ErrMessageTooLarge = the message is larger than MaxMessageSize
+	ErrMessageTooLarge = errors.New("Message Too Large")
+)
+
+// Pool is a mux.Pool encoding messages as protocol buffers
+type Pool struct{}
+
+// Name returns the name of the codec
+func (p *Pool) Name() string {
+	return Name
+}
+
+// NewEncoder returns an Encoder writing messages to w
+func (p *Pool) NewEncoder(w io.Writer) mux.Encoder {
+	return encoder{w}
+}
+
+// NewDecoder returns a Decoder reading messages from r
+func (p *Pool) NewDecoder(r io.Reader) mux.Decoder {
+	return decoder{bufio.NewReader(r)}
+}
+
+// NewClient returns a mux.Client using the pool
+func (p *Pool) NewClient(conn net.Conn, config *mux.Config) (mux.Client, error) {
+	return mux.NewClient(conn, p, config)
+}
+
+// NewServer returns a mux.Server using the pool
+func (p *Pool) NewServer(conn net.Conn, config *mux.Config) (mux.Server, error) {
+	return mux.NewServer(conn, p, config)
+}
+
+// NewReceiver returns a mux.Receiver sending values to ch
+func (p *Pool) NewReceiver(ch interface{}) mux.Receiver {
+	return mux.NewReceiver(ch, p)
+}
+
+type encoder struct {
+	w io.Writer
+}
+
+// Encode writes v as a size prefixed Message
+func (e encoder) Encode(v interface{}) error {
+	msg, err := marshal(v)
+	if err != nil {
+		return err
+	}
+
+	b := protowire.AppendVarint(make([]byte, 0, len(msg)+binary.MaxVarintLen64), uint64(len(msg)))
+	_, err = e.w.Write(append(b, msg...))
+	return err
+}
+
+type decoder struct {
+	r *bufio.Reader
+}
+
+// Decode reads the next Message into v, discarding it if v is nil
+func (d decoder) Decode(v interface{}) error {
+	size, err := binary.ReadUvarint(d.r)
+	if err != nil {
+		return err
+	}
+	if size > MaxMessageSize {
+		return ErrMessageTooLarge
+	}
+
+	msg := make([]byte, size)
+	if _, err := io.ReadFull(d.r, msg); err != nil {
+		return err
+	}
+
+	if v == nil {
+		return nil
+	}
+	return unmarshal(msg, v)
+}
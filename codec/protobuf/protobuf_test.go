@@ -0,0 +1,76 @@
+package protobuf
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/doubledutch/quantum"
+)
+
+// header is like the header mux sends before each message
+type header struct {
+	Type uint8
+}
+
+func TestPool(t *testing.T) {
+	pool := new(Pool)
+	values := []interface{}{
+		header{Type: quantum.RequestType},
+		quantum.NewRequest("build", `{"branch":"master"}`),
+		"log",
+		"",
+		2,
+		[]byte("stdin"),
+		quantum.Error{Code: quantum.CodeJobNotFound, Message: "Job Not Found"},
+		quantum.WindowSize{Rows: 24, Cols: 80},
+		quantum.FileChunk{Name: "a.bin", Offset: 1 << 40, Data: []byte{0, 1, 2}, EOF: true, Checksum: "abc"},
+		quantum.Envelope{Stream: 3, Type: quantum.StreamDoneType, Data: []byte{1}},
+		quantum.NewStreamResult(quantum.ErrSigReceived),
+		quantum.NewStreamResult(nil),
+	}
+
+	var buf bytes.Buffer
+	enc := pool.NewEncoder(&buf)
+	for _, v := range values {
+		if err := enc.Encode(v); err != nil {
+			t.Fatalf("%T: %s", v, err)
+		}
+	}
+
+	dec := pool.NewDecoder(&buf)
+	for _, v := range values {
+		decoded := reflect.New(reflect.TypeOf(v))
+		if err := dec.Decode(decoded.Interface()); err != nil {
+			t.Fatalf("%T: %s", v, err)
+		}
+		if !reflect.DeepEqual(decoded.Elem().Interface(), v) {
+			t.Fatalf("expected %#v, got %#v", v, decoded.Elem().Interface())
+		}
+	}
+}
+
+func TestPoolDiscard(t *testing.T) {
+	pool := new(Pool)
+
+	var buf bytes.Buffer
+	enc := pool.NewEncoder(&buf)
+	enc.Encode("skipped")
+	enc.Encode(quantum.NewRequest("build", ""))
+
+	dec := pool.NewDecoder(&buf)
+	if err := dec.Decode(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// Messages only decode into the values they hold
+	var log string
+	if err := dec.Decode(&log); !errors.Is(err, ErrUnexpectedMessage) {
+		t.Fatalf("expected unexpected message, got %v", err)
+	}
+
+	if err := enc.Encode(struct{ Name string }{}); !errors.Is(err, ErrUnsupportedType) {
+		t.Fatalf("expected unsupported type, got %v", err)
+	}
+}
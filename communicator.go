@@ -15,6 +15,9 @@ type BasicCommunicator struct {
 	sync.WaitGroup
 	parent AgentConn
 	child  ClientConn
+
+	// Prefix is prepended to the logs of the child
+	Prefix string
 }
 
 // NewBasicCommunicator creates a new basic communicator between parent and child
@@ -27,6 +30,16 @@ func NewBasicCommunicator(parent AgentConn, child ClientConn) Communicator {
 	return bc
 }
 
+// NewPrefixCommunicator creates a basic communicator between parent and child,
+// prefixing the logs of child with prefix
+func NewPrefixCommunicator(parent AgentConn, child ClientConn, prefix string) Communicator {
+	return &BasicCommunicator{
+		parent: parent,
+		child:  child,
+		Prefix: prefix,
+	}
+}
+
 // Communicate faciliates communication the parent and child
 func (c *BasicCommunicator) Communicate(exitCh chan struct{}) {
 	c.DrainLogs()
@@ -42,9 +55,13 @@ func (c *BasicCommunicator) DrainLogs() {
 	LOOP:
 		for {
 			select {
-			case log := <-c.child.Logs():
-				c.parent.Logs() <- log
+			case log, ok := <-c.child.Logs():
+				if !ok {
+					break LOOP
+				}
+				c.parent.Logs() <- c.Prefix + log
 			case <-c.child.IsShutdown():
+				c.drainBuffered()
 				break LOOP
 			case <-c.parent.IsShutdown():
 				break LOOP
@@ -55,6 +72,21 @@ func (c *BasicCommunicator) DrainLogs() {
 	}()
 }
 
+// drainBuffered sends the logs still buffered by the child to the parent
+func (c *BasicCommunicator) drainBuffered() {
+	for {
+		select {
+		case log, ok := <-c.child.Logs():
+			if !ok {
+				return
+			}
+			c.parent.Logs() <- c.Prefix + log
+		default:
+			return
+		}
+	}
+}
+
 // ForwardSignals forwards signals from the parent to the child.
 // Forwarding stops when exit closes
 func (c *BasicCommunicator) ForwardSignals(exit chan struct{}) {
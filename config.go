@@ -9,6 +9,9 @@ import (
 	"github.com/doubledutch/mux/gob"
 )
 
+// DefaultDialTimeout is the default time to connect to an agent
+const DefaultDialTimeout = 5 * time.Second
+
 var (
 	// ErrInvalidLager = nil Lager
 	ErrInvalidLager = errors.New("Invalid Config Lager")
@@ -45,16 +48,35 @@ func DefaultConfig() *Config {
 
 // ConnConfig are configuration settings needed for Conn
 type ConnConfig struct {
-	Timeout time.Duration
+	Timeout     time.Duration
+	DialTimeout time.Duration
 	*Config
+
+	// Codecs are the codecs offered in handshakes, in order of preference.
+	// Defaults to Config.Pool as DefaultCodec.
+	Codecs []Codec
+	// Capabilities are offered in handshakes, defaulting to
+	// DefaultCapabilities
+	Capabilities Capabilities
 }
 
 // DefaultConnConfig is the default ConnConfig
 func DefaultConnConfig() *ConnConfig {
 	return &ConnConfig{
-		Timeout: 100 * time.Millisecond,
-		Config:  DefaultConfig(),
+		Timeout:     100 * time.Millisecond,
+		DialTimeout: DefaultDialTimeout,
+		Config:      DefaultConfig(),
+	}
+}
+
+// GetDialTimeout returns DialTimeout, or DefaultDialTimeout if DialTimeout
+// is not set. Timeout is too short for dialing remote agents.
+func (c *ConnConfig) GetDialTimeout() time.Duration {
+	if c.DialTimeout == 0 {
+		return DefaultDialTimeout
 	}
+
+	return c.DialTimeout
 }
 
 // ToMux creates a mux.Config from ConnConfig
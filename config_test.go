@@ -0,0 +1,18 @@
+package quantum
+
+import (
+	"testing"
+	"time"
+)
+
+func TestGetDialTimeout(t *testing.T) {
+	config := &ConnConfig{Timeout: 100 * time.Millisecond}
+	if timeout := config.GetDialTimeout(); timeout != DefaultDialTimeout {
+		t.Fatalf("expected default dial timeout, got %s", timeout)
+	}
+
+	config.DialTimeout = time.Second
+	if timeout := config.GetDialTimeout(); timeout != time.Second {
+		t.Fatalf("expected 1s, got %s", timeout)
+	}
+}
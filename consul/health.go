@@ -0,0 +1,257 @@
+package consul
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/doubledutch/lager"
+	"github.com/doubledutch/quantum"
+	"github.com/doubledutch/quantum/client"
+	"github.com/hashicorp/consul/api"
+)
+
+const (
+	// DefaultWaitTime is the default maximum duration of a blocking query
+	DefaultWaitTime = 5 * time.Minute
+	// DefaultWatchTTL is the default time types are watched once they're
+	// no longer resolved
+	DefaultWatchTTL = 10 * time.Minute
+	// retryInterval is the time to wait before retrying a failed query
+	retryInterval = time.Second
+)
+
+// HealthResolver is a client resolver that only resolves instances with
+// passing health checks. Instances of each type are cached locally, and kept
+// fresh with blocking queries against Consul's health endpoint. Types not
+// resolved within the WatchTTL are no longer watched.
+type HealthResolver struct {
+	config *quantum.ConnConfig
+	lgr    lager.Lager
+	httpc  *api.Client
+
+	datacenter string
+	tag        string
+	waitTime   time.Duration
+	watchTTL   time.Duration
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu      sync.Mutex
+	watches map[string]*healthWatch
+}
+
+// NewHealthResolver creates a HealthResolver using config
+func NewHealthResolver(rc *ResolverConfig) (*HealthResolver, error) {
+	config := connConfig(rc.ConnConfig)
+
+	httpc, err := api.NewClient(&api.Config{
+		Address: rc.HTTPAddr,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	tag := rc.Tag
+	if tag == "" {
+		tag = DefaultTag
+	}
+
+	waitTime := rc.WaitTime
+	if waitTime == 0 {
+		waitTime = DefaultWaitTime
+	}
+
+	watchTTL := rc.WatchTTL
+	if watchTTL == 0 {
+		watchTTL = DefaultWatchTTL
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r := &HealthResolver{
+		config:     config,
+		lgr:        config.Lager,
+		httpc:      httpc,
+		datacenter: rc.Datacenter,
+		tag:        tag,
+		waitTime:   waitTime,
+		watchTTL:   watchTTL,
+		ctx:        ctx,
+		cancel:     cancel,
+		watches:    make(map[string]*healthWatch),
+	}
+	go r.expire()
+
+	return r, nil
+}
+
+// Resolve resolves a ClientConn using a ResolveRequest
+func (r *HealthResolver) Resolve(request quantum.ResolveRequest) (quantum.ClientConn, error) {
+	candidates, err := r.Candidates(request)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(candidates) == 0 {
+		return nil, quantum.NoAgentsFromRequest(request)
+	}
+
+	addresses := make([]string, len(candidates))
+	for i, candidate := range candidates {
+		addresses[i] = candidate.Address
+	}
+
+	return quantum.DialFirst(client.New(r.config), addresses, r.config.GetDialTimeout())
+}
+
+// Candidates returns the passing instances of request.Type from the cache.
+// The first request of a type starts watching the type, and waits for the
+// first query to complete.
+func (r *HealthResolver) Candidates(request quantum.ResolveRequest) ([]quantum.Candidate, error) {
+	entries, err := r.watch(request.Type).get()
+	if err != nil {
+		return nil, err
+	}
+
+	results := newHealthResults(entries, request)
+	candidates := make([]quantum.Candidate, len(results))
+	for i, result := range results {
+		candidates[i] = quantum.Candidate{
+			Address: result.address,
+			Agent:   result.agent,
+		}
+	}
+
+	return candidates, nil
+}
+
+// Close stops watching Consul
+func (r *HealthResolver) Close() error {
+	r.cancel()
+	return nil
+}
+
+func (r *HealthResolver) watch(t string) *healthWatch {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	w, ok := r.watches[t]
+	if !ok {
+		w = &healthWatch{
+			ready: make(chan struct{}),
+		}
+		w.ctx, w.cancel = context.WithCancel(r.ctx)
+		r.watches[t] = w
+		go r.run(t, w)
+	}
+	w.used = time.Now()
+
+	return w
+}
+
+// expire stops the watches not used within the watch TTL, until the
+// resolver closes
+func (r *HealthResolver) expire() {
+	ticker := time.NewTicker(r.watchTTL / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-r.ctx.Done():
+			return
+		}
+
+		r.mu.Lock()
+		for t, w := range r.watches {
+			if time.Since(w.used) > r.watchTTL {
+				w.cancel()
+				delete(r.watches, t)
+			}
+		}
+		r.mu.Unlock()
+	}
+}
+
+// run keeps w fresh with blocking queries until it's stopped
+func (r *HealthResolver) run(t string, w *healthWatch) {
+	var index uint64
+	for {
+		opts := &api.QueryOptions{
+			Datacenter: r.datacenter,
+			WaitIndex:  index,
+			WaitTime:   r.waitTime,
+		}
+
+		entries, meta, err := r.httpc.Health().Service(t, r.tag, true, opts.WithContext(w.ctx))
+		if w.ctx.Err() != nil {
+			return
+		}
+
+		if err != nil {
+			r.lgr.Errorf("Health query for %s failed: %s\n", t, err)
+			w.fail(err)
+
+			select {
+			case <-time.After(retryInterval):
+			case <-w.ctx.Done():
+				return
+			}
+			continue
+		}
+
+		// The index going backwards means Consul's state was reset
+		if meta.LastIndex < index {
+			index = 0
+		} else {
+			index = meta.LastIndex
+		}
+		w.set(entries)
+	}
+}
+
+// healthWatch holds the cached instances of a type
+type healthWatch struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	// used is when the watch was last used, guarded by the resolver
+	used time.Time
+
+	ready     chan struct{}
+	readyOnce sync.Once
+
+	mu      sync.RWMutex
+	entries []*api.ServiceEntry
+	err     error
+}
+
+func (w *healthWatch) get() ([]*api.ServiceEntry, error) {
+	select {
+	case <-w.ready:
+	case <-w.ctx.Done():
+		return nil, w.ctx.Err()
+	}
+
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return w.entries, w.err
+}
+
+func (w *healthWatch) set(entries []*api.ServiceEntry) {
+	w.mu.Lock()
+	w.entries = entries
+	w.err = nil
+	w.mu.Unlock()
+	w.readyOnce.Do(func() { close(w.ready) })
+}
+
+// fail records err, keeping previously cached instances if any
+func (w *healthWatch) fail(err error) {
+	w.mu.Lock()
+	if w.entries == nil {
+		w.err = err
+	}
+	w.mu.Unlock()
+	w.readyOnce.Do(func() { close(w.ready) })
+}
@@ -0,0 +1,179 @@
+package consul
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/doubledutch/quantum"
+	"github.com/hashicorp/consul/api"
+)
+
+// fakeConsul serves /v1/health/service/ with blocking queries
+type fakeConsul struct {
+	t *testing.T
+
+	mu      sync.Mutex
+	changed chan struct{}
+	index   uint64
+	entries []*api.ServiceEntry
+}
+
+func newFakeConsul(t *testing.T) *fakeConsul {
+	return &fakeConsul{
+		t:       t,
+		changed: make(chan struct{}),
+		index:   1,
+	}
+}
+
+func (c *fakeConsul) set(entries []*api.ServiceEntry) {
+	c.mu.Lock()
+	c.entries = entries
+	c.index++
+	close(c.changed)
+	c.changed = make(chan struct{})
+	c.mu.Unlock()
+}
+
+func (c *fakeConsul) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if !strings.HasPrefix(req.URL.Path, "/v1/health/service/") {
+		http.NotFound(w, req)
+		return
+	}
+
+	query := req.URL.Query()
+	if _, ok := query["passing"]; !ok {
+		c.t.Error("expected passing only query")
+	}
+
+	c.mu.Lock()
+	index, changed := c.index, c.changed
+	c.mu.Unlock()
+
+	// Block while the client is up to date
+	if wait, _ := strconv.ParseUint(query.Get("index"), 10, 64); wait >= index {
+		select {
+		case <-changed:
+		case <-time.After(time.Second):
+		case <-req.Context().Done():
+			return
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	w.Header().Set("X-Consul-Index", strconv.FormatUint(c.index, 10))
+	json.NewEncoder(w).Encode(c.entries)
+}
+
+func testEntry(node, address string) *api.ServiceEntry {
+	return &api.ServiceEntry{
+		Node:    &api.Node{Node: node, Address: address},
+		Service: &api.AgentService{Service: "build", Port: 1234, Tags: []string{"quantum"}},
+	}
+}
+
+func TestHealthResolver(t *testing.T) {
+	consul := newFakeConsul(t)
+	consul.set([]*api.ServiceEntry{testEntry("one", "10.0.0.1")})
+	srv := httptest.NewServer(consul)
+	defer srv.Close()
+
+	r, err := NewHealthResolver(&ResolverConfig{
+		HTTPAddr: strings.TrimPrefix(srv.URL, "http://"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	candidates, err := r.Candidates(quantum.ResolveRequest{Type: "build"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(candidates) != 1 || candidates[0].Address != "10.0.0.1:1234" || candidates[0].Agent != "one" {
+		t.Fatalf("wrong candidates: %v", candidates)
+	}
+
+	// The blocking query picks up the new instance
+	consul.set([]*api.ServiceEntry{testEntry("one", "10.0.0.1"), testEntry("two", "10.0.0.2")})
+	deadline := time.Now().Add(time.Second)
+	for len(candidates) != 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+		candidates, _ = r.Candidates(quantum.ResolveRequest{Type: "build"})
+	}
+	if len(candidates) != 2 {
+		t.Fatal("expected the cache to be updated")
+	}
+
+	candidates, _ = r.Candidates(quantum.ResolveRequest{Type: "build", Agent: "two"})
+	if len(candidates) != 1 || candidates[0].Agent != "two" {
+		t.Fatalf("wrong candidates: %v", candidates)
+	}
+}
+
+func TestHealthResolverNoAgents(t *testing.T) {
+	srv := httptest.NewServer(newFakeConsul(t))
+	defer srv.Close()
+
+	r, err := NewHealthResolver(&ResolverConfig{
+		HTTPAddr: strings.TrimPrefix(srv.URL, "http://"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	if _, err := r.Resolve(quantum.ResolveRequest{Type: "build"}); err == nil || !quantum.IsNoAgentsErr(err) {
+		t.Fatalf("expected no agents error, got %v", err)
+	}
+}
+
+func TestHealthResolverExpiresWatches(t *testing.T) {
+	consul := newFakeConsul(t)
+	consul.set([]*api.ServiceEntry{testEntry("one", "10.0.0.1")})
+	srv := httptest.NewServer(consul)
+	defer srv.Close()
+
+	r, err := NewHealthResolver(&ResolverConfig{
+		HTTPAddr: strings.TrimPrefix(srv.URL, "http://"),
+		WatchTTL: 50 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	if _, err := r.Candidates(quantum.ResolveRequest{Type: "build"}); err != nil {
+		t.Fatal(err)
+	}
+
+	watches := func() int {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		return len(r.watches)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for watches() != 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if watches() != 0 {
+		t.Fatal("expected the unused watch to stop")
+	}
+
+	// Types are watched again once resolved
+	candidates, err := r.Candidates(quantum.ResolveRequest{Type: "build"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(candidates) != 1 || watches() != 1 {
+		t.Fatalf("expected a new watch, got %v", candidates)
+	}
+}
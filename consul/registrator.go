@@ -1,6 +1,14 @@
 package consul
 
 import (
+	"errors"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
 	"code.google.com/p/go-uuid/uuid"
 	"github.com/doubledutch/lager"
 	"github.com/doubledutch/quantum"
@@ -8,28 +16,120 @@ import (
 	"github.com/hashicorp/go-multierror"
 )
 
-// NewRegistrator creates a Registrator
+const (
+	// DefaultCheckTTL is the default TTL of service checks
+	DefaultCheckTTL = 15 * time.Second
+	// DefaultDeregisterCriticalAfter is the default time after which Consul
+	// deregisters critical services
+	DefaultDeregisterCriticalAfter = time.Minute
+)
+
+// RegistratorConfig configures a Registrator
+type RegistratorConfig struct {
+	HTTPAddr string
+	Lager    lager.Lager
+
+	// CheckTTL is the TTL of the check of each service. The agent heartbeats
+	// the check while healthy. Ignored when CheckTCP is set.
+	CheckTTL time.Duration
+	// CheckTCP registers a TCP check every CheckInterval instead of a TTL check
+	CheckTCP      bool
+	CheckInterval time.Duration
+	// CheckAddress is the host Consul connects to for TCP checks,
+	// defaulting to 127.0.0.1 since registration relies on a local agent.
+	CheckAddress string
+	// DeregisterCriticalAfter removes services critical for longer than it.
+	// Services are never removed when it's negative.
+	DeregisterCriticalAfter time.Duration
+}
+
+// DefaultRegistratorConfig is the default RegistratorConfig
+func DefaultRegistratorConfig(httpAddr string, lgr lager.Lager) *RegistratorConfig {
+	return &RegistratorConfig{
+		HTTPAddr:                httpAddr,
+		Lager:                   lgr,
+		CheckTTL:                DefaultCheckTTL,
+		CheckInterval:           DefaultCheckTTL,
+		CheckAddress:            "127.0.0.1",
+		DeregisterCriticalAfter: DefaultDeregisterCriticalAfter,
+	}
+}
+
+// NewRegistrator creates a Registrator with TTL checks
 func NewRegistrator(httpAddr string, lgr lager.Lager) quantum.Registrator {
+	return NewRegistratorWithConfig(DefaultRegistratorConfig(httpAddr, lgr))
+}
+
+// NewRegistratorWithConfig creates a Registrator using config. Zero
+// durations and CheckAddress are set to those of DefaultRegistratorConfig.
+func NewRegistratorWithConfig(config *RegistratorConfig) quantum.Registrator {
+	if config.CheckTTL == 0 {
+		config.CheckTTL = DefaultCheckTTL
+	}
+
+	if config.CheckInterval == 0 {
+		config.CheckInterval = DefaultCheckTTL
+	}
+
+	if config.CheckAddress == "" {
+		config.CheckAddress = "127.0.0.1"
+	}
+
+	if config.DeregisterCriticalAfter == 0 {
+		config.DeregisterCriticalAfter = DefaultDeregisterCriticalAfter
+	}
+
 	return &Registrator{
-		httpAddr: httpAddr,
-		lgr:      lgr,
+		httpAddr: config.HTTPAddr,
+		config:   config,
+		lgr:      config.Lager,
 	}
 }
 
 // Registrator uses consul to implement quantum.Registrator
 type Registrator struct {
-	serviceIDs []string
-	httpAddr   string
+	services []*api.AgentServiceRegistration
+	httpAddr string
+	labels   quantum.Labels
+	config   *RegistratorConfig
 
+	// mu keeps heartbeats from registering services again once they're
+	// deregistered
+	mu     sync.Mutex
 	client *api.Client
+	stop   chan struct{}
 
 	lgr lager.Lager
 }
 
-// Register will register types with Consul
-func (r *Registrator) Register(port int, reg quantum.Registry) error {
+// SetLabels sets the labels advertised for each service. Labels are
+// registered as service metadata, and as key=value tags.
+func (r *Registrator) SetLabels(labels quantum.Labels) {
+	r.labels = labels
+}
+
+// Register will register types with Consul. TTL checks are heartbeated
+// until Deregister, reporting the health of reg if it implements
+// quantum.HealthChecker. Agents at capacity report a warning, so they stay
+// registered while resolvers of passing instances skip them. Services
+// Consul deregisters, such as those critical for DeregisterCriticalAfter,
+// are registered again by the next heartbeat. Agents must listen on TCP,
+// and services are registered with the IP the agent is bound to, if any.
+func (r *Registrator) Register(addr net.Addr, reg quantum.Registry) error {
+	port, err := quantum.TCPPort(addr)
+	if err != nil {
+		return err
+	}
+
+	var address string
+	if ip := addr.(*net.TCPAddr).IP; ip != nil && !ip.IsUnspecified() {
+		address = ip.String()
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
 	if r.client == nil {
-		var err error
 		r.client, err = api.NewClient(&api.Config{
 			Address: r.httpAddr,
 		})
@@ -46,31 +146,168 @@ func (r *Registrator) Register(port int, reg quantum.Registry) error {
 		ID := uuid.New()
 		// We may need to set the ID ourselves to guarantee it's unique
 		service := &api.AgentServiceRegistration{
-			ID:   ID,
-			Name: jobType,
-			Port: port,
-			Tags: []string{"quantum"},
+			ID:      ID,
+			Name:    jobType,
+			Address: address,
+			Port:    port,
+			Tags:    append([]string{"quantum"}, labelTags(r.labels)...),
+			Meta:    r.labels,
+			Check:   r.newCheck(ID, port),
 		}
 		if err := agent.ServiceRegister(service); err != nil {
 			multierror.Append(merr, err)
 		} else {
-			r.serviceIDs = append(r.serviceIDs, ID)
+			r.services = append(r.services, service)
 		}
 	}
 
+	if !r.config.CheckTCP && len(r.services) > 0 {
+		hc, ok := reg.(quantum.HealthChecker)
+		if !ok {
+			hc = alwaysHealthy{}
+		}
+		// A single heartbeat updates the services of every Register
+		if r.stop != nil {
+			close(r.stop)
+		}
+		r.stop = make(chan struct{})
+		services := append([]*api.AgentServiceRegistration(nil), r.services...)
+		go r.heartbeat(hc, services, r.stop)
+	}
+
 	return merr.ErrorOrNil()
 }
 
-// Deregister deregisters our serviceIDs with Consul
+// newCheck creates the check of the service with serviceID
+func (r *Registrator) newCheck(serviceID string, port int) *api.AgentServiceCheck {
+	check := &api.AgentServiceCheck{
+		CheckID: checkID(serviceID),
+	}
+
+	if r.config.DeregisterCriticalAfter > 0 {
+		check.DeregisterCriticalServiceAfter = r.config.DeregisterCriticalAfter.String()
+	}
+
+	if r.config.CheckTCP {
+		check.TCP = net.JoinHostPort(r.config.CheckAddress, strconv.Itoa(port))
+		check.Interval = r.config.CheckInterval.String()
+		return check
+	}
+
+	check.TTL = r.config.CheckTTL.String()
+	return check
+}
+
+func checkID(serviceID string) string {
+	return "service:" + serviceID
+}
+
+// alwaysHealthy is the HealthChecker of registries without health
+type alwaysHealthy struct{}
+
+func (alwaysHealthy) Healthy() error {
+	return nil
+}
+
+// heartbeat updates TTL checks with the health of hc until stop closes
+func (r *Registrator) heartbeat(hc quantum.HealthChecker, services []*api.AgentServiceRegistration, stop chan struct{}) {
+	// Heartbeat well within the TTL so a late update doesn't fail the check
+	ticker := time.NewTicker(r.config.CheckTTL / 3)
+	defer ticker.Stop()
+
+	for {
+		r.mu.Lock()
+		select {
+		case <-stop:
+			r.mu.Unlock()
+			return
+		default:
+		}
+		r.updateChecks(services, hc.Healthy())
+		r.mu.Unlock()
+
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+	}
+}
+
+func (r *Registrator) updateChecks(services []*api.AgentServiceRegistration, health error) {
+	agent := r.client.Agent()
+	for _, service := range services {
+		err := updateCheck(agent, service.ID, health)
+		if err != nil {
+			// The check is gone once Consul deregisters the service
+			r.lgr.Infof("Registering %s again: %s\n", service.ID, err)
+			if err = agent.ServiceRegister(service); err == nil {
+				err = updateCheck(agent, service.ID, health)
+			}
+		}
+
+		if err != nil {
+			r.lgr.Errorf("Unable to update check of %s: %s\n", service.ID, err)
+		}
+	}
+}
+
+// updateCheck reports health to the check of the service with serviceID
+func updateCheck(agent *api.Agent, serviceID string, health error) error {
+	switch {
+	case health == nil:
+		return agent.PassTTL(checkID(serviceID), "")
+	case errors.Is(health, quantum.ErrAtCapacity):
+		return agent.WarnTTL(checkID(serviceID), health.Error())
+	default:
+		return agent.FailTTL(checkID(serviceID), health.Error())
+	}
+}
+
+// Deregister deregisters our services with Consul
 func (r *Registrator) Deregister() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.stop != nil {
+		close(r.stop)
+		r.stop = nil
+	}
+
+	if r.client == nil {
+		return nil
+	}
+
 	merr := &multierror.Error{}
 	agent := r.client.Agent()
 
-	for _, serviceID := range r.serviceIDs {
-		if err := agent.ServiceDeregister(serviceID); err != nil {
+	for _, service := range r.services {
+		if err := agent.ServiceDeregister(service.ID); err != nil {
 			multierror.Append(merr, err)
 		}
 	}
+	r.services = nil
 
 	return merr.ErrorOrNil()
 }
+
+// labelTags returns labels as key=value tags
+func labelTags(labels quantum.Labels) []string {
+	tags := make([]string, 0, len(labels))
+	for k, v := range labels {
+		tags = append(tags, k+"="+v)
+	}
+	sort.Strings(tags)
+	return tags
+}
+
+// tagLabels parses key=value tags into labels
+func tagLabels(tags []string) quantum.Labels {
+	labels := make(quantum.Labels)
+	for _, tag := range tags {
+		if kv := strings.SplitN(tag, "=", 2); len(kv) == 2 {
+			labels[kv[0]] = kv[1]
+		}
+	}
+	return labels
+}
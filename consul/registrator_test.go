@@ -0,0 +1,213 @@
+package consul
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/doubledutch/lager"
+	"github.com/doubledutch/quantum"
+	"github.com/doubledutch/quantum/inmemory"
+	"github.com/hashicorp/consul/api"
+)
+
+func TestNewCheckTTL(t *testing.T) {
+	r := NewRegistrator("", lager.NewLogLager(nil)).(*Registrator)
+
+	check := r.newCheck("id", 8500)
+	if check.CheckID != "service:id" {
+		t.Fatal("wrong check id")
+	}
+	if check.TTL != DefaultCheckTTL.String() || check.TCP != "" {
+		t.Fatal("expected TTL check")
+	}
+	if check.DeregisterCriticalServiceAfter != DefaultDeregisterCriticalAfter.String() {
+		t.Fatal("wrong deregister critical after")
+	}
+}
+
+func TestNewCheckTCP(t *testing.T) {
+	config := DefaultRegistratorConfig("", lager.NewLogLager(nil))
+	config.CheckTCP = true
+	config.CheckInterval = 5 * time.Second
+	r := NewRegistratorWithConfig(config).(*Registrator)
+
+	check := r.newCheck("id", 8500)
+	if check.TCP != "127.0.0.1:8500" || check.Interval != "5s" || check.TTL != "" {
+		t.Fatal("expected TCP check")
+	}
+}
+
+func TestLabelTags(t *testing.T) {
+	labels := quantum.Labels{"env": "prod", "region": "eu"}
+
+	tags := labelTags(labels)
+	if len(tags) != 2 || tags[0] != "env=prod" || tags[1] != "region=eu" {
+		t.Fatalf("wrong tags: %v", tags)
+	}
+
+	if parsed := tagLabels(append(tags, "quantum")); parsed.String() != labels.String() {
+		t.Fatalf("wrong labels: %v", parsed)
+	}
+}
+
+func TestNewRegistratorDefaults(t *testing.T) {
+	r := NewRegistratorWithConfig(&RegistratorConfig{Lager: lager.NewLogLager(nil)}).(*Registrator)
+
+	check := r.newCheck("id", 8500)
+	if check.TTL != DefaultCheckTTL.String() {
+		t.Fatalf("expected default TTL, got %s", check.TTL)
+	}
+	if check.DeregisterCriticalServiceAfter != DefaultDeregisterCriticalAfter.String() {
+		t.Fatal("wrong deregister critical after")
+	}
+	if r.config.CheckInterval != DefaultCheckTTL || r.config.CheckAddress != "127.0.0.1" {
+		t.Fatal("expected default TCP check config")
+	}
+}
+
+// fakeAgent serves the service and TTL check endpoints of a Consul agent
+type fakeAgent struct {
+	mu            sync.Mutex
+	registrations int
+	checks        map[string]string
+}
+
+func (a *fakeAgent) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	path := req.URL.Path
+	switch {
+	case path == "/v1/agent/service/register":
+		var service api.AgentServiceRegistration
+		json.NewDecoder(req.Body).Decode(&service)
+		a.registrations++
+		a.checks[checkID(service.ID)] = "critical"
+	case strings.HasPrefix(path, "/v1/agent/service/deregister/"):
+		delete(a.checks, checkID(strings.TrimPrefix(path, "/v1/agent/service/deregister/")))
+	case strings.HasPrefix(path, "/v1/agent/check/"):
+		parts := strings.SplitN(strings.TrimPrefix(path, "/v1/agent/check/"), "/", 2)
+		if _, ok := a.checks[parts[1]]; !ok {
+			http.Error(w, "CheckID does not have associated TTL", http.StatusInternalServerError)
+			return
+		}
+		a.checks[parts[1]] = parts[0]
+	default:
+		http.NotFound(w, req)
+	}
+}
+
+// status returns the status of the only check, and the registrations
+func (a *fakeAgent) status() (string, int) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, status := range a.checks {
+		return status, a.registrations
+	}
+	return "", a.registrations
+}
+
+// deregisterAll deregisters every service, like Consul does with services
+// critical for too long
+func (a *fakeAgent) deregisterAll() {
+	a.mu.Lock()
+	a.checks = make(map[string]string)
+	a.mu.Unlock()
+}
+
+// testJob is a job of typ
+type testJob struct {
+	typ string
+}
+
+func (j *testJob) Type() string {
+	return j.typ
+}
+
+func (j *testJob) Configure(p []byte) error {
+	return nil
+}
+
+func (j *testJob) Run(conn quantum.AgentConn) error {
+	return nil
+}
+
+// testHealthRegistry is a registry reporting the health it's set to
+type testHealthRegistry struct {
+	quantum.Registry
+
+	mu     sync.Mutex
+	health error
+}
+
+func (r *testHealthRegistry) set(health error) {
+	r.mu.Lock()
+	r.health = health
+	r.mu.Unlock()
+}
+
+func (r *testHealthRegistry) Healthy() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.health
+}
+
+func TestHeartbeat(t *testing.T) {
+	consul := &fakeAgent{checks: make(map[string]string)}
+	srv := httptest.NewServer(consul)
+	defer srv.Close()
+
+	lgr := lager.NewLogLager(nil)
+	config := DefaultRegistratorConfig(strings.TrimPrefix(srv.URL, "http://"), lgr)
+	config.CheckTTL = 30 * time.Millisecond
+	r := NewRegistratorWithConfig(config)
+
+	reg := &testHealthRegistry{Registry: inmemory.NewRegistry(lgr)}
+	reg.Add(&testJob{typ: "build"})
+
+	waitFor := func(status string, registrations int) {
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			s, n := consul.status()
+			if s == status && n == registrations {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("expected %s after %d registrations, got %s after %d", status, registrations, s, n)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+
+	if err := r.Register(&net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1234}, reg); err != nil {
+		t.Fatal(err)
+	}
+	waitFor("pass", 1)
+
+	// Busy agents warn rather than fail, so they aren't deregistered
+	reg.set(quantum.ErrAtCapacity)
+	waitFor("warn", 1)
+	reg.set(nil)
+	waitFor("pass", 1)
+
+	reg.set(errors.New("Unhealthy"))
+	waitFor("fail", 1)
+
+	// Deregistered services are registered again
+	consul.deregisterAll()
+	reg.set(nil)
+	waitFor("pass", 2)
+
+	if err := r.Deregister(); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(3 * config.CheckTTL)
+	waitFor("", 2)
+}
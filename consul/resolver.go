@@ -1,9 +1,12 @@
 package consul
 
 import (
-	"fmt"
+	"encoding/hex"
+	"net"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/doubledutch/lager"
 	"github.com/doubledutch/quantum"
@@ -14,23 +17,82 @@ import (
 
 type resolveResult struct {
 	address string
+	agent   string
+}
+
+const (
+	// DefaultDomain is the default domain of Consul DNS
+	DefaultDomain = "consul"
+	// DefaultTag is the tag quantum services are registered with
+	DefaultTag = "quantum"
+)
+
+// ResolverConfig configures a ClientResolver
+type ResolverConfig struct {
+	HTTPAddr string
+	DNSAddr  string
+
+	// Domain is the domain of Consul DNS, defaulting to DefaultDomain
+	Domain string
+	// Datacenter to resolve in, defaulting to the datacenter of the Consul agent
+	Datacenter string
+	// Tag filters services by tag. DNS lookups are unfiltered when empty,
+	// catalog lookups default to DefaultTag.
+	Tag string
+	// WaitTime is the maximum duration of the blocking queries of a
+	// HealthResolver, defaulting to DefaultWaitTime
+	WaitTime time.Duration
+	// WatchTTL is how long a HealthResolver watches a type that isn't
+	// resolved, defaulting to DefaultWatchTTL
+	WatchTTL time.Duration
+
+	ConnConfig *quantum.ConnConfig
 }
 
 // NewClientResolver creates a consul client resolver
 func NewClientResolver(httpAddr, dnsAddr string, config *quantum.ConnConfig) quantum.ClientResolver {
-	if config == nil {
-		config = quantum.DefaultConnConfig()
-	}
-	if config.Config == nil {
-		config.Config = quantum.DefaultConfig()
+	return NewClientResolverWithConfig(&ResolverConfig{
+		HTTPAddr:   httpAddr,
+		DNSAddr:    dnsAddr,
+		ConnConfig: config,
+	})
+}
+
+// NewClientResolverWithConfig creates a consul client resolver using config
+func NewClientResolverWithConfig(rc *ResolverConfig) *ClientResolver {
+	config := connConfig(rc.ConnConfig)
+
+	domain := strings.Trim(rc.Domain, ".")
+	if domain == "" {
+		domain = DefaultDomain
 	}
+
 	return &ClientResolver{
-		config:   config,
-		httpAddr: httpAddr,
-		dnsAddr:  dnsAddr,
+		config:     config,
+		lgr:        config.Lager,
+		httpAddr:   rc.HTTPAddr,
+		dnsAddr:    rc.DNSAddr,
+		domain:     domain,
+		datacenter: rc.Datacenter,
+		tag:        rc.Tag,
+		dnsc:       &dns.Client{Net: "tcp"},
 	}
 }
 
+// connConfig returns a copy of config with defaults set, leaving config to
+// its caller
+func connConfig(config *quantum.ConnConfig) *quantum.ConnConfig {
+	if config == nil {
+		return quantum.DefaultConnConfig()
+	}
+
+	c := *config
+	if c.Config == nil {
+		c.Config = quantum.DefaultConfig()
+	}
+	return &c
+}
+
 // ClientResolver is a client resolver that leverages Consul's service discovery.
 type ClientResolver struct {
 	config *quantum.ConnConfig
@@ -39,8 +101,15 @@ type ClientResolver struct {
 	httpAddr string
 	dnsAddr  string
 
-	dnsc  *dns.Client
-	httpc *api.Client
+	domain     string
+	datacenter string
+	tag        string
+
+	dnsc *dns.Client
+
+	httpOnce sync.Once
+	httpc    *api.Client
+	httpErr  error
 }
 
 // Resolve resolves a ClientConn using a ResolveRequest
@@ -59,8 +128,31 @@ func (cr *ClientResolver) Resolve(request quantum.ResolveRequest) (quantum.Clien
 	return cr.resolveClient(results)
 }
 
+// Candidates returns the address of every agent that matches request
+func (cr *ClientResolver) Candidates(request quantum.ResolveRequest) ([]quantum.Candidate, error) {
+	results, err := cr.resolveResults(request)
+	if err != nil {
+		return nil, err
+	}
+
+	candidates := make([]quantum.Candidate, len(results))
+	for i, result := range results {
+		candidates[i] = quantum.Candidate{
+			Address: result.address,
+			Agent:   result.agent,
+		}
+	}
+
+	return candidates, nil
+}
+
 // ResolveConfigs resolves client configs given the specified arguments
 func (cr *ClientResolver) resolveResults(rr quantum.ResolveRequest) (results []resolveResult, err error) {
+	// Labels are only available from the catalog
+	if len(rr.Selector) > 0 {
+		return cr.resolveWithCatalog(rr)
+	}
+
 	if rr.Agent == "" {
 		return cr.resolveWithDNS(rr)
 	}
@@ -68,63 +160,225 @@ func (cr *ClientResolver) resolveResults(rr quantum.ResolveRequest) (results []r
 	return cr.resolveWithAPI(rr)
 }
 
+// resolveWithDNS resolves rr with an SRV lookup, falling back to the health
+// API when DNS returns no results.
 func (cr *ClientResolver) resolveWithDNS(rr quantum.ResolveRequest) (results []resolveResult, err error) {
 	m := new(dns.Msg)
-	// For now, assume .service.consul. for domain
-	srv := rr.Type + ".service.consul."
-	m.SetQuestion(srv, dns.TypeSRV)
+	m.SetQuestion(cr.srvName(rr.Type), dns.TypeSRV)
 
-	if cr.dnsc == nil {
-		cr.dnsc = &dns.Client{Net: "tcp"}
-	}
 	in, _, err := cr.dnsc.Exchange(m, cr.dnsAddr)
 	if err != nil {
 		cr.lgr.Errorf("DNS Exchange failed: %s\n", err)
 		return nil, err
 	}
 
-	return newResolveResults(in, rr), nil
+	results = newResolveResults(in, rr, cr.domain)
+	if len(results) > 0 || cr.httpAddr == "" {
+		return results, nil
+	}
+
+	cr.lgr.Debugf("No DNS results for %s, falling back to health API", rr.Type)
+	return cr.resolveWithHealth(rr)
 }
 
-func newResolveResults(in *dns.Msg, rr quantum.ResolveRequest) (results []resolveResult) {
-	for i, a := range in.Answer {
-		srv := a.(*dns.SRV)
-		if rr.Agent != "" {
-			// We were given an agent name, match it to the hostname of the SRV record
-			targetSplit := strings.Split(srv.Target, ".")
-			if len(targetSplit) < 5 {
-				// We expect name.node.dc1.consul. (5)
-				continue
-			}
-			// Drop .node.dc1.consul.
-			hostnameSplit := targetSplit[:len(targetSplit)-4]
-			hostname := strings.Join(hostnameSplit, ".")
-			if hostname != rr.Agent {
-				continue
+// srvName returns [tag.]type.service[.datacenter].domain.
+func (cr *ClientResolver) srvName(t string) string {
+	name := t + ".service."
+	if cr.tag != "" {
+		name = cr.tag + "." + name
+	}
+	if cr.datacenter != "" {
+		name += cr.datacenter + "."
+	}
+	return name + cr.domain + "."
+}
+
+// newResolveResults creates results from the SRV answers of in. Targets are
+// matched by name to the A and AAAA records in in.Extra.
+func newResolveResults(in *dns.Msg, rr quantum.ResolveRequest, domain string) (results []resolveResult) {
+	addrs := make(map[string]net.IP)
+	for _, extra := range in.Extra {
+		if ip, ok := extraIP(extra); ok {
+			name := strings.ToLower(extra.Header().Name)
+			if _, ok := addrs[name]; !ok {
+				addrs[name] = ip
 			}
 		}
-		a := in.Extra[i].(*dns.A)
-		sPort := ":" + strconv.Itoa(int(srv.Port))
+	}
+
+	for i, a := range in.Answer {
+		srv, ok := a.(*dns.SRV)
+		if !ok {
+			continue
+		}
+
+		hostname, ip := parseTarget(srv.Target, domain)
+		// We were given an agent name, match it to the hostname of the SRV record
+		if rr.Agent != "" && !strings.EqualFold(hostname, rr.Agent) {
+			continue
+		}
+
+		found := ip != nil
+		if addr, ok := addrs[strings.ToLower(srv.Target)]; ok {
+			ip, found = addr, true
+		} else if !found && i < len(in.Extra) && in.Extra[i].Header().Name == "" {
+			// Unnamed extras are paired by index
+			ip, found = extraIP(in.Extra[i])
+		}
+		if !found {
+			continue
+		}
+
 		results = append(results, resolveResult{
-			address: a.A.String() + sPort,
+			address: net.JoinHostPort(ip.String(), strconv.Itoa(int(srv.Port))),
+			agent:   hostname,
 		})
 	}
 	return
 }
 
-func (cr *ClientResolver) resolveWithAPI(rr quantum.ResolveRequest) (results []resolveResult, err error) {
-	if cr.httpc == nil {
-		cr.httpc, err = api.NewClient(&api.Config{
-			Address: cr.httpAddr,
+func extraIP(rr dns.RR) (net.IP, bool) {
+	switch a := rr.(type) {
+	case *dns.A:
+		return a.A, true
+	case *dns.AAAA:
+		return a.AAAA, true
+	}
+	return nil, false
+}
+
+// parseTarget parses an SRV target. name.node.dc.domain. targets return the
+// node name, hex.addr.dc.domain. targets return the encoded IP.
+func parseTarget(target, domain string) (hostname string, ip net.IP) {
+	target = strings.TrimSuffix(strings.TrimSuffix(target, "."), "."+domain)
+	parts := strings.Split(target, ".")
+	if len(parts) < 3 {
+		return "", nil
+	}
+
+	switch parts[len(parts)-2] {
+	case "node":
+		hostname = strings.Join(parts[:len(parts)-2], ".")
+	case "addr":
+		if b, err := hex.DecodeString(parts[0]); err == nil && (len(b) == net.IPv4len || len(b) == net.IPv6len) {
+			ip = net.IP(b)
+		}
+	}
+	return
+}
+
+// resolveWithHealth resolves the passing services of rr.Type
+func (cr *ClientResolver) resolveWithHealth(rr quantum.ResolveRequest) (results []resolveResult, err error) {
+	if err := cr.initHTTP(); err != nil {
+		return nil, err
+	}
+
+	entries, _, err := cr.httpc.Health().Service(rr.Type, cr.catalogTag(), true, cr.queryOptions())
+	if err != nil {
+		return nil, err
+	}
+
+	return newHealthResults(entries, rr), nil
+}
+
+func newHealthResults(entries []*api.ServiceEntry, rr quantum.ResolveRequest) (results []resolveResult) {
+	for _, entry := range entries {
+		if rr.Agent != "" && !strings.EqualFold(entry.Node.Node, rr.Agent) {
+			continue
+		}
+
+		labels := quantum.Labels(entry.Service.Meta)
+		if len(labels) == 0 {
+			labels = tagLabels(entry.Service.Tags)
+		}
+		if !rr.Selector.Matches(labels) {
+			continue
+		}
+
+		address := entry.Service.Address
+		if address == "" {
+			address = entry.Node.Address
+		}
+		results = append(results, resolveResult{
+			address: net.JoinHostPort(address, strconv.Itoa(entry.Service.Port)),
+			agent:   entry.Node.Node,
 		})
-		if err != nil {
-			return nil, err
+	}
+	return
+}
+
+func (cr *ClientResolver) catalogTag() string {
+	if cr.tag == "" {
+		return DefaultTag
+	}
+	return cr.tag
+}
+
+func (cr *ClientResolver) queryOptions() *api.QueryOptions {
+	return &api.QueryOptions{
+		Datacenter: cr.datacenter,
+	}
+}
+
+// resolveWithCatalog resolves the services of rr.Type whose labels match
+// rr.Selector, optionally on the node named rr.Agent.
+func (cr *ClientResolver) resolveWithCatalog(rr quantum.ResolveRequest) (results []resolveResult, err error) {
+	if err := cr.initHTTP(); err != nil {
+		return nil, err
+	}
+
+	services, _, err := cr.httpc.Catalog().Service(rr.Type, cr.catalogTag(), cr.queryOptions())
+	if err != nil {
+		return nil, err
+	}
+
+	return newCatalogResults(services, rr), nil
+}
+
+func newCatalogResults(services []*api.CatalogService, rr quantum.ResolveRequest) (results []resolveResult) {
+	for _, service := range services {
+		if rr.Agent != "" && !strings.EqualFold(service.Node, rr.Agent) {
+			continue
+		}
+
+		labels := quantum.Labels(service.ServiceMeta)
+		if len(labels) == 0 {
+			labels = tagLabels(service.ServiceTags)
+		}
+		if !rr.Selector.Matches(labels) {
+			continue
 		}
+
+		address := service.ServiceAddress
+		if address == "" {
+			address = service.Address
+		}
+		results = append(results, resolveResult{
+			address: net.JoinHostPort(address, strconv.Itoa(service.ServicePort)),
+			agent:   service.Node,
+		})
+	}
+	return
+}
+
+// initHTTP creates the HTTP client once, when it's first needed
+func (cr *ClientResolver) initHTTP() error {
+	cr.httpOnce.Do(func() {
+		cr.httpc, cr.httpErr = api.NewClient(&api.Config{
+			Address: cr.httpAddr,
+		})
+	})
+	return cr.httpErr
+}
+
+func (cr *ClientResolver) resolveWithAPI(rr quantum.ResolveRequest) (results []resolveResult, err error) {
+	if err := cr.initHTTP(); err != nil {
+		return nil, err
 	}
 
 	catalog := cr.httpc.Catalog()
 	var nodeName string
-	nodes, _, err := catalog.Nodes(nil)
+	nodes, _, err := catalog.Nodes(cr.queryOptions())
 	if err != nil {
 		return nil, err
 	}
@@ -137,7 +391,7 @@ func (cr *ClientResolver) resolveWithAPI(rr quantum.ResolveRequest) (results []r
 	if nodeName == "" {
 		return nil, quantum.NoAgentsFromRequest(rr)
 	}
-	node, _, err := catalog.Node(nodeName, nil)
+	node, _, err := catalog.Node(nodeName, cr.queryOptions())
 	if node == nil || err != nil {
 		return nil, quantum.NoAgentsFromRequest(rr)
 	}
@@ -161,17 +415,24 @@ func (cr *ClientResolver) resolveWithAPI(rr quantum.ResolveRequest) (results []r
 		service.Address = node.Node.Address
 	}
 
-	return []resolveResult{{address: fmt.Sprintf("%s:%d", service.Address, service.Port)}}, nil
+	return []resolveResult{{
+		address: net.JoinHostPort(service.Address, strconv.Itoa(service.Port)),
+		agent:   node.Node.Node,
+	}}, nil
 }
 
-func (cr *ClientResolver) resolveClient(results []resolveResult) (conn quantum.ClientConn, err error) {
-	// TODO: Do this concurrently, first one to respond wins
-	for _, result := range results {
-		client := client.New(cr.config)
-		conn, err = client.Dial(result.address)
-		if err == nil {
-			break
-		}
+// resolveClient dials results concurrently, the first one to connect wins
+func (cr *ClientResolver) resolveClient(results []resolveResult) (quantum.ClientConn, error) {
+	addresses := make([]string, len(results))
+	for i, result := range results {
+		addresses[i] = result.address
 	}
-	return
+
+	conn, err := quantum.DialFirst(client.New(cr.config), addresses, cr.config.GetDialTimeout())
+	if err != nil {
+		cr.lgr.Errorf("Unable to dial agents: %s\n", err)
+		return nil, err
+	}
+
+	return conn, nil
 }
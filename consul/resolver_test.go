@@ -2,9 +2,11 @@ package consul
 
 import (
 	"net"
+	"sync"
 	"testing"
 
 	"github.com/doubledutch/quantum"
+	"github.com/hashicorp/consul/api"
 	"github.com/miekg/dns"
 )
 
@@ -30,7 +32,7 @@ func TestGetConfigs(t *testing.T) {
 	results := newResolveResults(msg, quantum.ResolveRequest{
 		Agent: "one",
 		Type:  "",
-	})
+	}, DefaultDomain)
 
 	if len(results) != 1 {
 		t.Fatal("expected to resolve 1 config")
@@ -63,7 +65,7 @@ func TestBadTarget(t *testing.T) {
 	results := newResolveResults(msg, quantum.ResolveRequest{
 		Agent: "one",
 		Type:  "",
-	})
+	}, DefaultDomain)
 	if len(results) != 0 {
 		t.Fatal("expected no results")
 	}
@@ -90,9 +92,152 @@ func TestHostnameAgentDontMatch(t *testing.T) {
 	results := newResolveResults(msg, quantum.ResolveRequest{
 		Agent: "one",
 		Type:  "",
-	})
+	}, DefaultDomain)
 
 	if len(results) != 0 {
 		t.Fatal("expected no results")
 	}
 }
+
+func TestCatalogResultsSelector(t *testing.T) {
+	services := []*api.CatalogService{
+		{
+			Node:        "one",
+			Address:     "10.0.0.1",
+			ServicePort: 1234,
+			ServiceMeta: map[string]string{"env": "prod"},
+		},
+		{
+			Node:        "two",
+			Address:     "10.0.0.2",
+			ServicePort: 1234,
+			ServiceTags: []string{"quantum", "env=dev"},
+		},
+	}
+
+	results := newCatalogResults(services, quantum.ResolveRequest{
+		Selector: quantum.MustParseSelector("env=dev"),
+	})
+
+	if len(results) != 1 {
+		t.Fatal("expected to resolve 1 result")
+	}
+
+	if results[0].address != "10.0.0.2:1234" || results[0].agent != "two" {
+		t.Fatal("wrong result")
+	}
+}
+
+func TestResolveResultsMatchByName(t *testing.T) {
+	msg := &dns.Msg{}
+	msg.Answer = []dns.RR{
+		&dns.SRV{Target: "one.node.dc1.consul.", Port: 1234},
+		&dns.SRV{Target: "two.node.dc1.consul.", Port: 1234},
+	}
+	// Extras are out of order, and two is IPv6
+	msg.Extra = []dns.RR{
+		&dns.AAAA{Hdr: dns.RR_Header{Name: "two.node.dc1.consul."}, AAAA: net.ParseIP("fd00::2")},
+		&dns.A{Hdr: dns.RR_Header{Name: "one.node.dc1.consul."}, A: net.ParseIP("10.0.0.1")},
+	}
+
+	results := newResolveResults(msg, quantum.ResolveRequest{}, DefaultDomain)
+	if len(results) != 2 {
+		t.Fatal("expected to resolve 2 results")
+	}
+
+	if results[0].address != "10.0.0.1:1234" || results[0].agent != "one" {
+		t.Fatalf("wrong result: %v", results[0])
+	}
+	if results[1].address != "[fd00::2]:1234" || results[1].agent != "two" {
+		t.Fatalf("wrong result: %v", results[1])
+	}
+}
+
+func TestResolveResultsCustomDomain(t *testing.T) {
+	msg := &dns.Msg{}
+	msg.Answer = []dns.RR{
+		&dns.SRV{Target: "one.node.east.example.com.", Port: 1234},
+		&dns.SRV{Target: "0a000002.addr.east.example.com.", Port: 4321},
+	}
+	msg.Extra = []dns.RR{
+		&dns.A{Hdr: dns.RR_Header{Name: "one.node.east.example.com."}, A: net.ParseIP("10.0.0.1")},
+	}
+
+	results := newResolveResults(msg, quantum.ResolveRequest{}, "example.com")
+	if len(results) != 2 {
+		t.Fatal("expected to resolve 2 results")
+	}
+
+	if results[0].agent != "one" {
+		t.Fatal("wrong agent")
+	}
+	if results[1].address != "10.0.0.2:4321" {
+		t.Fatalf("wrong addr target address: %s", results[1].address)
+	}
+}
+
+func TestSRVName(t *testing.T) {
+	cr := NewClientResolverWithConfig(&ResolverConfig{})
+	if name := cr.srvName("build"); name != "build.service.consul." {
+		t.Fatalf("wrong name: %s", name)
+	}
+
+	cr = NewClientResolverWithConfig(&ResolverConfig{
+		Domain:     "example.com.",
+		Datacenter: "east",
+		Tag:        "quantum",
+	})
+	if name := cr.srvName("build"); name != "quantum.build.service.east.example.com." {
+		t.Fatalf("wrong name: %s", name)
+	}
+}
+
+func TestHealthResults(t *testing.T) {
+	entries := []*api.ServiceEntry{
+		{
+			Node:    &api.Node{Node: "one", Address: "10.0.0.1"},
+			Service: &api.AgentService{Port: 1234},
+		},
+		{
+			Node:    &api.Node{Node: "two", Address: "10.0.0.2"},
+			Service: &api.AgentService{Address: "fd00::2", Port: 1234},
+		},
+	}
+
+	results := newHealthResults(entries, quantum.ResolveRequest{Agent: "TWO"})
+	if len(results) != 1 || results[0].address != "[fd00::2]:1234" {
+		t.Fatalf("wrong results: %v", results)
+	}
+}
+
+func TestNewClientResolverCopiesConfig(t *testing.T) {
+	config := &quantum.ConnConfig{}
+	cr := NewClientResolverWithConfig(&ResolverConfig{ConnConfig: config})
+	if config.Config != nil {
+		t.Fatal("expected config to be left unchanged")
+	}
+	if cr.config.Config == nil {
+		t.Fatal("expected the resolver config to be defaulted")
+	}
+}
+
+func TestClientResolverInitHTTP(t *testing.T) {
+	cr := NewClientResolver("127.0.0.1:8500", "127.0.0.1:8600", nil).(*ClientResolver)
+
+	// Resolutions run concurrently, sharing a single client
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := cr.initHTTP(); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if cr.httpc == nil || cr.dnsc == nil {
+		t.Fatal("expected clients")
+	}
+}
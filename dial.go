@@ -0,0 +1,63 @@
+package quantum
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/go-multierror"
+)
+
+var (
+	// ErrNoAddresses occurs when there are no addresses to dial
+	ErrNoAddresses = errors.New("no addresses provided")
+)
+
+type dialResult struct {
+	address string
+	conn    ClientConn
+	err     error
+}
+
+// DialFirst dials addresses concurrently using client, returning the first
+// ClientConn to connect. Connections made after the first are closed.
+// If every dial fails, the returned error lists the error of each address.
+func DialFirst(client Client, addresses []string, timeout time.Duration) (ClientConn, error) {
+	if len(addresses) == 0 {
+		return nil, ErrNoAddresses
+	}
+
+	resultCh := make(chan dialResult, len(addresses))
+	for _, address := range addresses {
+		go func(address string) {
+			conn, err := client.DialTimeout(address, timeout)
+			resultCh <- dialResult{
+				address: address,
+				conn:    conn,
+				err:     err,
+			}
+		}(address)
+	}
+
+	var result error
+	for i := range addresses {
+		r := <-resultCh
+		if r.err != nil {
+			result = multierror.Append(result, fmt.Errorf("%s: %s", r.address, r.err))
+			continue
+		}
+
+		// Close the losers once they've connected
+		go func(remaining int) {
+			for j := 0; j < remaining; j++ {
+				if loser := <-resultCh; loser.err == nil {
+					loser.conn.Close()
+				}
+			}
+		}(len(addresses) - i - 1)
+
+		return r.conn, nil
+	}
+
+	return nil, result
+}
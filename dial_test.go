@@ -0,0 +1,144 @@
+package quantum
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/doubledutch/mux"
+)
+
+type testClientConn struct {
+	mux.Client
+
+	address string
+
+	mu     sync.Mutex
+	closed bool
+}
+
+func (c *testClientConn) Run(request Request) error {
+	return nil
+}
+
+func (c *testClientConn) Logs() <-chan string {
+	return nil
+}
+
+func (c *testClientConn) Signals() chan<- os.Signal {
+	return nil
+}
+
+func (c *testClientConn) Stdin() chan<- []byte {
+	return nil
+}
+
+func (c *testClientConn) WindowSizes() chan<- WindowSize {
+	return nil
+}
+
+func (c *testClientConn) Uploads() chan<- FileChunk {
+	return nil
+}
+
+func (c *testClientConn) Files() <-chan FileChunk {
+	return nil
+}
+
+func (c *testClientConn) Handshake() *Handshake {
+	return nil
+}
+
+func (c *testClientConn) Close() error {
+	c.mu.Lock()
+	c.closed = true
+	c.mu.Unlock()
+	return nil
+}
+
+func (c *testClientConn) isClosed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.closed
+}
+
+// testClient dials addresses after their delay, failing unknown addresses
+type testClient struct {
+	delays map[string]time.Duration
+
+	mu    sync.Mutex
+	conns []*testClientConn
+}
+
+func (c *testClient) Dial(address string) (ClientConn, error) {
+	return c.DialTimeout(address, 0)
+}
+
+func (c *testClient) DialTimeout(address string, timeout time.Duration) (ClientConn, error) {
+	delay, ok := c.delays[address]
+	if !ok {
+		return nil, errors.New("connection refused")
+	}
+	time.Sleep(delay)
+
+	conn := &testClientConn{address: address}
+	c.mu.Lock()
+	c.conns = append(c.conns, conn)
+	c.mu.Unlock()
+	return conn, nil
+}
+
+func TestDialFirst(t *testing.T) {
+	client := &testClient{
+		delays: map[string]time.Duration{
+			"fast": 0,
+			"slow": 50 * time.Millisecond,
+		},
+	}
+
+	conn, err := DialFirst(client, []string{"slow", "dead", "fast"}, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if conn.(*testClientConn).address != "fast" {
+		t.Fatal("expected fastest address to win")
+	}
+
+	// Wait for the loser to connect and be closed
+	time.Sleep(100 * time.Millisecond)
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	for _, c := range client.conns {
+		if c.address == "slow" && !c.isClosed() {
+			t.Fatal("expected loser to be closed")
+		}
+		if c.address == "fast" && c.isClosed() {
+			t.Fatal("expected winner to be open")
+		}
+	}
+}
+
+func TestDialFirstErr(t *testing.T) {
+	client := &testClient{}
+
+	_, err := DialFirst(client, []string{"one", "two"}, time.Second)
+	if err == nil {
+		t.Fatal("expected error")
+	}
+
+	for _, address := range []string{"one: connection refused", "two: connection refused"} {
+		if !strings.Contains(err.Error(), address) {
+			t.Fatalf("expected error to contain %s: %s", address, err)
+		}
+	}
+}
+
+func TestDialFirstNoAddresses(t *testing.T) {
+	if _, err := DialFirst(&testClient{}, nil, time.Second); err != ErrNoAddresses {
+		t.Fatal("expected no addresses error")
+	}
+}
@@ -0,0 +1,299 @@
+package dns
+
+import (
+	"errors"
+	"math/rand"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/doubledutch/lager"
+	"github.com/doubledutch/quantum"
+	"github.com/doubledutch/quantum/client"
+	"github.com/hashicorp/go-multierror"
+	miekg "github.com/miekg/dns"
+)
+
+var (
+	// ErrInvalidServer = a resolver requires a DNS server
+	ErrInvalidServer = errors.New("Invalid DNS Server")
+)
+
+// ResolverConfig configures a ClientResolver
+type ResolverConfig struct {
+	// Server is the host:port of the DNS server
+	Server string
+	// Domain of the SRV records, _type._tcp.Domain.
+	Domain string
+	// Net is the protocol used to query Server, udp or tcp. Defaults to udp.
+	Net string
+
+	ConnConfig *quantum.ConnConfig
+}
+
+// ClientResolver resolves agents from _type._tcp.domain SRV records.
+//
+// Candidates are ordered by SRV priority, and by a weighted random order
+// within a priority, as described by RFC 2782. Agent names are matched to
+// the first label of SRV targets, or the full target. Labels are read from
+// key=value TXT records of targets.
+type ClientResolver struct {
+	config *quantum.ConnConfig
+	lgr    lager.Lager
+
+	server string
+	domain string
+	dnsc   *miekg.Client
+
+	mu  sync.Mutex
+	rnd *rand.Rand
+}
+
+// NewClientResolver creates a ClientResolver using config
+func NewClientResolver(rc *ResolverConfig) (*ClientResolver, error) {
+	if rc.Server == "" {
+		return nil, ErrInvalidServer
+	}
+
+	config := rc.ConnConfig
+	if config == nil {
+		config = quantum.DefaultConnConfig()
+	}
+	if config.Config == nil {
+		// Copied, so the caller's ConnConfig isn't modified
+		c := *config
+		c.Config = quantum.DefaultConfig()
+		config = &c
+	}
+
+	return &ClientResolver{
+		config: config,
+		lgr:    config.Lager,
+		server: rc.Server,
+		domain: strings.Trim(rc.Domain, "."),
+		dnsc:   &miekg.Client{Net: rc.Net},
+		rnd:    rand.New(rand.NewSource(time.Now().UnixNano())),
+	}, nil
+}
+
+// Resolve dials the candidates of request in SRV order, returning the first
+// ClientConn to connect.
+func (r *ClientResolver) Resolve(request quantum.ResolveRequest) (quantum.ClientConn, error) {
+	candidates, err := r.Candidates(request)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(candidates) == 0 {
+		return nil, quantum.NoAgentsFromRequest(request)
+	}
+
+	c := client.New(r.config)
+	var result error
+	for _, candidate := range candidates {
+		conn, err := c.DialTimeout(candidate.Address, r.config.GetDialTimeout())
+		if err == nil {
+			return conn, nil
+		}
+		result = multierror.Append(result, errors.New(candidate.Address+": "+err.Error()))
+	}
+
+	return nil, result
+}
+
+// Candidates returns the agents serving request.Type in SRV order
+func (r *ClientResolver) Candidates(request quantum.ResolveRequest) ([]quantum.Candidate, error) {
+	in, err := r.exchange(r.srvName(request.Type), miekg.TypeSRV)
+	if err != nil {
+		return nil, err
+	}
+
+	var records []*miekg.SRV
+	for _, answer := range in.Answer {
+		if srv, ok := answer.(*miekg.SRV); ok {
+			records = append(records, srv)
+		}
+	}
+
+	var candidates []quantum.Candidate
+	for _, srv := range r.order(records) {
+		agent := agentName(srv.Target)
+		if request.Agent != "" && !matchesAgent(srv.Target, request.Agent) {
+			continue
+		}
+
+		if len(request.Selector) > 0 {
+			labels, err := r.labels(srv.Target)
+			if err != nil {
+				return nil, err
+			}
+			if !request.Selector.Matches(labels) {
+				continue
+			}
+		}
+
+		ip, err := r.lookupIP(srv.Target, in.Extra)
+		if err != nil {
+			r.lgr.Errorf("Unable to resolve %s: %s\n", srv.Target, err)
+			continue
+		}
+
+		candidates = append(candidates, quantum.Candidate{
+			Address: net.JoinHostPort(ip.String(), strconv.Itoa(int(srv.Port))),
+			Agent:   agent,
+		})
+	}
+
+	return candidates, nil
+}
+
+// srvName returns _type._tcp.domain.
+func (r *ClientResolver) srvName(t string) string {
+	name := "_" + t + "._tcp."
+	if r.domain != "" {
+		name += r.domain + "."
+	}
+	return name
+}
+
+func (r *ClientResolver) exchange(name string, qtype uint16) (*miekg.Msg, error) {
+	m := new(miekg.Msg)
+	m.SetQuestion(miekg.Fqdn(name), qtype)
+
+	in, _, err := r.dnsc.Exchange(m, r.server)
+	if err != nil {
+		r.lgr.Errorf("DNS Exchange failed: %s\n", err)
+		return nil, err
+	}
+
+	return in, nil
+}
+
+// lookupIP returns the address of target from extra, or queries A then AAAA
+// records of target.
+func (r *ClientResolver) lookupIP(target string, extra []miekg.RR) (net.IP, error) {
+	if ip := findIP(target, extra); ip != nil {
+		return ip, nil
+	}
+
+	for _, qtype := range []uint16{miekg.TypeA, miekg.TypeAAAA} {
+		in, err := r.exchange(target, qtype)
+		if err != nil {
+			return nil, err
+		}
+		if ip := findIP(target, in.Answer); ip != nil {
+			return ip, nil
+		}
+	}
+
+	return nil, errors.New("no address records")
+}
+
+func findIP(target string, records []miekg.RR) net.IP {
+	for _, rr := range records {
+		if !strings.EqualFold(rr.Header().Name, target) {
+			continue
+		}
+
+		switch a := rr.(type) {
+		case *miekg.A:
+			return a.A
+		case *miekg.AAAA:
+			return a.AAAA
+		}
+	}
+	return nil
+}
+
+// labels returns the key=value TXT records of target as labels
+func (r *ClientResolver) labels(target string) (quantum.Labels, error) {
+	in, err := r.exchange(target, miekg.TypeTXT)
+	if err != nil {
+		return nil, err
+	}
+
+	labels := make(quantum.Labels)
+	for _, answer := range in.Answer {
+		txt, ok := answer.(*miekg.TXT)
+		if !ok {
+			continue
+		}
+		for _, s := range txt.Txt {
+			if kv := strings.SplitN(s, "=", 2); len(kv) == 2 {
+				labels[kv[0]] = kv[1]
+			}
+		}
+	}
+
+	return labels, nil
+}
+
+// order sorts records by priority, and by weighted random order within a
+// priority, per RFC 2782.
+func (r *ClientResolver) order(records []*miekg.SRV) []*miekg.SRV {
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].Priority < records[j].Priority
+	})
+
+	ordered := make([]*miekg.SRV, 0, len(records))
+	for start := 0; start < len(records); {
+		end := start
+		for end < len(records) && records[end].Priority == records[start].Priority {
+			end++
+		}
+		ordered = append(ordered, r.weighted(records[start:end])...)
+		start = end
+	}
+
+	return ordered
+}
+
+// weighted orders records of the same priority by repeatedly selecting a
+// record with probability proportional to its weight.
+func (r *ClientResolver) weighted(records []*miekg.SRV) []*miekg.SRV {
+	remaining := make([]*miekg.SRV, len(records))
+	copy(remaining, records)
+	// Zero weight records are placed first, giving them a small chance
+	sort.SliceStable(remaining, func(i, j int) bool {
+		return remaining[i].Weight == 0 && remaining[j].Weight != 0
+	})
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	ordered := make([]*miekg.SRV, 0, len(records))
+	for len(remaining) > 0 {
+		total := 0
+		for _, srv := range remaining {
+			total += int(srv.Weight)
+		}
+
+		n := r.rnd.Intn(total + 1)
+		i, sum := 0, 0
+		for ; i < len(remaining)-1; i++ {
+			sum += int(remaining[i].Weight)
+			if sum >= n {
+				break
+			}
+		}
+
+		ordered = append(ordered, remaining[i])
+		remaining = append(remaining[:i], remaining[i+1:]...)
+	}
+
+	return ordered
+}
+
+// agentName returns the first label of target
+func agentName(target string) string {
+	return strings.SplitN(strings.TrimSuffix(target, "."), ".", 2)[0]
+}
+
+func matchesAgent(target, agent string) bool {
+	agent = strings.TrimSuffix(agent, ".")
+	return strings.EqualFold(agentName(target), agent) ||
+		strings.EqualFold(strings.TrimSuffix(target, "."), agent)
+}
@@ -0,0 +1,151 @@
+package dns
+
+import (
+	"net"
+	"testing"
+
+	"github.com/doubledutch/quantum"
+	miekg "github.com/miekg/dns"
+)
+
+// startServer serves records from an in-process DNS server
+func startServer(t *testing.T, records []miekg.RR) string {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler := miekg.HandlerFunc(func(w miekg.ResponseWriter, req *miekg.Msg) {
+		m := new(miekg.Msg)
+		m.SetReply(req)
+		q := req.Question[0]
+		for _, rr := range records {
+			if rr.Header().Name == q.Name && rr.Header().Rrtype == q.Qtype {
+				m.Answer = append(m.Answer, rr)
+			}
+		}
+		w.WriteMsg(m)
+	})
+
+	started := make(chan struct{})
+	server := &miekg.Server{
+		PacketConn:        pc,
+		Handler:           handler,
+		NotifyStartedFunc: func() { close(started) },
+	}
+	go server.ActivateAndServe()
+	<-started
+	t.Cleanup(func() { server.Shutdown() })
+
+	return pc.LocalAddr().String()
+}
+
+func rr(t *testing.T, s string) miekg.RR {
+	r, err := miekg.NewRR(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func testRecords(t *testing.T) []miekg.RR {
+	return []miekg.RR{
+		rr(t, "_test._tcp.example.com. 0 IN SRV 10 0 8001 agent1.example.com."),
+		rr(t, "_test._tcp.example.com. 0 IN SRV 20 0 8002 agent2.example.com."),
+		rr(t, "agent1.example.com. 0 IN A 127.0.0.1"),
+		rr(t, "agent2.example.com. 0 IN AAAA ::1"),
+		rr(t, `agent2.example.com. 0 IN TXT "env=prod"`),
+	}
+}
+
+func newTestResolver(t *testing.T, records []miekg.RR) *ClientResolver {
+	r, err := NewClientResolver(&ResolverConfig{
+		Server: startServer(t, records),
+		Domain: "example.com",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func TestCandidates(t *testing.T) {
+	r := newTestResolver(t, testRecords(t))
+
+	candidates, err := r.Candidates(quantum.ResolveRequest{Type: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []quantum.Candidate{
+		{Address: "127.0.0.1:8001", Agent: "agent1"},
+		{Address: "[::1]:8002", Agent: "agent2"},
+	}
+	if len(candidates) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, candidates)
+	}
+	for i := range expected {
+		if candidates[i] != expected[i] {
+			t.Fatalf("expected %v, got %v", expected[i], candidates[i])
+		}
+	}
+}
+
+func TestCandidatesAgent(t *testing.T) {
+	r := newTestResolver(t, testRecords(t))
+
+	for _, agent := range []string{"agent2", "agent2.example.com"} {
+		candidates, err := r.Candidates(quantum.ResolveRequest{Type: "test", Agent: agent})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(candidates) != 1 || candidates[0].Agent != "agent2" {
+			t.Fatalf("expected agent2, got %v", candidates)
+		}
+	}
+}
+
+func TestCandidatesSelector(t *testing.T) {
+	r := newTestResolver(t, testRecords(t))
+
+	candidates, err := r.Candidates(quantum.ResolveRequest{
+		Type:     "test",
+		Selector: quantum.MustParseSelector("env=prod"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(candidates) != 1 || candidates[0].Agent != "agent2" {
+		t.Fatalf("expected agent2, got %v", candidates)
+	}
+}
+
+func TestResolveNoAgents(t *testing.T) {
+	r := newTestResolver(t, testRecords(t))
+
+	_, err := r.Resolve(quantum.ResolveRequest{Type: "missing"})
+	if !quantum.IsNoAgentsErr(err) {
+		t.Fatalf("expected no agents error, got %v", err)
+	}
+}
+
+func TestWeighted(t *testing.T) {
+	r := newTestResolver(t, nil)
+
+	heavy := &miekg.SRV{Target: "heavy.", Weight: 90}
+	light := &miekg.SRV{Target: "light.", Weight: 10}
+	zero := &miekg.SRV{Target: "zero.", Weight: 0}
+
+	counts := make(map[string]int)
+	for i := 0; i < 1000; i++ {
+		ordered := r.order([]*miekg.SRV{light, zero, heavy})
+		if len(ordered) != 3 {
+			t.Fatalf("expected 3 records, got %d", len(ordered))
+		}
+		counts[ordered[0].Target]++
+	}
+
+	if counts["heavy."] < counts["light."] || counts["light."] < counts["zero."] {
+		t.Fatalf("expected selection proportional to weight, got %v", counts)
+	}
+}
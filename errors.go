@@ -0,0 +1,140 @@
+package quantum
+
+import (
+	"errors"
+
+	"github.com/doubledutch/mux"
+)
+
+const (
+	// ErrorType is a mux type for typed errors, sent before the connection
+	// is done so clients receive the code of the error.
+	ErrorType = uint8(68)
+)
+
+// ErrorCode classifies an Error
+type ErrorCode int
+
+const (
+	// CodeUnknown is the code of untyped errors
+	CodeUnknown ErrorCode = iota
+	// CodeNoAgents is the code of resolutions without agents
+	CodeNoAgents
+	// CodeJobNotFound is the code of requests for unregistered types
+	CodeJobNotFound
+	// CodeConfigureFailed is the code of requests a job failed to configure
+	CodeConfigureFailed
+	// CodeJobPanicked is the code of jobs that panicked
+	CodeJobPanicked
+	// CodeSignalled is the code of jobs stopped by a signal
+	CodeSignalled
+	// CodeUnauthorized is the code of unauthorized requests
+	CodeUnauthorized
+	// CodeTimeout is the code of operations that timed out
+	CodeTimeout
+)
+
+var codeNames = map[ErrorCode]string{
+	CodeUnknown:         "unknown",
+	CodeNoAgents:        "no agents",
+	CodeJobNotFound:     "job not found",
+	CodeConfigureFailed: "configure failed",
+	CodeJobPanicked:     "job panicked",
+	CodeSignalled:       "signalled",
+	CodeUnauthorized:    "unauthorized",
+	CodeTimeout:         "timeout",
+}
+
+func (c ErrorCode) String() string {
+	if name, ok := codeNames[c]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+var (
+	// ErrNoAgents matches errors of resolutions without agents
+	ErrNoAgents = NewError(CodeNoAgents, "no agents responded")
+	// ErrJobNotFound = Registry.Get requests job is not found within Registry
+	ErrJobNotFound = NewError(CodeJobNotFound, "Job Not Found")
+	// ErrConfigureFailed matches errors of jobs failing to configure
+	ErrConfigureFailed = NewError(CodeConfigureFailed, "Job Configure Failed")
+	// ErrJobPanicked describes the error when a job panics
+	ErrJobPanicked = NewError(CodeJobPanicked, "Job exited with unexpected error")
+	// ErrUnauthorized matches errors of unauthorized requests
+	ErrUnauthorized = NewError(CodeUnauthorized, "Unauthorized")
+	// ErrTimeout matches errors of operations that timed out
+	ErrTimeout = NewError(CodeTimeout, "Timeout")
+)
+
+// Error is an error with a code. Errors are sent to clients with their code,
+// so clients can match them with errors.Is against the sentinel errors
+// above, or retrieve them with errors.As.
+type Error struct {
+	Code    ErrorCode
+	Message string
+
+	// cause is the original error, only available where it occurred
+	cause error
+}
+
+// NewError creates an Error with code and message
+func NewError(code ErrorCode, message string) *Error {
+	return &Error{
+		Code:    code,
+		Message: message,
+	}
+}
+
+// WrapError creates an Error with code and the message of err
+func WrapError(code ErrorCode, err error) *Error {
+	return &Error{
+		Code:    code,
+		Message: err.Error(),
+		cause:   err,
+	}
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// Unwrap returns the cause of e
+func (e *Error) Unwrap() error {
+	return e.cause
+}
+
+// Is reports whether target is an Error with the same known code
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && e.Code != CodeUnknown && e.Code == t.Code
+}
+
+// ToError returns err as an Error, with CodeUnknown if err isn't typed
+func ToError(err error) *Error {
+	if err == nil {
+		return nil
+	}
+
+	var e *Error
+	if errors.As(err, &e) {
+		return e
+	}
+
+	return WrapError(CodeUnknown, err)
+}
+
+// CodeOf returns the code of err, or CodeUnknown if err isn't typed
+func CodeOf(err error) ErrorCode {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Code
+	}
+	return CodeUnknown
+}
+
+// SendError sends err as an Error over conn ahead of Done
+func SendError(conn mux.Conn, err error) error {
+	e := ToError(err)
+	return conn.Send(ErrorType, Error{Code: e.Code, Message: e.Message})
+}
@@ -0,0 +1,65 @@
+package quantum
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/hashicorp/go-multierror"
+)
+
+func TestErrorIs(t *testing.T) {
+	err := NoAgentsErr("test")
+	if !errors.Is(err, ErrNoAgents) {
+		t.Fatal("expected no agents error")
+	}
+	if errors.Is(err, ErrJobNotFound) {
+		t.Fatal("unexpected job not found error")
+	}
+
+	wrapped := multierror.Append(fmt.Errorf("dns: %w", err), fmt.Errorf("consul: %w", err))
+	if !IsNoAgentsErr(wrapped) {
+		t.Fatal("expected wrapped no agents error")
+	}
+
+	// A failing resolver isn't a miss
+	mixed := multierror.Append(errors.New("consul unavailable"), fmt.Errorf("dns: %w", err))
+	if IsNoAgentsErr(mixed) {
+		t.Fatal("unexpected no agents error")
+	}
+
+	if errors.Is(NewError(CodeUnknown, "a"), NewError(CodeUnknown, "b")) {
+		t.Fatal("unknown errors should not match")
+	}
+}
+
+func TestErrorAs(t *testing.T) {
+	cause := errors.New("bad data")
+	err := fmt.Errorf("configure: %w", WrapError(CodeConfigureFailed, cause))
+
+	var e *Error
+	if !errors.As(err, &e) || e.Code != CodeConfigureFailed {
+		t.Fatalf("expected configure failed error, got %v", err)
+	}
+	if !errors.Is(err, cause) {
+		t.Fatal("expected cause to be unwrapped")
+	}
+	if CodeOf(err) != CodeConfigureFailed {
+		t.Fatalf("expected %s, got %s", CodeConfigureFailed, CodeOf(err))
+	}
+}
+
+func TestToError(t *testing.T) {
+	if ToError(nil) != nil {
+		t.Fatal("expected nil")
+	}
+
+	e := ToError(errors.New("untyped"))
+	if e.Code != CodeUnknown || e.Message != "untyped" {
+		t.Fatalf("expected unknown error, got %v", e)
+	}
+
+	if ToError(ErrSigReceived) != ErrSigReceived {
+		t.Fatal("expected typed error to be returned as is")
+	}
+}
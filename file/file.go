@@ -0,0 +1,111 @@
+package file
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/doubledutch/quantum"
+)
+
+// Entry is a registration of an agent in the file
+type Entry struct {
+	// ID identifies the registration, set by Registrator
+	ID      string         `json:"id,omitempty"`
+	Agent   string         `json:"agent,omitempty"`
+	Address string         `json:"address"`
+	Types   []string       `json:"types"`
+	Labels  quantum.Labels `json:"labels,omitempty"`
+}
+
+// File is the JSON document shared by registrators and resolvers
+type File struct {
+	Entries []Entry `json:"entries"`
+}
+
+// Read reads the File at path. A missing file is an empty File.
+func Read(path string) (*File, error) {
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return &File{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	f := &File{}
+	if len(b) == 0 {
+		return f, nil
+	}
+	if err := json.Unmarshal(b, f); err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// Write atomically replaces the File at path with f
+func Write(path string, f *File) error {
+	b, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	// TempFile creates files only readable by their owner, keep the mode
+	// of the file being replaced so other users can still read it
+	mode := os.FileMode(0644)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+	if err := tmp.Chmod(mode); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	// Readers never observe a partially written file
+	return os.Rename(tmp.Name(), path)
+}
+
+// Update applies fn to the File at path while holding an exclusive lock,
+// writing the result back.
+func Update(path string, fn func(f *File) error) error {
+	unlock, err := lock(path + ".lock")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	f, err := Read(path)
+	if err != nil {
+		return err
+	}
+
+	if err := fn(f); err != nil {
+		return err
+	}
+
+	return Write(path, f)
+}
+
+func (e Entry) hasType(t string) bool {
+	for _, et := range e.Types {
+		if et == t {
+			return true
+		}
+	}
+	return false
+}
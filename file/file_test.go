@@ -0,0 +1,209 @@
+package file
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/doubledutch/lager"
+	"github.com/doubledutch/quantum"
+	"github.com/doubledutch/quantum/inmemory"
+)
+
+type testJob struct {
+	typ string
+}
+
+func (j *testJob) Type() string {
+	return j.typ
+}
+
+func (j *testJob) Configure(p []byte) error {
+	return nil
+}
+
+func (j *testJob) Run(conn quantum.AgentConn) error {
+	return nil
+}
+
+func newTestRegistry(types ...string) quantum.Registry {
+	reg := inmemory.NewRegistry(lager.NewLogLager(nil))
+	for _, t := range types {
+		reg.Add(&testJob{typ: t})
+	}
+	return reg
+}
+
+func tempPath(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "quantum-file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return filepath.Join(dir, "agents.json")
+}
+
+func TestRegisterResolve(t *testing.T) {
+	path := tempPath(t)
+
+	r1 := NewRegistrator(path)
+	r1.Agent, r1.Host = "agent1", "127.0.0.1"
+	r1.SetLabels(quantum.Labels{"env": "prod"})
+	if err := r1.Register(&net.TCPAddr{Port: 8001}, newTestRegistry("build", "deploy")); err != nil {
+		t.Fatal(err)
+	}
+
+	r2 := NewRegistrator(path)
+	r2.Agent, r2.Host = "agent2", "127.0.0.1"
+	if err := r2.Register(&net.TCPAddr{Port: 8002}, newTestRegistry("build")); err != nil {
+		t.Fatal(err)
+	}
+
+	resolver := NewClientResolver(nil, path)
+
+	candidates, err := resolver.Candidates(quantum.ResolveRequest{Type: "build"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(candidates) != 2 {
+		t.Fatalf("expected 2 candidates, got %v", candidates)
+	}
+
+	candidates, err = resolver.Candidates(quantum.ResolveRequest{Type: "build", Agent: "agent2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(candidates) != 1 || candidates[0].Address != "127.0.0.1:8002" {
+		t.Fatalf("expected agent2, got %v", candidates)
+	}
+
+	candidates, err = resolver.Candidates(quantum.ResolveRequest{
+		Type:     "build",
+		Selector: quantum.MustParseSelector("env=prod"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(candidates) != 1 || candidates[0].Agent != "agent1" {
+		t.Fatalf("expected agent1, got %v", candidates)
+	}
+
+	if err := r1.Deregister(); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = resolver.Resolve(quantum.ResolveRequest{Type: "deploy"})
+	if !quantum.IsNoAgentsErr(err) {
+		t.Fatalf("expected no agents error, got %v", err)
+	}
+}
+
+func TestConcurrentRegister(t *testing.T) {
+	path := tempPath(t)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			r := NewRegistrator(path)
+			r.Agent = "agent" + strconv.Itoa(i)
+			if err := r.Register(&net.TCPAddr{Port: 8000 + i}, newTestRegistry("build")); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	f, err := Read(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(f.Entries) != 20 {
+		t.Fatalf("expected 20 entries, got %d", len(f.Entries))
+	}
+}
+
+func TestReload(t *testing.T) {
+	path := tempPath(t)
+	resolver := NewClientResolver(nil, path)
+
+	candidates, err := resolver.Candidates(quantum.ResolveRequest{Type: "build"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(candidates) != 0 {
+		t.Fatalf("expected no candidates, got %v", candidates)
+	}
+
+	err = Write(path, &File{Entries: []Entry{
+		{Agent: "static", Address: "10.0.0.1:8000", Types: []string{"build"}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	candidates, err = resolver.Candidates(quantum.ResolveRequest{Type: "build"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(candidates) != 1 || candidates[0].Agent != "static" {
+		t.Fatalf("expected static, got %v", candidates)
+	}
+}
+
+func TestRegisterAddress(t *testing.T) {
+	path := tempPath(t)
+
+	bound := NewRegistrator(path)
+	bound.Agent = "bound"
+	if err := bound.Register(&net.TCPAddr{IP: net.ParseIP("10.0.0.5"), Port: 8000}, newTestRegistry("build")); err != nil {
+		t.Fatal(err)
+	}
+
+	socket := NewRegistrator(path)
+	socket.Agent = "socket"
+	if err := socket.Register(&net.UnixAddr{Name: "/tmp/agent.sock", Net: "unix"}, newTestRegistry("build")); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := Read(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(f.Entries) != 2 || f.Entries[0].Address != "10.0.0.5:8000" || f.Entries[1].Address != "unix:/tmp/agent.sock" {
+		t.Fatalf("wrong entries: %+v", f.Entries)
+	}
+}
+
+func TestWriteMode(t *testing.T) {
+	path := tempPath(t)
+
+	if err := Write(path, &File{}); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0644 {
+		t.Fatalf("expected 0644, got %s", info.Mode())
+	}
+
+	if err := os.Chmod(path, 0664); err != nil {
+		t.Fatal(err)
+	}
+	if err := Write(path, &File{}); err != nil {
+		t.Fatal(err)
+	}
+	if info, err = os.Stat(path); err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0664 {
+		t.Fatalf("expected mode to be kept, got %s", info.Mode())
+	}
+}
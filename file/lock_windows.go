@@ -0,0 +1,31 @@
+//go:build windows
+// +build windows
+
+package file
+
+import (
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// lock acquires an exclusive LockFileEx lock on path, creating it if needed.
+// The lock is released by the OS if the process exits without unlocking.
+func lock(path string) (func(), error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	h := windows.Handle(f.Fd())
+	ol := new(windows.Overlapped)
+	if err := windows.LockFileEx(h, windows.LOCKFILE_EXCLUSIVE_LOCK, 0, 1, 0, ol); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return func() {
+		windows.UnlockFileEx(h, 0, 1, 0, ol)
+		f.Close()
+	}, nil
+}
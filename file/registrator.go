@@ -0,0 +1,105 @@
+package file
+
+import (
+	"net"
+	"os"
+	"strconv"
+
+	"code.google.com/p/go-uuid/uuid"
+	"github.com/doubledutch/quantum"
+)
+
+// Registrator registers agents in a shared file
+type Registrator struct {
+	// Path of the file
+	Path string
+	// Agent is the name of the agent, defaulting to the hostname
+	Agent string
+	// Host is the host clients dial, defaulting to the IP the agent is
+	// bound to, or the hostname when bound to every interface
+	Host string
+	// Labels of the agent
+	Labels quantum.Labels
+
+	ids []string
+}
+
+// NewRegistrator creates a Registrator for the file at path
+func NewRegistrator(path string) *Registrator {
+	return &Registrator{
+		Path: path,
+	}
+}
+
+// SetLabels sets the labels advertised for the agent
+func (r *Registrator) SetLabels(labels quantum.Labels) {
+	r.Labels = labels
+}
+
+// Register adds an entry with the types of reg to the file
+func (r *Registrator) Register(addr net.Addr, reg quantum.Registry) error {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "localhost"
+	}
+
+	agent := r.Agent
+	if agent == "" {
+		agent = hostname
+	}
+
+	address := quantum.DialAddress(addr, hostname)
+	if r.Host != "" {
+		if port, err := quantum.TCPPort(addr); err == nil {
+			address = net.JoinHostPort(r.Host, strconv.Itoa(port))
+		}
+	}
+
+	entry := Entry{
+		ID:      uuid.New(),
+		Agent:   agent,
+		Address: address,
+		Types:   reg.Types(),
+		Labels:  r.Labels,
+	}
+
+	err = Update(r.Path, func(f *File) error {
+		f.Entries = append(f.Entries, entry)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	r.ids = append(r.ids, entry.ID)
+	return nil
+}
+
+// Deregister removes the entries added by Register from the file
+func (r *Registrator) Deregister() error {
+	if len(r.ids) == 0 {
+		return nil
+	}
+
+	ids := make(map[string]bool, len(r.ids))
+	for _, id := range r.ids {
+		ids[id] = true
+	}
+
+	err := Update(r.Path, func(f *File) error {
+		entries := f.Entries[:0]
+		for _, entry := range f.Entries {
+			if !ids[entry.ID] {
+				entries = append(entries, entry)
+			}
+		}
+		f.Entries = entries
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	r.ids = nil
+	return nil
+}
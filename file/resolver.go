@@ -0,0 +1,124 @@
+package file
+
+import (
+	"os"
+	"sync"
+	"time"
+
+	"github.com/doubledutch/lager"
+	"github.com/doubledutch/quantum"
+	"github.com/doubledutch/quantum/client"
+)
+
+// ClientResolver resolves agents from a shared file. The file is reloaded
+// when its modification time or size changes, so entries added by
+// registrators, or by hand, are picked up without restarting.
+type ClientResolver struct {
+	config *quantum.ConnConfig
+	lgr    lager.Lager
+	path   string
+
+	mu      sync.Mutex
+	file    *File
+	modTime time.Time
+	size    int64
+}
+
+// NewClientResolver creates a ClientResolver for the file at path
+func NewClientResolver(config *quantum.ConnConfig, path string) *ClientResolver {
+	if config == nil {
+		config = quantum.DefaultConnConfig()
+	}
+	if config.Config == nil {
+		// Copied, so the caller's ConnConfig isn't modified
+		c := *config
+		c.Config = quantum.DefaultConfig()
+		config = &c
+	}
+
+	return &ClientResolver{
+		config: config,
+		lgr:    config.Lager,
+		path:   path,
+	}
+}
+
+// Resolve resolves a ClientConn using a ResolveRequest
+func (r *ClientResolver) Resolve(request quantum.ResolveRequest) (quantum.ClientConn, error) {
+	candidates, err := r.Candidates(request)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(candidates) == 0 {
+		return nil, quantum.NoAgentsFromRequest(request)
+	}
+
+	addresses := make([]string, len(candidates))
+	for i, candidate := range candidates {
+		addresses[i] = candidate.Address
+	}
+
+	return quantum.DialFirst(client.New(r.config), addresses, r.config.GetDialTimeout())
+}
+
+// Candidates returns the entries of the file matching request
+func (r *ClientResolver) Candidates(request quantum.ResolveRequest) ([]quantum.Candidate, error) {
+	f, err := r.load()
+	if err != nil {
+		return nil, err
+	}
+
+	var candidates []quantum.Candidate
+	for _, entry := range f.Entries {
+		if !entry.hasType(request.Type) {
+			continue
+		}
+		if request.Agent != "" && entry.Agent != request.Agent {
+			continue
+		}
+		if !request.Selector.Matches(entry.Labels) {
+			continue
+		}
+
+		candidates = append(candidates, quantum.Candidate{
+			Address: entry.Address,
+			Agent:   entry.Agent,
+		})
+	}
+
+	return candidates, nil
+}
+
+// load returns the file, reloading it if it changed since the last load
+func (r *ClientResolver) load() (*File, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	info, err := os.Stat(r.path)
+	if os.IsNotExist(err) {
+		r.file = &File{}
+		r.modTime, r.size = time.Time{}, 0
+		return r.file, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if r.file != nil && info.ModTime().Equal(r.modTime) && info.Size() == r.size {
+		return r.file, nil
+	}
+
+	f, err := Read(r.path)
+	if err != nil {
+		r.lgr.Errorf("Unable to read %s: %s\n", r.path, err)
+		// Keep resolving with the last good file
+		if r.file != nil {
+			return r.file, nil
+		}
+		return nil, err
+	}
+
+	r.lgr.Debugf("Loaded %d entries from %s\n", len(f.Entries), r.path)
+	r.file, r.modTime, r.size = f, info.ModTime(), info.Size()
+	return f, nil
+}
@@ -0,0 +1,332 @@
+// Package gateway implements an HTTP/JSON gateway to quantum agents, for
+// clients that can't speak the mux protocol.
+//
+//	POST   /jobs           submits a Submission, replying with its Status
+//	GET    /jobs           lists the Status of jobs
+//	GET    /jobs/{id}      replies with the Status of a job
+//	GET    /jobs/{id}/logs streams the logs of a job, then its final Status
+//	DELETE /jobs/{id}      cancels a job
+//
+// Logs are streamed as Server-Sent Events when requested with
+// "Accept: text/event-stream", otherwise as chunked newline delimited JSON.
+// Only the latest MaxLogs lines of a job are kept, so clients streaming
+// them late first receive how many lines were truncated.
+package gateway
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"code.google.com/p/go-uuid/uuid"
+	"github.com/doubledutch/quantum"
+	"github.com/doubledutch/quantum/agent"
+	"github.com/doubledutch/quantum/client"
+)
+
+const (
+	// DefaultRetention is how long finished jobs are kept
+	DefaultRetention = time.Hour
+	// DefaultMaxLogs is how many log lines are kept per job
+	DefaultMaxLogs = 10000
+)
+
+var (
+	// ErrNoBackend = a gateway has neither a Resolver nor a Registry
+	ErrNoBackend = errors.New("No Gateway Resolver or Registry")
+	// ErrInvalidSubmission = a submission isn't valid JSON or lacks a type
+	ErrInvalidSubmission = errors.New("Invalid Job Submission")
+	// ErrJobDone = a job can't be cancelled once done
+	ErrJobDone = errors.New("Job Done")
+)
+
+// Config configures a Gateway
+type Config struct {
+	*quantum.ConnConfig
+
+	// Resolver resolves the agents running jobs
+	Resolver quantum.ClientResolver
+	// Registry runs jobs within the gateway, when Resolver is nil
+	Registry quantum.Registry
+	// Retention is how long finished jobs are kept, defaulting to
+	// DefaultRetention
+	Retention time.Duration
+	// MaxLogs is how many of the latest log lines of a job are kept for
+	// clients streaming them, defaulting to DefaultMaxLogs
+	MaxLogs int
+}
+
+// Gateway is an http.Handler running jobs submitted as JSON
+type Gateway struct {
+	*quantum.ConnConfig
+
+	resolver  quantum.ClientResolver
+	registry  quantum.Registry
+	retention time.Duration
+	maxLogs   int
+
+	mu   sync.Mutex
+	jobs map[string]*job
+}
+
+// New creates a new Gateway
+func New(config *Config) *Gateway {
+	if config == nil {
+		config = new(Config)
+	}
+
+	if config.ConnConfig == nil {
+		config.ConnConfig = quantum.DefaultConnConfig()
+	}
+
+	if config.Retention == 0 {
+		config.Retention = DefaultRetention
+	}
+
+	if config.MaxLogs <= 0 {
+		config.MaxLogs = DefaultMaxLogs
+	}
+
+	return &Gateway{
+		ConnConfig: config.ConnConfig,
+		resolver:   config.Resolver,
+		registry:   config.Registry,
+		retention:  config.Retention,
+		maxLogs:    config.MaxLogs,
+		jobs:       make(map[string]*job),
+	}
+}
+
+// ServeHTTP routes requests to jobs
+func (g *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(r.URL.Path, "/")
+	parts := strings.Split(path, "/")
+	if parts[0] != "jobs" || len(parts) > 3 {
+		http.NotFound(w, r)
+		return
+	}
+
+	switch {
+	case len(parts) == 1 && r.Method == http.MethodPost:
+		g.submit(w, r)
+	case len(parts) == 1 && r.Method == http.MethodGet:
+		g.list(w)
+	case len(parts) == 2 && r.Method == http.MethodGet:
+		g.withJob(w, parts[1], func(j *job) {
+			writeJSON(w, http.StatusOK, j.Status())
+		})
+	case len(parts) == 2 && r.Method == http.MethodDelete:
+		g.withJob(w, parts[1], func(j *job) {
+			if !j.cancel() {
+				writeError(w, http.StatusConflict, ErrJobDone)
+				return
+			}
+			writeJSON(w, http.StatusAccepted, j.Status())
+		})
+	case len(parts) == 3 && parts[2] == "logs" && r.Method == http.MethodGet:
+		g.withJob(w, parts[1], func(j *job) {
+			g.streamLogs(w, r, j)
+		})
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (g *Gateway) submit(w http.ResponseWriter, r *http.Request) {
+	var submission Submission
+	if err := json.NewDecoder(r.Body).Decode(&submission); err != nil || submission.Type == "" {
+		writeError(w, http.StatusBadRequest, ErrInvalidSubmission)
+		return
+	}
+
+	resolve, err := submission.resolveRequest()
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	conn, err := g.dial(resolve)
+	if err != nil {
+		g.Lager.Errorf("Error resolving %s: %s", submission.Type, err)
+		writeError(w, errStatus(err), err)
+		return
+	}
+
+	j := newJob(uuid.New(), submission.Type, conn, g.maxLogs)
+	g.mu.Lock()
+	g.jobs[j.status.ID] = j
+	g.mu.Unlock()
+
+	go func() {
+		j.run(submission.request())
+		time.AfterFunc(g.retention, func() {
+			g.mu.Lock()
+			delete(g.jobs, j.status.ID)
+			g.mu.Unlock()
+		})
+	}()
+
+	writeJSON(w, http.StatusAccepted, j.Status())
+}
+
+// dial resolves a conn for request, or connects to the Registry
+func (g *Gateway) dial(request quantum.ResolveRequest) (quantum.ClientConn, error) {
+	if g.resolver != nil {
+		return g.resolver.Resolve(request)
+	}
+	if g.registry == nil {
+		return nil, ErrNoBackend
+	}
+
+	agentConn, clientConn := net.Pipe()
+	go func() {
+		ac, err := agent.Negotiate(agentConn, g.ConnConfig, "gateway")
+		if err != nil {
+			g.Lager.Errorf("Error creating local conn: %s", err)
+			agentConn.Close()
+			return
+		}
+		ac.Serve(g.registry)
+	}()
+
+	conn, err := client.Negotiate(clientConn, g.ConnConfig)
+	if err != nil {
+		clientConn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+func (g *Gateway) list(w http.ResponseWriter) {
+	g.mu.Lock()
+	statuses := make([]Status, 0, len(g.jobs))
+	for _, j := range g.jobs {
+		statuses = append(statuses, j.Status())
+	}
+	g.mu.Unlock()
+
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Started.Before(statuses[j].Started)
+	})
+	writeJSON(w, http.StatusOK, statuses)
+}
+
+// withJob calls fn with the job with id, if found
+func (g *Gateway) withJob(w http.ResponseWriter, id string, fn func(*job)) {
+	g.mu.Lock()
+	j, ok := g.jobs[id]
+	g.mu.Unlock()
+
+	if !ok {
+		writeError(w, http.StatusNotFound, quantum.ErrJobNotFound)
+		return
+	}
+	fn(j)
+}
+
+// streamLogs streams the logs of j until it's done or the client leaves
+func (g *Gateway) streamLogs(w http.ResponseWriter, r *http.Request, j *job) {
+	sse := strings.Contains(r.Header.Get("Accept"), "text/event-stream")
+	if sse {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+	} else {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+	}
+	w.WriteHeader(http.StatusOK)
+
+	flusher, _ := w.(http.Flusher)
+	enc := json.NewEncoder(w)
+
+	var i int
+	for {
+		logs, skipped, changed, done := j.since(i)
+		i += skipped + len(logs)
+
+		if skipped > 0 {
+			if sse {
+				writeEvent(w, "truncated", strconv.Itoa(skipped))
+			} else {
+				enc.Encode(struct {
+					Truncated int `json:"truncated"`
+				}{skipped})
+			}
+		}
+
+		for _, log := range logs {
+			if sse {
+				writeEvent(w, "log", log)
+			} else {
+				enc.Encode(struct {
+					Log string `json:"log"`
+				}{log})
+			}
+		}
+
+		if done {
+			status := j.Status()
+			if sse {
+				b, _ := json.Marshal(status)
+				writeEvent(w, "status", string(b))
+			} else {
+				enc.Encode(struct {
+					Status Status `json:"status"`
+				}{status})
+			}
+		}
+
+		if flusher != nil {
+			flusher.Flush()
+		}
+		if done {
+			return
+		}
+
+		select {
+		case <-changed:
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// writeEvent writes a Server-Sent Event, splitting data by line
+func writeEvent(w http.ResponseWriter, event, data string) {
+	fmt.Fprintf(w, "event: %s\n", event)
+	for _, line := range strings.Split(strings.TrimSuffix(data, "\n"), "\n") {
+		fmt.Fprintf(w, "data: %s\n", line)
+	}
+	fmt.Fprint(w, "\n")
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, struct {
+		Error string `json:"error"`
+		Code  string `json:"code"`
+	}{err.Error(), quantum.CodeOf(err).String()})
+}
+
+// errStatus returns the HTTP status of failing to resolve an agent
+func errStatus(err error) int {
+	switch quantum.CodeOf(err) {
+	case quantum.CodeNoAgents:
+		return http.StatusServiceUnavailable
+	case quantum.CodeTimeout:
+		return http.StatusGatewayTimeout
+	default:
+		return http.StatusBadGateway
+	}
+}
@@ -0,0 +1,322 @@
+package gateway
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/doubledutch/mux"
+	"github.com/doubledutch/quantum"
+	"github.com/doubledutch/quantum/inmemory"
+)
+
+// testJob logs its data, then blocks until signalled if its data is "block"
+type testJob struct {
+	data string
+}
+
+func (j *testJob) Type() string {
+	return "echo"
+}
+
+func (j *testJob) Configure(p []byte) error {
+	j.data = string(p)
+	return nil
+}
+
+func (j *testJob) Run(conn quantum.AgentConn) error {
+	conn.Send(mux.LogType, "got "+j.data)
+	if j.data == "block" {
+		<-conn.Signals()
+		return quantum.ErrSigReceived
+	}
+	return nil
+}
+
+// testRunnerJob runs sleep with a BasicRunner, which fails by exit code
+// once signalled
+type testRunnerJob struct{}
+
+func (j *testRunnerJob) Type() string {
+	return "sleep"
+}
+
+func (j *testRunnerJob) Configure(p []byte) error {
+	return nil
+}
+
+func (j *testRunnerJob) Run(conn quantum.AgentConn) error {
+	outCh := make(chan string, 1)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for log := range outCh {
+			conn.Send(mux.LogType, log)
+		}
+	}()
+
+	err := new(quantum.BasicRunner).Run("sleep 10", outCh, conn.Signals())
+	close(outCh)
+	<-done
+	return err
+}
+
+// testFloodJob logs as many lines as its data
+type testFloodJob struct {
+	n int
+}
+
+func (j *testFloodJob) Type() string {
+	return "flood"
+}
+
+func (j *testFloodJob) Configure(p []byte) (err error) {
+	j.n, err = strconv.Atoi(string(p))
+	return err
+}
+
+func (j *testFloodJob) Run(conn quantum.AgentConn) error {
+	for i := 0; i < j.n; i++ {
+		conn.Send(mux.LogType, strconv.Itoa(i))
+	}
+	return nil
+}
+
+type noAgentsResolver struct{}
+
+func (noAgentsResolver) Resolve(request quantum.ResolveRequest) (quantum.ClientConn, error) {
+	return nil, quantum.NoAgentsFromRequest(request)
+}
+
+func newTestServer() *httptest.Server {
+	config := quantum.DefaultConnConfig()
+	reg := inmemory.NewRegistry(config.Lager)
+	reg.Add(new(testJob))
+	reg.Add(new(testRunnerJob))
+	return httptest.NewServer(New(&Config{ConnConfig: config, Registry: reg}))
+}
+
+func submit(t *testing.T, url, body string) Status {
+	resp, err := http.Post(url+"/jobs", "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("expected accepted, got %s", resp.Status)
+	}
+
+	var status Status
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		t.Fatal(err)
+	}
+	return status
+}
+
+func TestGatewayStream(t *testing.T) {
+	srv := newTestServer()
+	defer srv.Close()
+
+	status := submit(t, srv.URL, `{"type": "echo", "data": "hello"}`)
+	if status.State != Running || status.Agent != "gateway" {
+		t.Fatalf("unexpected status: %+v", status)
+	}
+
+	resp, err := http.Get(srv.URL + "/jobs/" + status.ID + "/logs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var lines []map[string]json.RawMessage
+	dec := json.NewDecoder(resp.Body)
+	for dec.More() {
+		var line map[string]json.RawMessage
+		if err := dec.Decode(&line); err != nil {
+			t.Fatal(err)
+		}
+		lines = append(lines, line)
+	}
+
+	if len(lines) != 2 || string(lines[0]["log"]) != `"got hello"` {
+		t.Fatalf("unexpected logs: %v", lines)
+	}
+	var final Status
+	json.Unmarshal(lines[1]["status"], &final)
+	if final.State != Succeeded || final.Finished == nil {
+		t.Fatalf("expected success, got %+v", final)
+	}
+}
+
+func TestGatewaySSE(t *testing.T) {
+	srv := newTestServer()
+	defer srv.Close()
+
+	status := submit(t, srv.URL, `{"type": "echo", "data": {"a": 1}}`)
+
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"/jobs/"+status.ID+"/logs", nil)
+	req.Header.Set("Accept", "text/event-stream")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var events []string
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		if line := scanner.Text(); line != "" {
+			events = append(events, line)
+		}
+	}
+
+	if len(events) != 4 || events[1] != `data: got {"a": 1}` || events[2] != "event: status" {
+		t.Fatalf("unexpected events: %q", events)
+	}
+}
+
+func TestGatewayTruncatesLogs(t *testing.T) {
+	config := quantum.DefaultConnConfig()
+	reg := inmemory.NewRegistry(config.Lager)
+	reg.Add(new(testFloodJob))
+	srv := httptest.NewServer(New(&Config{ConnConfig: config, Registry: reg, MaxLogs: 3}))
+	defer srv.Close()
+
+	status := submit(t, srv.URL, `{"type": "flood", "data": "10"}`)
+
+	// Connect late, once the job is done
+	deadline := time.Now().Add(5 * time.Second)
+	for status.Finished == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for job")
+		}
+		time.Sleep(10 * time.Millisecond)
+
+		resp, err := http.Get(srv.URL + "/jobs/" + status.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		json.NewDecoder(resp.Body).Decode(&status)
+		resp.Body.Close()
+	}
+
+	resp, err := http.Get(srv.URL + "/jobs/" + status.ID + "/logs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var lines []string
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+
+	expected := []string{`{"truncated":7}`, `{"log":"7"}`, `{"log":"8"}`, `{"log":"9"}`}
+	if len(lines) != 5 || strings.Join(lines[:4], " ") != strings.Join(expected, " ") {
+		t.Fatalf("unexpected logs: %q", lines)
+	}
+}
+
+// cancel submits body, then cancels the job once it logs, returning its
+// final status
+func cancel(t *testing.T, url, body string) Status {
+	status := submit(t, url, body)
+
+	// Wait for the job to run before cancelling it
+	resp, err := http.Get(url + "/jobs/" + status.ID + "/logs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	dec := json.NewDecoder(resp.Body)
+	var line map[string]json.RawMessage
+	if err := dec.Decode(&line); err != nil {
+		t.Fatal(err)
+	}
+
+	req, _ := http.NewRequest(http.MethodDelete, url+"/jobs/"+status.ID, nil)
+	cancelResp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cancelResp.Body.Close()
+	if cancelResp.StatusCode != http.StatusAccepted {
+		t.Fatalf("expected accepted, got %s", cancelResp.Status)
+	}
+
+	var final struct {
+		Status Status `json:"status"`
+	}
+	for final.Status.Finished == nil {
+		if err := dec.Decode(&final); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cancelResp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cancelResp.Body.Close()
+	if cancelResp.StatusCode != http.StatusConflict {
+		t.Fatalf("expected conflict, got %s", cancelResp.Status)
+	}
+	return final.Status
+}
+
+func TestGatewayCancel(t *testing.T) {
+	srv := newTestServer()
+	defer srv.Close()
+
+	status := cancel(t, srv.URL, `{"type": "echo", "data": "block"}`)
+	if status.State != Cancelled || status.Code != quantum.CodeSignalled.String() {
+		t.Fatalf("expected cancelled, got %+v", status)
+	}
+}
+
+func TestGatewayCancelRunner(t *testing.T) {
+	srv := newTestServer()
+	defer srv.Close()
+
+	status := cancel(t, srv.URL, `{"type": "sleep"}`)
+	if status.State != Cancelled || status.Error == "" {
+		t.Fatalf("expected cancelled, got %+v", status)
+	}
+}
+
+func TestGatewayErrors(t *testing.T) {
+	srv := httptest.NewServer(New(&Config{Resolver: noAgentsResolver{}}))
+	defer srv.Close()
+
+	tests := []struct {
+		method, path, body string
+		status             int
+	}{
+		{http.MethodPost, "/jobs", `{"type": "echo"}`, http.StatusServiceUnavailable},
+		{http.MethodPost, "/jobs", `{}`, http.StatusBadRequest},
+		{http.MethodPost, "/jobs", `{"type": "echo", "selector": "env in ("}`, http.StatusBadRequest},
+		{http.MethodGet, "/jobs/missing", "", http.StatusNotFound},
+		{http.MethodPut, "/jobs", "", http.StatusMethodNotAllowed},
+		{http.MethodGet, "/other", "", http.StatusNotFound},
+	}
+
+	for _, test := range tests {
+		req, _ := http.NewRequest(test.method, srv.URL+test.path, strings.NewReader(test.body))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != test.status {
+			t.Fatalf("%s %s: expected %d, got %s", test.method, test.path, test.status, resp.Status)
+		}
+	}
+}
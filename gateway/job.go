@@ -0,0 +1,208 @@
+package gateway
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/doubledutch/mux"
+	"github.com/doubledutch/quantum"
+)
+
+// States of jobs
+const (
+	Running   = "running"
+	Succeeded = "succeeded"
+	Failed    = "failed"
+	Cancelled = "cancelled"
+)
+
+// Submission is the JSON body submitting a job. Data is sent to the job
+// as is, or as its contents when it's a JSON string.
+type Submission struct {
+	Type     string          `json:"type"`
+	Data     json.RawMessage `json:"data,omitempty"`
+	Agent    string          `json:"agent,omitempty"`
+	Key      string          `json:"key,omitempty"`
+	Selector string          `json:"selector,omitempty"`
+}
+
+// request returns the quantum.Request of s
+func (s Submission) request() quantum.Request {
+	var data string
+	if err := json.Unmarshal(s.Data, &data); err != nil {
+		data = string(s.Data)
+	}
+	return quantum.NewRequest(s.Type, data)
+}
+
+// resolveRequest returns the quantum.ResolveRequest of s
+func (s Submission) resolveRequest() (quantum.ResolveRequest, error) {
+	selector, err := quantum.ParseSelector(s.Selector)
+	if err != nil {
+		return quantum.ResolveRequest{}, err
+	}
+
+	return quantum.ResolveRequest{
+		Agent:    s.Agent,
+		Type:     s.Type,
+		Key:      s.Key,
+		Selector: selector,
+	}, nil
+}
+
+// Status is the JSON status of a job
+type Status struct {
+	ID       string     `json:"id"`
+	Type     string     `json:"type"`
+	Agent    string     `json:"agent,omitempty"`
+	State    string     `json:"state"`
+	Error    string     `json:"error,omitempty"`
+	Code     string     `json:"code,omitempty"`
+	Started  time.Time  `json:"started"`
+	Finished *time.Time `json:"finished,omitempty"`
+}
+
+// job is a job submitted to the gateway, retaining its latest logs for
+// clients streaming them
+type job struct {
+	conn    quantum.ClientConn
+	maxLogs int
+
+	mu        sync.Mutex
+	status    Status
+	logs      []string // ring of the latest maxLogs logs
+	total     int      // logs received, including those overwritten
+	changed   chan struct{}
+	cancelled bool
+	done      chan struct{}
+}
+
+func newJob(id, t string, conn quantum.ClientConn, maxLogs int) *job {
+	status := Status{
+		ID:      id,
+		Type:    t,
+		State:   Running,
+		Started: time.Now(),
+	}
+	if handshake := conn.Handshake(); handshake != nil {
+		status.Agent = handshake.Agent
+	}
+
+	return &job{
+		conn:    conn,
+		maxLogs: maxLogs,
+		status:  status,
+		changed: make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+}
+
+// run runs request on the conn of the job, recording its logs and result
+func (j *job) run(request quantum.Request) {
+	logsDone := make(chan struct{})
+	go func() {
+		defer close(logsDone)
+		for log := range j.conn.Logs() {
+			j.mu.Lock()
+			j.addLog(log)
+			j.notify()
+			j.mu.Unlock()
+		}
+	}()
+
+	err := j.conn.Run(request)
+	<-logsDone
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	finished := time.Now()
+	j.status.Finished = &finished
+	switch {
+	case err == nil:
+		j.status.State = Succeeded
+	case j.cancelled:
+		// Jobs report signals their own way, e.g. BasicRunner by exit code
+		j.status.State = Cancelled
+	default:
+		j.status.State = Failed
+	}
+	if err != nil {
+		j.status.Error = err.Error()
+		j.status.Code = quantum.CodeOf(err).String()
+	}
+
+	close(j.done)
+	j.notify()
+}
+
+// addLog adds log to the ring, overwriting the oldest once full, with j.mu
+// held
+func (j *job) addLog(log string) {
+	if len(j.logs) < j.maxLogs {
+		j.logs = append(j.logs, log)
+	} else {
+		j.logs[j.total%j.maxLogs] = log
+	}
+	j.total++
+}
+
+// notify wakes clients waiting for changes, with j.mu held
+func (j *job) notify() {
+	close(j.changed)
+	j.changed = make(chan struct{})
+}
+
+// Status returns the status of the job
+func (j *job) Status() Status {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.status
+}
+
+// since returns the logs of the job from index i, how many logs from i
+// were truncated, a chan closed once the job changes, and whether the job
+// is done
+func (j *job) since(i int) ([]string, int, <-chan struct{}, bool) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	var skipped int
+	if first := j.total - len(j.logs); i < first {
+		skipped = first - i
+		i = first
+	}
+
+	// Copied, since the ring is overwritten as logs arrive
+	var logs []string
+	for ; i < j.total; i++ {
+		logs = append(logs, j.logs[i%j.maxLogs])
+	}
+
+	select {
+	case <-j.done:
+		return logs, skipped, j.changed, true
+	default:
+		return logs, skipped, j.changed, false
+	}
+}
+
+// cancel signals the job to stop, returning false if it's done
+func (j *job) cancel() bool {
+	j.mu.Lock()
+	select {
+	case <-j.done:
+		j.mu.Unlock()
+		return false
+	default:
+	}
+	j.cancelled = true
+	j.mu.Unlock()
+
+	// Sent directly, since Run closes Signals() as the job completes.
+	// Failing to send means the connection, and so the job, is ending.
+	j.conn.Send(mux.SignalType, os.Interrupt)
+	return true
+}
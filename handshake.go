@@ -0,0 +1,378 @@
+package quantum
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/doubledutch/mux"
+)
+
+const (
+	// ProtocolVersion is the version of the protocol spoken by this package
+	ProtocolVersion = 1
+	// MinProtocolVersion is the oldest version of the protocol supported
+	MinProtocolVersion = 1
+
+	// DefaultCodec is the name of the codec of ConnConfig.Pool, unless
+	// it's a NamedPool
+	DefaultCodec = "gob"
+
+	// maxHelloSize limits the size of hellos read from peers
+	maxHelloSize = 64 * 1024
+)
+
+// Capabilities are optional protocol features, negotiated by the handshake
+const (
+	// CapabilityResults sends typed errors and results of jobs
+	CapabilityResults = "results"
+	// CapabilityStdin streams stdin and window sizes to jobs
+	CapabilityStdin = "stdin"
+	// CapabilityFiles transfers files to and from jobs
+	CapabilityFiles = "files"
+	// CapabilitySessions runs many jobs over one connection
+	CapabilitySessions = "sessions"
+	// CapabilityStructuredLogs sends logs with fields, reserved for peers
+	// implementing it
+	CapabilityStructuredLogs = "structured-logs"
+)
+
+var (
+	// ErrInvalidHello = a peer sent something other than a hello
+	ErrInvalidHello = errors.New("Invalid Handshake Hello")
+	// ErrIncompatibleVersion = peers share no protocol version
+	ErrIncompatibleVersion = errors.New("Incompatible Protocol Version")
+	// ErrNoCommonCodec = peers share no codec
+	ErrNoCommonCodec = errors.New("No Common Codec")
+	// ErrHandshakeRejected = the agent rejected the client's hello
+	ErrHandshakeRejected = errors.New("Handshake Rejected")
+	// ErrCapabilityUnsupported = the peer doesn't support a required capability
+	ErrCapabilityUnsupported = errors.New("Capability Unsupported")
+)
+
+// DefaultCapabilities are the capabilities supported by this package
+var DefaultCapabilities = Capabilities{
+	CapabilityResults,
+	CapabilityStdin,
+	CapabilityFiles,
+	CapabilitySessions,
+}
+
+// Codec is a mux.Pool that peers may negotiate by Name
+type Codec struct {
+	Name string
+	Pool mux.Pool
+}
+
+// NamedPool is a mux.Pool naming its codec for handshakes
+type NamedPool interface {
+	mux.Pool
+	Name() string
+}
+
+// Capabilities is a set of capabilities
+type Capabilities []string
+
+// Has returns whether c contains capability
+func (c Capabilities) Has(capability string) bool {
+	return contains(c, capability)
+}
+
+// intersect returns the capabilities of c also in other
+func (c Capabilities) intersect(other Capabilities) Capabilities {
+	var result Capabilities
+	for _, cc := range c {
+		if other.Has(cc) {
+			result = append(result, cc)
+		}
+	}
+	return result
+}
+
+// Hello is sent by each peer when a connection opens. Agents set Error
+// when rejecting a client.
+type Hello struct {
+	Version      int
+	MinVersion   int
+	Agent        string
+	Codecs       []string
+	Capabilities Capabilities
+	Error        string
+}
+
+// Handshake is the outcome of a handshake, exposed by negotiated conns
+type Handshake struct {
+	// Version is the protocol version spoken, 0 for clients predating
+	// handshakes
+	Version int
+	// Agent is the name of the agent
+	Agent string
+	// Codec is the name of the codec encoding messages
+	Codec string
+	// Capabilities are the capabilities supported by both peers
+	Capabilities Capabilities
+}
+
+// Has returns whether both peers support capability
+func (h *Handshake) Has(capability string) bool {
+	return h != nil && h.Capabilities.Has(capability)
+}
+
+// Allows returns ErrCapabilityUnsupported unless both peers support the
+// capability needed to send messages of mux type t. Conns made without
+// negotiating, with a nil handshake, send every message.
+func (h *Handshake) Allows(t uint8) error {
+	if h == nil {
+		return nil
+	}
+
+	var capability string
+	switch t {
+	case ErrorType:
+		capability = CapabilityResults
+	case StdinType, WindowSizeType:
+		capability = CapabilityStdin
+	case FileChunkType:
+		capability = CapabilityFiles
+	case EnvelopeType:
+		capability = CapabilitySessions
+	default:
+		return nil
+	}
+
+	if !h.Has(capability) {
+		return fmt.Errorf("%w: %s", ErrCapabilityUnsupported, capability)
+	}
+	return nil
+}
+
+// Negotiate negotiates the handshake of a client sending client and an
+// agent sending agent. The client's codecs are in order of preference.
+func Negotiate(client, agent Hello) (*Handshake, error) {
+	if client.Version == 0 {
+		return nil, ErrInvalidHello
+	}
+
+	version := client.Version
+	if agent.Version < version {
+		version = agent.Version
+	}
+	if version < client.MinVersion || version < agent.MinVersion {
+		return nil, fmt.Errorf("%w: client %d-%d, agent %d-%d", ErrIncompatibleVersion,
+			client.MinVersion, client.Version, agent.MinVersion, agent.Version)
+	}
+
+	var codec string
+	for _, c := range client.Codecs {
+		if contains(agent.Codecs, c) {
+			codec = c
+			break
+		}
+	}
+	if codec == "" {
+		return nil, fmt.Errorf("%w: client %v, agent %v", ErrNoCommonCodec, client.Codecs, agent.Codecs)
+	}
+
+	return &Handshake{
+		Version:      version,
+		Agent:        agent.Agent,
+		Codec:        codec,
+		Capabilities: client.Capabilities.intersect(agent.Capabilities),
+	}, nil
+}
+
+// ClientHandshake sends the hello of config on conn and reads the agent's
+// hello, returning the negotiated handshake
+func ClientHandshake(conn net.Conn, config *ConnConfig) (*Handshake, error) {
+	hello := config.hello("")
+
+	var agent Hello
+	if err := handshakeIO(conn, config, func() error {
+		if err := writeHello(conn, hello); err != nil {
+			return err
+		}
+		return readHello(conn, &agent)
+	}); err != nil {
+		return nil, err
+	}
+
+	// Negotiating again gives the reason of most rejections as an error
+	handshake, err := Negotiate(hello, agent)
+	if err != nil {
+		return nil, err
+	}
+	if agent.Error != "" {
+		return nil, fmt.Errorf("%w: %s", ErrHandshakeRejected, agent.Error)
+	}
+	return handshake, nil
+}
+
+// AgentHandshake reads the hello of a client on conn and replies with the
+// hello of config for the agent name, returning the negotiated handshake.
+// Incompatible clients are rejected with the reason.
+func AgentHandshake(conn net.Conn, config *ConnConfig, name string) (*Handshake, error) {
+	hello := config.hello(name)
+
+	var client Hello
+	err := handshakeIO(conn, config, func() error {
+		return readHello(conn, &client)
+	})
+
+	var handshake *Handshake
+	if err == nil {
+		handshake, err = Negotiate(client, hello)
+	}
+	if err != nil {
+		// Let the client know why, it may still understand hellos
+		hello.Error = err.Error()
+	}
+
+	if writeErr := handshakeIO(conn, config, func() error {
+		return writeHello(conn, hello)
+	}); err == nil {
+		err = writeErr
+	}
+
+	if err != nil {
+		return nil, err
+	}
+	return handshake, nil
+}
+
+// DetectHello reads ahead on conn to tell clients sending hellos from
+// clients predating handshakes, which send gob encoded requests straight
+// away. Hellos start with the high byte of their length, always zero,
+// which gob streams never start with. The returned conn replays the
+// bytes read ahead.
+func DetectHello(conn net.Conn, config *ConnConfig) (net.Conn, bool, error) {
+	var b [1]byte
+	if err := handshakeIO(conn, config, func() error {
+		_, err := io.ReadFull(conn, b[:])
+		return err
+	}); err != nil {
+		return nil, false, err
+	}
+
+	replayed := &replayConn{
+		Conn: conn,
+		r:    io.MultiReader(bytes.NewReader(b[:]), conn),
+	}
+	return replayed, b[0] == 0, nil
+}
+
+// replayConn is a net.Conn reading from r
+type replayConn struct {
+	net.Conn
+	r io.Reader
+}
+
+func (c *replayConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+// Negotiated returns a copy of c using the codec negotiated by handshake
+func (c *ConnConfig) Negotiated(handshake *Handshake) *ConnConfig {
+	config := *c
+	if c.Config != nil {
+		cc := *c.Config
+		cc.Pool = c.codec(handshake.Codec)
+		config.Config = &cc
+	}
+	return &config
+}
+
+// codecs returns the codecs of c, defaulting to Pool, named DefaultCodec
+// unless it's a NamedPool
+func (c *ConnConfig) codecs() []Codec {
+	if len(c.Codecs) > 0 {
+		return c.Codecs
+	}
+
+	name := DefaultCodec
+	if named, ok := c.Pool.(NamedPool); ok {
+		name = named.Name()
+	}
+	return []Codec{{Name: name, Pool: c.Pool}}
+}
+
+// codec returns the pool of the codec called name
+func (c *ConnConfig) codec(name string) mux.Pool {
+	for _, codec := range c.codecs() {
+		if codec.Name == name {
+			return codec.Pool
+		}
+	}
+	return c.Pool
+}
+
+// hello returns the hello of c, for the agent name
+func (c *ConnConfig) hello(name string) Hello {
+	capabilities := c.Capabilities
+	if capabilities == nil {
+		capabilities = DefaultCapabilities
+	}
+
+	var codecs []string
+	for _, codec := range c.codecs() {
+		codecs = append(codecs, codec.Name)
+	}
+
+	return Hello{
+		Version:      ProtocolVersion,
+		MinVersion:   MinProtocolVersion,
+		Agent:        name,
+		Codecs:       codecs,
+		Capabilities: capabilities,
+	}
+}
+
+// handshakeIO runs fn with the dial timeout of config as deadline for conn
+func handshakeIO(conn net.Conn, config *ConnConfig, fn func() error) error {
+	if timeout := config.GetDialTimeout(); timeout > 0 {
+		conn.SetDeadline(time.Now().Add(timeout))
+		defer conn.SetDeadline(time.Time{})
+	}
+	return fn()
+}
+
+// Hellos are JSON, so peers with other codecs or versions can read them,
+// prefixed by their length so nothing past the hello is read.
+func writeHello(w io.Writer, hello Hello) error {
+	b, err := json.Marshal(hello)
+	if err != nil {
+		return err
+	}
+
+	buf := make([]byte, 4+len(b))
+	binary.BigEndian.PutUint32(buf, uint32(len(b)))
+	copy(buf[4:], b)
+	_, err = w.Write(buf)
+	return err
+}
+
+func readHello(r io.Reader, hello *Hello) error {
+	var size [4]byte
+	if _, err := io.ReadFull(r, size[:]); err != nil {
+		return err
+	}
+
+	n := binary.BigEndian.Uint32(size[:])
+	if n > maxHelloSize {
+		return ErrInvalidHello
+	}
+
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return err
+	}
+
+	if err := json.Unmarshal(b, hello); err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidHello, err)
+	}
+	return nil
+}
@@ -0,0 +1,188 @@
+package quantum
+
+import (
+	"encoding/gob"
+	"errors"
+	"net"
+	"testing"
+)
+
+func TestNegotiate(t *testing.T) {
+	client := Hello{
+		Version:      2,
+		MinVersion:   1,
+		Codecs:       []string{"json", "gob"},
+		Capabilities: Capabilities{CapabilityStdin, CapabilityFiles},
+	}
+	agent := Hello{
+		Version:      1,
+		MinVersion:   1,
+		Agent:        "agent",
+		Codecs:       []string{"gob", "json"},
+		Capabilities: Capabilities{CapabilityFiles, CapabilitySessions},
+	}
+
+	handshake, err := Negotiate(client, agent)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if handshake.Version != 1 || handshake.Agent != "agent" || handshake.Codec != "json" {
+		t.Fatalf("unexpected handshake: %+v", handshake)
+	}
+	if !handshake.Has(CapabilityFiles) || handshake.Has(CapabilityStdin) || handshake.Has(CapabilitySessions) {
+		t.Fatalf("expected only files, got %v", handshake.Capabilities)
+	}
+}
+
+func TestNegotiateIncompatible(t *testing.T) {
+	client := Hello{Version: 1, MinVersion: 1, Codecs: []string{"gob"}}
+
+	tests := []struct {
+		agent Hello
+		err   error
+	}{
+		{Hello{Version: 3, MinVersion: 2, Codecs: []string{"gob"}}, ErrIncompatibleVersion},
+		{Hello{Version: 1, MinVersion: 1, Codecs: []string{"json"}}, ErrNoCommonCodec},
+	}
+
+	for _, test := range tests {
+		if _, err := Negotiate(client, test.agent); !errors.Is(err, test.err) {
+			t.Fatalf("expected %v, got %v", test.err, err)
+		}
+	}
+
+	if _, err := Negotiate(Hello{}, client); !errors.Is(err, ErrInvalidHello) {
+		t.Fatalf("expected invalid hello, got %v", err)
+	}
+}
+
+func TestHandshake(t *testing.T) {
+	agentConn, clientConn := net.Pipe()
+	defer agentConn.Close()
+	defer clientConn.Close()
+
+	type result struct {
+		handshake *Handshake
+		err       error
+	}
+	agentCh := make(chan result, 1)
+	go func() {
+		handshake, err := AgentHandshake(agentConn, DefaultConnConfig(), "agent")
+		agentCh <- result{handshake, err}
+	}()
+
+	handshake, err := ClientHandshake(clientConn, DefaultConnConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := <-agentCh
+	if r.err != nil {
+		t.Fatal(r.err)
+	}
+
+	if handshake.Agent != "agent" || handshake.Codec != DefaultCodec || !handshake.Has(CapabilitySessions) {
+		t.Fatalf("unexpected handshake: %+v", handshake)
+	}
+	if r.handshake.Codec != handshake.Codec || len(r.handshake.Capabilities) != len(handshake.Capabilities) {
+		t.Fatalf("peers negotiated differently: %+v, %+v", r.handshake, handshake)
+	}
+}
+
+func TestHandshakeRejected(t *testing.T) {
+	agentConn, clientConn := net.Pipe()
+	defer agentConn.Close()
+	defer clientConn.Close()
+
+	agentErrCh := make(chan error, 1)
+	go func() {
+		_, err := AgentHandshake(agentConn, DefaultConnConfig(), "agent")
+		agentErrCh <- err
+	}()
+
+	config := DefaultConnConfig()
+	config.Codecs = []Codec{{Name: "json"}}
+	if _, err := ClientHandshake(clientConn, config); !errors.Is(err, ErrNoCommonCodec) {
+		t.Fatalf("expected no common codec, got %v", err)
+	}
+	if err := <-agentErrCh; !errors.Is(err, ErrNoCommonCodec) {
+		t.Fatalf("expected agent to reject client, got %v", err)
+	}
+}
+
+func TestHandshakeInvalidHello(t *testing.T) {
+	agentConn, clientConn := net.Pipe()
+	defer agentConn.Close()
+	defer clientConn.Close()
+
+	go clientConn.Write([]byte{0, 0, 0, 4, 'g', 'o', 'b', '!'})
+	go readHello(clientConn, new(Hello))
+
+	if _, err := AgentHandshake(agentConn, DefaultConnConfig(), "agent"); !errors.Is(err, ErrInvalidHello) {
+		t.Fatalf("expected invalid hello, got %v", err)
+	}
+}
+
+func TestHandshakeAllows(t *testing.T) {
+	var negotiated *Handshake
+	if err := negotiated.Allows(ErrorType); err != nil {
+		t.Fatalf("expected conns without handshakes to send errors, got %v", err)
+	}
+
+	legacy := &Handshake{}
+	for _, typ := range []uint8{ErrorType, StdinType, WindowSizeType, FileChunkType, EnvelopeType} {
+		if err := legacy.Allows(typ); !errors.Is(err, ErrCapabilityUnsupported) {
+			t.Fatalf("expected type %d to be unsupported, got %v", typ, err)
+		}
+	}
+	if err := legacy.Allows(RequestType); err != nil {
+		t.Fatalf("expected requests to be sent, got %v", err)
+	}
+
+	handshake := &Handshake{Capabilities: Capabilities{CapabilityStdin}}
+	if err := handshake.Allows(StdinType); err != nil {
+		t.Fatal(err)
+	}
+	if err := handshake.Allows(FileChunkType); !errors.Is(err, ErrCapabilityUnsupported) {
+		t.Fatalf("expected files to be unsupported, got %v", err)
+	}
+}
+
+func TestDetectHello(t *testing.T) {
+	tests := []struct {
+		write func(net.Conn)
+		hello bool
+	}{
+		{func(c net.Conn) { writeHello(c, Hello{Version: 1}) }, true},
+		{func(c net.Conn) { gob.NewEncoder(c).Encode(Request{Type: "legacy"}) }, false},
+	}
+
+	for _, test := range tests {
+		agentConn, clientConn := net.Pipe()
+		go func() {
+			test.write(clientConn)
+			clientConn.Close()
+		}()
+
+		conn, hello, err := DetectHello(agentConn, DefaultConnConfig())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hello != test.hello {
+			t.Fatalf("expected hello %t, got %t", test.hello, hello)
+		}
+
+		// Nothing read ahead is lost
+		if hello {
+			var h Hello
+			if err := readHello(conn, &h); err != nil || h.Version != 1 {
+				t.Fatalf("expected replayed hello, got %+v, %v", h, err)
+			}
+		} else {
+			var request Request
+			if err := gob.NewDecoder(conn).Decode(&request); err != nil || request.Type != "legacy" {
+				t.Fatalf("expected replayed request, got %+v, %v", request, err)
+			}
+		}
+	}
+}
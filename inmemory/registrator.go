@@ -1,7 +1,7 @@
 package inmemory
 
 import (
-	"strconv"
+	"net"
 
 	"github.com/doubledutch/quantum"
 )
@@ -10,6 +10,8 @@ import (
 type Registrator struct {
 	// Type -> Address
 	Jobs map[string]string
+	// Labels of the agent
+	Labels quantum.Labels
 }
 
 // NewRegistrator creates a new Registrator
@@ -20,14 +22,20 @@ func NewRegistrator() *Registrator {
 }
 
 // Register will register a Registry locally
-func (r *Registrator) Register(port int, reg quantum.Registry) error {
+func (r *Registrator) Register(addr net.Addr, reg quantum.Registry) error {
+	address := quantum.DialAddress(addr, "0.0.0.0")
 	for _, t := range reg.Types() {
-		r.Jobs[t] = "0.0.0.0:" + strconv.Itoa(port)
+		r.Jobs[t] = address
 	}
 
 	return nil
 }
 
+// SetLabels sets the labels advertised for the agent
+func (r *Registrator) SetLabels(labels quantum.Labels) {
+	r.Labels = labels
+}
+
 // Deregister will register the jobs locally
 func (r *Registrator) Deregister() error {
 	r.Jobs = nil
@@ -1,15 +1,13 @@
 package inmemory
 
 import (
-	"errors"
-
 	"github.com/doubledutch/lager"
 	"github.com/doubledutch/quantum"
 )
 
 var (
 	// ErrJobNotFound = Register.Get requests job is not found within Registery
-	ErrJobNotFound = errors.New("Job Not Found")
+	ErrJobNotFound = quantum.ErrJobNotFound
 )
 
 // NewRegistry creates a Router, initializing the job map.
@@ -43,10 +41,14 @@ func (r *Registry) Get(request quantum.Request) (quantum.Job, error) {
 		return nil, ErrJobNotFound
 	}
 
+	if factory, ok := job.(quantum.JobFactory); ok {
+		job = factory.NewJob()
+	}
+
 	err := job.Configure(request.Data)
 	if err != nil {
 		r.lgr.Errorf("job configure error: type: %s, data: %s", request.Type, request.Data)
-		return nil, err
+		return nil, quantum.WrapError(quantum.CodeConfigureFailed, err)
 	}
 
 	return job, nil
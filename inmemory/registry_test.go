@@ -81,3 +81,47 @@ func TestNewInMemoryRegistryGetErr(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+type testFactoryJob struct {
+	data string
+}
+
+func (j *testFactoryJob) Type() string {
+	return registryJob
+}
+
+func (j *testFactoryJob) NewJob() quantum.Job {
+	return &testFactoryJob{}
+}
+
+func (j *testFactoryJob) Configure(p []byte) error {
+	j.data = string(p)
+	return nil
+}
+
+func (j *testFactoryJob) Run(conn quantum.AgentConn) error {
+	return nil
+}
+
+func TestRegistryGetFactory(t *testing.T) {
+	r := NewRegistry(lager.NewLogLager(nil))
+
+	job := &testFactoryJob{}
+	r.Add(job)
+
+	first, err := r.Get(quantum.NewRequest(registryJob, "first"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := r.Get(quantum.NewRequest(registryJob, "second"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if first == second || first.(*testFactoryJob).data != "first" || second.(*testFactoryJob).data != "second" {
+		t.Fatal("expected a new job for each request")
+	}
+	if job.data != "" {
+		t.Fatal("registered job should not be configured")
+	}
+}
@@ -41,5 +41,19 @@ func (r *ClientResolver) Resolve(request quantum.ResolveRequest) (quantum.Client
 		return nil, quantum.NoAgentsFromRequest(request)
 	}
 
+	if !request.Selector.Matches(r.registrator.Labels) {
+		return nil, quantum.NoAgentsFromRequest(request)
+	}
+
 	return r.client.Dial(addr)
 }
+
+// Candidates returns the address registered for the type of request
+func (r *ClientResolver) Candidates(request quantum.ResolveRequest) ([]quantum.Candidate, error) {
+	addr, ok := r.registrator.Jobs[request.Type]
+	if !ok || !request.Selector.Matches(r.registrator.Labels) {
+		return nil, nil
+	}
+
+	return []quantum.Candidate{{Address: addr}}, nil
+}
@@ -1,15 +1,23 @@
 package integration
 
 import (
+	"bytes"
+	"errors"
+	"io/ioutil"
 	"log"
 	"net"
+	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
 	"sync"
 	"testing"
 
+	"github.com/doubledutch/mux"
 	"github.com/doubledutch/quantum"
 	"github.com/doubledutch/quantum/agent"
 	"github.com/doubledutch/quantum/client"
+	"github.com/doubledutch/quantum/inmemory"
 )
 
 const (
@@ -110,3 +118,305 @@ func TestClientAgent(t *testing.T) {
 	}
 	wg.Wait()
 }
+
+func TestTypedErrors(t *testing.T) {
+	agentNetConn, clientNetConn := net.Pipe()
+
+	config := quantum.DefaultConnConfig()
+	ac, err := agent.NewConn(agentNetConn, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go ac.Serve(inmemory.NewRegistry(config.Lager))
+
+	cc, err := client.NewConn(clientNetConn, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = cc.Run(quantum.NewRequest("missing", ""))
+	if !errors.Is(err, quantum.ErrJobNotFound) {
+		t.Fatalf("expected job not found, got %v", err)
+	}
+
+	var e *quantum.Error
+	if !errors.As(err, &e) || e.Code != quantum.CodeJobNotFound {
+		t.Fatalf("expected %s, got %v", quantum.CodeJobNotFound, err)
+	}
+}
+
+func TestLegacyClient(t *testing.T) {
+	a := agent.New(nil)
+	a.Add(new(testAgentJob))
+
+	l, agentAddr := listenTCP()
+	defer l.Close()
+	go func() {
+		for a.Accept(l) == nil {
+		}
+	}()
+
+	// Clients predating handshakes send requests straight away
+	dial := func() quantum.ClientConn {
+		netConn, err := net.Dial("tcp", agentAddr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cc, err := client.NewConn(netConn, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cc
+	}
+
+	cc := dial()
+	var logs []string
+	done := make(chan struct{})
+	go func() {
+		for log := range cc.Logs() {
+			logs = append(logs, log)
+		}
+		close(done)
+	}()
+	if err := cc.Run(quantum.NewRequest(serverJob, "{}")); err != nil {
+		t.Fatal(err)
+	}
+	<-done
+	if len(logs) != 3 {
+		t.Fatalf("expected 3 logs, got %v", logs)
+	}
+
+	// Errors arrive untyped, from Done
+	err := dial().Run(quantum.NewRequest("missing", ""))
+	var e *quantum.Error
+	if err == nil || err.Error() != quantum.ErrJobNotFound.Error() || errors.As(err, &e) {
+		t.Fatalf("expected untyped job not found, got %v", err)
+	}
+}
+
+const interactiveJob = "interactiveJob"
+
+// testInteractiveJob greets the line read from a terminal
+type testInteractiveJob struct{}
+
+func (j *testInteractiveJob) Type() string {
+	return interactiveJob
+}
+
+func (j *testInteractiveJob) Configure(p []byte) error {
+	return nil
+}
+
+func (j *testInteractiveJob) Run(conn quantum.AgentConn) error {
+	outCh := make(chan string, 1)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for log := range outCh {
+			conn.Send(mux.LogType, log)
+		}
+	}()
+
+	err := quantum.NewPTYRunner(conn).Run("read name && echo hello $name", outCh, conn.Signals())
+	close(outCh)
+	<-done
+	return err
+}
+
+func TestInteractive(t *testing.T) {
+	agentNetConn, clientNetConn := net.Pipe()
+
+	config := quantum.DefaultConnConfig()
+	ac, err := agent.NewConn(agentNetConn, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reg := inmemory.NewRegistry(config.Lager)
+	reg.Add(&testInteractiveJob{})
+	go ac.Serve(reg)
+
+	cc, err := client.NewConn(clientNetConn, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stdin, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stdin.Close()
+	w.WriteString("quantum\n")
+
+	var out bytes.Buffer
+	if err := client.RunTerminal(cc, quantum.NewRequest(interactiveJob, ""), stdin, &out); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+
+	if !strings.Contains(out.String(), "hello quantum") {
+		t.Fatalf("expected hello quantum, got %q", out.String())
+	}
+}
+
+const stdinJob = "stdinJob"
+
+// testStdinJob greets the line read from stdin, as a step of a BasicJob
+type testStdinJob struct {
+	*quantum.BasicJob
+}
+
+func (j *testStdinJob) Type() string {
+	return stdinJob
+}
+
+func (j *testStdinJob) Configure(p []byte) error {
+	j.BasicJob = quantum.NewBasicJob(j)
+	return nil
+}
+
+func (j *testStdinJob) Steps() []quantum.Step {
+	return []quantum.Step{new(GreetStep)}
+}
+
+type GreetStep struct{}
+
+func (s GreetStep) Run(state quantum.StateBag) error {
+	conn := state.Get("conn").(quantum.AgentConn)
+	runner := state.Get("runner").(quantum.Runner)
+	return runner.Run("read name && echo hello $name", conn.Logs(), conn.Signals())
+}
+
+func (s GreetStep) Cleanup(state quantum.StateBag) {}
+
+func TestBasicJobStdin(t *testing.T) {
+	agentNetConn, clientNetConn := net.Pipe()
+
+	config := quantum.DefaultConnConfig()
+	ac, err := agent.NewConn(agentNetConn, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reg := inmemory.NewRegistry(config.Lager)
+	reg.Add(new(testStdinJob))
+	go ac.Serve(reg)
+
+	cc, err := client.NewConn(clientNetConn, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var logs []string
+	done := make(chan struct{})
+	go func() {
+		for log := range cc.Logs() {
+			logs = append(logs, log)
+		}
+		close(done)
+	}()
+
+	cc.Stdin() <- []byte("quantum\n")
+	if err := cc.Run(quantum.NewRequest(stdinJob, "")); err != nil {
+		t.Fatal(err)
+	}
+	<-done
+
+	if !strings.Contains(strings.Join(logs, ""), "hello quantum") {
+		t.Fatalf("expected hello quantum, got %q", logs)
+	}
+}
+
+const transferJob = "transferJob"
+
+// testTransferJob receives a file and sends it back
+type testTransferJob struct {
+	dir string
+}
+
+func (j *testTransferJob) Type() string {
+	return transferJob
+}
+
+func (j *testTransferJob) Configure(p []byte) error {
+	return nil
+}
+
+func (j *testTransferJob) Run(conn quantum.AgentConn) error {
+	files := quantum.NewFileTransfer(conn)
+	path, err := files.Receive(j.dir)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return files.Send("echo.bin", f, 0)
+}
+
+func TestTransfer(t *testing.T) {
+	agentDir, err := ioutil.TempDir("", "quantum-agent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(agentDir)
+	clientDir, err := ioutil.TempDir("", "quantum-client")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(clientDir)
+
+	agentNetConn, clientNetConn := net.Pipe()
+
+	config := quantum.DefaultConnConfig()
+	ac, err := agent.NewConn(agentNetConn, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reg := inmemory.NewRegistry(config.Lager)
+	reg.Add(&testTransferJob{dir: agentDir})
+	go ac.Serve(reg)
+
+	cc, err := client.NewConn(clientNetConn, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// An earlier upload stopped part way
+	data := bytes.Repeat([]byte("quantum"), 50000)
+	if err := ioutil.WriteFile(filepath.Join(agentDir, "upload.bin"), data[:len(data)/2], 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	type received struct {
+		path string
+		err  error
+	}
+	receivedCh := make(chan received, 1)
+	go func() {
+		if err := quantum.ResumeFile(quantum.ChanSender(cc.Uploads()), cc.Files(), "upload.bin", bytes.NewReader(data)); err != nil {
+			receivedCh <- received{"", err}
+			return
+		}
+		path, err := quantum.ReceiveFile(cc.Files(), clientDir, nil)
+		receivedCh <- received{path, err}
+	}()
+
+	if err := cc.Run(quantum.NewRequest(transferJob, "")); err != nil {
+		t.Fatal(err)
+	}
+
+	r := <-receivedCh
+	if r.err != nil {
+		t.Fatal(r.err)
+	}
+	b, err := ioutil.ReadFile(r.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, data) {
+		t.Fatal("downloaded file doesn't match upload")
+	}
+}
@@ -0,0 +1,156 @@
+package integration
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+
+	"github.com/doubledutch/mux/gob"
+	"github.com/doubledutch/quantum"
+	"github.com/doubledutch/quantum/agent"
+	"github.com/doubledutch/quantum/client"
+	"github.com/doubledutch/quantum/codec/json"
+	"github.com/doubledutch/quantum/codec/protobuf"
+	"github.com/doubledutch/quantum/inmemory"
+)
+
+// testCodecNegotiation runs a job over a codec negotiated with the agent
+func testCodecNegotiation(t *testing.T, pool quantum.NamedPool) {
+	agentConfig := quantum.DefaultConnConfig()
+	agentConfig.Codecs = []quantum.Codec{
+		{Name: quantum.DefaultCodec, Pool: new(gob.Pool)},
+		{Name: json.Name, Pool: new(json.Pool)},
+		{Name: protobuf.Name, Pool: new(protobuf.Pool)},
+	}
+
+	agentNetConn, clientNetConn := net.Pipe()
+
+	reg := inmemory.NewRegistry(agentConfig.Lager)
+	reg.Add(new(testAgentJob))
+	go func() {
+		ac, err := agent.Negotiate(agentNetConn, agentConfig, "agent")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		ac.Serve(reg)
+	}()
+
+	clientConfig := quantum.DefaultConnConfig()
+	clientConfig.Pool = pool
+	cc, err := client.Negotiate(clientNetConn, clientConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cc.Handshake().Codec != pool.Name() {
+		t.Fatalf("expected %s, got %s", pool.Name(), cc.Handshake().Codec)
+	}
+
+	var logs []string
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		for log := range cc.Logs() {
+			logs = append(logs, log)
+		}
+		wg.Done()
+	}()
+
+	if err := cc.Run(quantum.NewRequest(serverJob, "{}")); err != nil {
+		t.Fatal(err)
+	}
+	wg.Wait()
+
+	if strings.Join(logs, ",") != "0,1,2" {
+		t.Fatalf("unexpected logs %v", logs)
+	}
+}
+
+// dialCodec connects a client and an agent serving reg, both using pool
+// as their Config.Pool
+func dialCodec(t *testing.T, pool quantum.NamedPool, reg quantum.Registry) quantum.ClientConn {
+	agentNetConn, clientNetConn := net.Pipe()
+
+	config := quantum.DefaultConnConfig()
+	config.Pool = pool
+	ac, err := agent.NewConn(agentNetConn, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go ac.Serve(reg)
+
+	cc, err := client.NewConn(clientNetConn, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cc
+}
+
+// testCodecSignals signals a blocking job over pool
+func testCodecSignals(t *testing.T, pool quantum.NamedPool) {
+	reg := inmemory.NewRegistry(quantum.DefaultConnConfig().Lager)
+	reg.Add(&testStreamJob{typ: "blocking", block: true})
+
+	cc := dialCodec(t, pool, reg)
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- cc.Run(quantum.NewRequest("blocking", ""))
+	}()
+	if log := <-cc.Logs(); log != "from blocking" {
+		t.Fatalf("expected blocking log, got %q", log)
+	}
+
+	cc.Signals() <- syscall.SIGINT
+	if err := <-errCh; !errors.Is(err, quantum.ErrSigReceived) {
+		t.Fatalf("expected signalled, got %v", err)
+	}
+}
+
+func TestCodecJSON(t *testing.T) {
+	testCodecNegotiation(t, new(json.Pool))
+}
+
+func TestCodecJSONSignals(t *testing.T) {
+	testCodecSignals(t, new(json.Pool))
+}
+
+func TestCodecProtobuf(t *testing.T) {
+	testCodecNegotiation(t, new(protobuf.Pool))
+}
+
+func TestCodecProtobufSignals(t *testing.T) {
+	testCodecSignals(t, new(protobuf.Pool))
+}
+
+func TestCodecProtobufPool(t *testing.T) {
+	reg := inmemory.NewRegistry(quantum.DefaultConnConfig().Lager)
+	reg.Add(new(testAgentJob))
+
+	// Requests reach the agent through the request receiver of the pool
+	cc := dialCodec(t, new(protobuf.Pool), reg)
+	var logs []string
+	done := make(chan struct{})
+	go func() {
+		for log := range cc.Logs() {
+			logs = append(logs, log)
+		}
+		close(done)
+	}()
+	if err := cc.Run(quantum.NewRequest(serverJob, "{}")); err != nil {
+		t.Fatal(err)
+	}
+	<-done
+	if strings.Join(logs, ",") != "0,1,2" {
+		t.Fatalf("unexpected logs %v", logs)
+	}
+
+	// Typed errors decode as Error messages
+	err := dialCodec(t, new(protobuf.Pool), reg).Run(quantum.NewRequest("missing", ""))
+	var e *quantum.Error
+	if !errors.As(err, &e) || e.Code != quantum.CodeJobNotFound {
+		t.Fatalf("expected %s, got %v", quantum.CodeJobNotFound, err)
+	}
+}
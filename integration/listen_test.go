@@ -0,0 +1,57 @@
+package integration
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/doubledutch/quantum"
+	"github.com/doubledutch/quantum/agent"
+	"github.com/doubledutch/quantum/inmemory"
+)
+
+func TestUnixSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "quantum-unix")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	a := agent.New(nil)
+	a.Add(new(testAgentJob))
+
+	ln, err := quantum.Listen("unix:" + filepath.Join(dir, "agent.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go a.Accept(ln)
+
+	registrator := inmemory.NewRegistrator()
+	if err := registrator.Register(ln.Addr(), a); err != nil {
+		t.Fatal(err)
+	}
+
+	resolver, err := inmemory.NewClientResolver(nil, registrator)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := resolver.Resolve(quantum.ResolveRequest{Type: serverJob})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for range conn.Logs() {
+		}
+	}()
+
+	if err := conn.Run(quantum.NewRequest(serverJob, "{}")); err != nil {
+		t.Fatal(err)
+	}
+	<-done
+}
@@ -0,0 +1,261 @@
+package integration
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/doubledutch/mux"
+	"github.com/doubledutch/quantum"
+	"github.com/doubledutch/quantum/agent"
+	"github.com/doubledutch/quantum/client"
+)
+
+// testStreamJob logs its type count times, then blocks until signalled
+// if block is set
+type testStreamJob struct {
+	typ   string
+	count int
+	block bool
+}
+
+func (j *testStreamJob) Type() string {
+	return j.typ
+}
+
+func (j *testStreamJob) Configure(p []byte) error {
+	return nil
+}
+
+func (j *testStreamJob) Run(conn quantum.AgentConn) error {
+	for i := 0; i < j.count || i == 0; i++ {
+		if err := conn.Send(mux.LogType, "from "+j.typ); err != nil {
+			return err
+		}
+	}
+
+	if j.block {
+		<-conn.Signals()
+		return quantum.ErrSigReceived
+	}
+	return nil
+}
+
+func TestSession(t *testing.T) {
+	a := agent.New(&agent.Config{Name: "session-agent"})
+	for i := 0; i < 3; i++ {
+		a.Add(&testStreamJob{typ: fmt.Sprintf("stream-%d", i)})
+	}
+	a.Add(&testStreamJob{typ: "blocking", block: true})
+
+	// Accept a single connection, every job must share it
+	l, agentAddr := listenTCP()
+	defer l.Close()
+	go a.Accept(l)
+
+	pool := client.NewSessionPool(nil)
+	defer pool.Close()
+
+	blocking, err := pool.Dial(agentAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if handshake := blocking.Handshake(); handshake.Agent != "session-agent" || !handshake.Has(quantum.CapabilitySessions) {
+		t.Fatalf("unexpected handshake: %+v", handshake)
+	}
+
+	blockErrCh := make(chan error, 1)
+	go func() {
+		blockErrCh <- blocking.Run(quantum.NewRequest("blocking", ""))
+	}()
+	if log := <-blocking.Logs(); log != "from blocking" {
+		t.Fatalf("expected blocking log, got %q", log)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		conn, err := pool.Dial(agentAddr)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		typ := fmt.Sprintf("stream-%d", i)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var logs []string
+			done := make(chan struct{})
+			go func() {
+				for log := range conn.Logs() {
+					logs = append(logs, log)
+				}
+				close(done)
+			}()
+
+			if err := conn.Run(quantum.NewRequest(typ, "")); err != nil {
+				t.Error(err)
+			}
+			<-done
+			if len(logs) != 1 || logs[0] != "from "+typ {
+				t.Errorf("expected logs of %s, got %v", typ, logs)
+			}
+		}()
+	}
+	wg.Wait()
+
+	missing, err := pool.Dial(agentAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := missing.Run(quantum.NewRequest("missing", "")); !errors.Is(err, quantum.ErrJobNotFound) {
+		t.Fatalf("expected job not found, got %v", err)
+	}
+
+	blocking.Signals() <- syscall.SIGINT
+	if err := <-blockErrCh; !errors.Is(err, quantum.ErrSigReceived) {
+		t.Fatalf("expected signalled, got %v", err)
+	}
+}
+
+func TestSessionSlowStream(t *testing.T) {
+	a := agent.New(&agent.Config{Name: "session-agent"})
+	a.Add(&testStreamJob{typ: "chatty", count: 10})
+	a.Add(&testStreamJob{typ: "quiet"})
+
+	l, agentAddr := listenTCP()
+	defer l.Close()
+	go a.Accept(l)
+
+	pool := client.NewSessionPool(nil)
+	defer pool.Close()
+
+	// The logs of chatty are never read
+	chatty, err := pool.Dial(agentAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chattyErrCh := make(chan error, 1)
+	go func() {
+		chattyErrCh <- chatty.Run(quantum.NewRequest("chatty", ""))
+	}()
+
+	quiet, err := pool.Dial(agentAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for range quiet.Logs() {
+		}
+	}()
+	if err := quiet.Run(quantum.NewRequest("quiet", "")); err != nil {
+		t.Fatal(err)
+	}
+
+	var logs int
+	for range chatty.Logs() {
+		logs++
+	}
+	if logs != 10 {
+		t.Fatalf("expected 10 logs, got %d", logs)
+	}
+	if err := <-chattyErrCh; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSessionSlowReader(t *testing.T) {
+	count := 2 * quantum.DefaultStreamBacklog
+
+	a := agent.New(&agent.Config{Name: "session-agent"})
+	a.Add(&testStreamJob{typ: "chatty", count: count})
+
+	l, agentAddr := listenTCP()
+	defer l.Close()
+	go a.Accept(l)
+
+	pool := client.NewSessionPool(nil)
+	defer pool.Close()
+
+	conn, err := pool.Dial(agentAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The stream backlogs before its logs are read, no log may be lost
+	logCh := make(chan int)
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		var logs int
+		for range conn.Logs() {
+			logs++
+		}
+		logCh <- logs
+	}()
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- conn.Run(quantum.NewRequest("chatty", ""))
+	}()
+
+	select {
+	case err := <-errCh:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("expected run to return")
+	}
+	if logs := <-logCh; logs != count {
+		t.Fatalf("expected %d logs, got %d", count, logs)
+	}
+}
+
+func TestSessionConcurrency(t *testing.T) {
+	a := agent.New(&agent.Config{Name: "session-agent", Concurrency: 1})
+	a.Add(&testStreamJob{typ: "blocking", block: true})
+	a.Add(&testStreamJob{typ: "quiet"})
+
+	l, agentAddr := listenTCP()
+	defer l.Close()
+	go a.Accept(l)
+
+	pool := client.NewSessionPool(nil)
+	defer pool.Close()
+
+	blocking, err := pool.Dial(agentAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blockErrCh := make(chan error, 1)
+	go func() {
+		blockErrCh <- blocking.Run(quantum.NewRequest("blocking", ""))
+	}()
+	<-blocking.Logs()
+
+	// The blocking job holds the only slot of the agent
+	rejected, err := pool.Dial(agentAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rejected.Run(quantum.NewRequest("quiet", "")); err == nil || err.Error() != agent.ErrAtCapacity.Error() {
+		t.Fatalf("expected at capacity, got %v", err)
+	}
+
+	blocking.Signals() <- syscall.SIGINT
+	<-blockErrCh
+
+	accepted, err := pool.Dial(agentAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for range accepted.Logs() {
+		}
+	}()
+	if err := accepted.Run(quantum.NewRequest("quiet", "")); err != nil {
+		t.Fatal(err)
+	}
+}
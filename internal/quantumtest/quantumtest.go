@@ -0,0 +1,200 @@
+// Package quantumtest provides fake connections for testing jobs, and the
+// workflows running them.
+package quantumtest
+
+import (
+	"os"
+	"sync"
+
+	"github.com/doubledutch/lager"
+	"github.com/doubledutch/mux"
+	"github.com/doubledutch/quantum"
+)
+
+// AgentConn is a quantum.AgentConn recording the logs jobs send
+type AgentConn struct {
+	mux.Server
+
+	LogCh    chan string
+	SigCh    chan os.Signal
+	StdinCh  chan []byte
+	ResizeCh chan quantum.WindowSize
+	FileCh   chan quantum.FileChunk
+	// Dir is the workspace of the job
+	Dir string
+
+	mu       sync.Mutex
+	logs     []string
+	shutdown chan struct{}
+}
+
+// NewAgentConn returns a new AgentConn
+func NewAgentConn() *AgentConn {
+	return &AgentConn{
+		LogCh:    make(chan string, 100),
+		SigCh:    make(chan os.Signal, 1),
+		StdinCh:  make(chan []byte, 1),
+		ResizeCh: make(chan quantum.WindowSize, 1),
+		FileCh:   make(chan quantum.FileChunk, 1),
+		shutdown: make(chan struct{}),
+	}
+}
+
+// Send records logs sent by the job, dropping other messages
+func (c *AgentConn) Send(t uint8, v interface{}) error {
+	if log, ok := v.(string); ok && t == mux.LogType {
+		c.mu.Lock()
+		c.logs = append(c.logs, log)
+		c.mu.Unlock()
+	}
+	return nil
+}
+
+// Sent returns the logs sent by the job
+func (c *AgentConn) Sent() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]string(nil), c.logs...)
+}
+
+// Logs returns the logs channel of the conn
+func (c *AgentConn) Logs() chan string {
+	return c.LogCh
+}
+
+// Signals returns the signals channel of the conn
+func (c *AgentConn) Signals() chan os.Signal {
+	return c.SigCh
+}
+
+// Stdin returns the stdin channel of the conn
+func (c *AgentConn) Stdin() chan []byte {
+	return c.StdinCh
+}
+
+// WindowSizes returns the window size channel of the conn
+func (c *AgentConn) WindowSizes() chan quantum.WindowSize {
+	return c.ResizeCh
+}
+
+// Files returns the files channel of the conn
+func (c *AgentConn) Files() chan quantum.FileChunk {
+	return c.FileCh
+}
+
+// Workspace returns Dir
+func (c *AgentConn) Workspace() string {
+	return c.Dir
+}
+
+// Handshake returns nil, the conn isn't negotiated
+func (c *AgentConn) Handshake() *quantum.Handshake {
+	return nil
+}
+
+// Lager returns a Lager logging to the standard logger
+func (c *AgentConn) Lager() lager.Lager {
+	return lager.NewLogLager(nil)
+}
+
+// IsShutdown returns a chan that is never closed
+func (c *AgentConn) IsShutdown() chan struct{} {
+	return c.shutdown
+}
+
+// ClientConn is a quantum.ClientConn running requests with RunFunc
+type ClientConn struct {
+	mux.Client
+
+	// Address is the address the conn was dialed to
+	Address string
+	// RunFunc runs requests, which succeed when it's nil
+	RunFunc func(quantum.Request) error
+
+	LogCh chan string
+	SigCh chan os.Signal
+
+	mu       sync.Mutex
+	closed   bool
+	shutdown chan struct{}
+	runOnce  sync.Once
+}
+
+// NewClientConn returns a new ClientConn dialed to address, running
+// requests with run
+func NewClientConn(address string, run func(quantum.Request) error) *ClientConn {
+	return &ClientConn{
+		Address:  address,
+		RunFunc:  run,
+		LogCh:    make(chan string, 100),
+		SigCh:    make(chan os.Signal, 1),
+		shutdown: make(chan struct{}),
+	}
+}
+
+// Run runs request with RunFunc, then shuts the conn down
+func (c *ClientConn) Run(request quantum.Request) error {
+	var err error
+	if c.RunFunc != nil {
+		err = c.RunFunc(request)
+	}
+	c.runOnce.Do(func() {
+		close(c.shutdown)
+	})
+	return err
+}
+
+// Logs provides the logs of the conn
+func (c *ClientConn) Logs() <-chan string {
+	return c.LogCh
+}
+
+// Signals provides the signals of the conn
+func (c *ClientConn) Signals() chan<- os.Signal {
+	return c.SigCh
+}
+
+// Stdin returns nil, stdin isn't supported
+func (c *ClientConn) Stdin() chan<- []byte {
+	return nil
+}
+
+// WindowSizes returns nil, window sizes aren't supported
+func (c *ClientConn) WindowSizes() chan<- quantum.WindowSize {
+	return nil
+}
+
+// Uploads returns nil, uploads aren't supported
+func (c *ClientConn) Uploads() chan<- quantum.FileChunk {
+	return nil
+}
+
+// Files returns nil, files aren't supported
+func (c *ClientConn) Files() <-chan quantum.FileChunk {
+	return nil
+}
+
+// Handshake returns nil, the conn isn't negotiated
+func (c *ClientConn) Handshake() *quantum.Handshake {
+	return nil
+}
+
+// IsShutdown returns a chan closed once a request ran
+func (c *ClientConn) IsShutdown() chan struct{} {
+	return c.shutdown
+}
+
+// Close marks the conn closed
+func (c *ClientConn) Close() error {
+	c.mu.Lock()
+	c.closed = true
+	c.mu.Unlock()
+	return nil
+}
+
+// Closed returns whether the conn was closed
+func (c *ClientConn) Closed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.closed
+}
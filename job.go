@@ -2,7 +2,6 @@ package quantum
 
 import (
 	"encoding/json"
-	"errors"
 	"sync"
 
 	"github.com/doubledutch/mux"
@@ -15,7 +14,7 @@ const (
 
 var (
 	// ErrSigReceived is used when a signal is received while running a job
-	ErrSigReceived = errors.New("Signal Received")
+	ErrSigReceived = NewError(CodeSignalled, "Signal Received")
 )
 
 // Request contains Type and Data. Type is the ID, Data is the request data
@@ -55,6 +54,14 @@ type Job interface {
 	Run(AgentConn) error
 }
 
+// JobFactory is a Job holding per-request state, such as the data it's
+// configured with. Registries configure and run a Job from NewJob for each
+// request, so concurrent requests of its type don't share that state.
+type JobFactory interface {
+	Job
+	NewJob() Job
+}
+
 // StepsJob is a superset of Job, providing Steps so this job can by ran
 // by BasicRun. This could be a StepJob with a BasicImplementation.
 type StepsJob interface {
@@ -69,7 +76,8 @@ func NewBasicJob(job StepsJob) *BasicJob {
 	}
 }
 
-// BasicJob executes a StepsJob on Run
+// BasicJob executes a StepsJob on Run. Steps run commands with the
+// "runner" of the state, a BasicRunner reading the stdin of the client.
 type BasicJob struct {
 	job StepsJob
 }
@@ -93,7 +101,9 @@ func (basic *BasicJob) Run(conn AgentConn) error {
 	state := NewStateBag()
 	state.Put("conn", conn)
 	state.Put("ui", NewUI(conn))
-	state.Put("runner", NewBasicRunner())
+	state.Put("runner", &BasicRunner{Stdin: conn.Stdin(), Dir: conn.Workspace()})
+	state.Put("files", NewFileTransfer(conn))
+	state.Put("workspace", conn.Workspace())
 
 	runner := &BasicExecutor{Steps: basic.job.Steps()}
 	err := runner.Run(state)
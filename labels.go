@@ -0,0 +1,220 @@
+package quantum
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Labels are key/value pairs advertised by an agent
+type Labels map[string]string
+
+// String returns labels as a sorted list of key=value pairs
+func (l Labels) String() string {
+	pairs := make([]string, 0, len(l))
+	for k, v := range l {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// LabeledRegistrator is a Registrator that advertises the labels of an agent
+type LabeledRegistrator interface {
+	Registrator
+	SetLabels(labels Labels)
+}
+
+// Operator is the operator of a Requirement
+type Operator string
+
+const (
+	// Equals requires the label to have the value
+	Equals = Operator("=")
+	// NotEquals requires the label to be missing or to have another value
+	NotEquals = Operator("!=")
+	// In requires the label to have one of the values
+	In = Operator("in")
+	// NotIn requires the label to be missing or to have none of the values
+	NotIn = Operator("notin")
+	// Exists requires the label to be set
+	Exists = Operator("exists")
+	// DoesNotExist requires the label to be missing
+	DoesNotExist = Operator("!")
+)
+
+// Requirement is a single condition on a label
+type Requirement struct {
+	Key      string
+	Operator Operator
+	Values   []string
+}
+
+// Matches returns whether labels satisfy the requirement
+func (r Requirement) Matches(labels Labels) bool {
+	value, ok := labels[r.Key]
+
+	switch r.Operator {
+	case Equals:
+		return ok && len(r.Values) == 1 && value == r.Values[0]
+	case NotEquals:
+		return !ok || len(r.Values) != 1 || value != r.Values[0]
+	case In:
+		return ok && contains(r.Values, value)
+	case NotIn:
+		return !ok || !contains(r.Values, value)
+	case Exists:
+		return ok
+	case DoesNotExist:
+		return !ok
+	}
+
+	return false
+}
+
+// String returns the requirement in selector syntax
+func (r Requirement) String() string {
+	switch r.Operator {
+	case Equals, NotEquals:
+		return r.Key + string(r.Operator) + strings.Join(r.Values, "")
+	case In, NotIn:
+		return r.Key + " " + string(r.Operator) + " (" + strings.Join(r.Values, ",") + ")"
+	case DoesNotExist:
+		return "!" + r.Key
+	}
+
+	return r.Key
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Selector matches labels when all of its requirements match.
+// An empty Selector matches all labels.
+type Selector []Requirement
+
+// Matches returns whether labels satisfy every requirement of the selector
+func (s Selector) Matches(labels Labels) bool {
+	for _, r := range s {
+		if !r.Matches(labels) {
+			return false
+		}
+	}
+	return true
+}
+
+// String returns the selector in the syntax accepted by ParseSelector
+func (s Selector) String() string {
+	requirements := make([]string, len(s))
+	for i, r := range s {
+		requirements[i] = r.String()
+	}
+	return strings.Join(requirements, ",")
+}
+
+var (
+	setRequirement = regexp.MustCompile(`^([^\s=!(),]+)\s+(in|notin)\s*\(([^()]*)\)$`)
+	validKey       = regexp.MustCompile(`^[^\s=!(),]+$`)
+)
+
+// ParseSelector parses a comma separated list of requirements:
+//
+//	env=prod          label env is prod
+//	tier!=db          label tier is missing or not db
+//	region in (us,eu) label region is us or eu
+//	os notin (win)    label os is missing or not win
+//	gpu               label gpu is set
+//	!gpu              label gpu is missing
+func ParseSelector(s string) (Selector, error) {
+	var selector Selector
+	for _, part := range splitRequirements(s) {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		r, err := parseRequirement(part)
+		if err != nil {
+			return nil, err
+		}
+		selector = append(selector, r)
+	}
+
+	return selector, nil
+}
+
+// MustParseSelector is like ParseSelector, but panics on error
+func MustParseSelector(s string) Selector {
+	selector, err := ParseSelector(s)
+	if err != nil {
+		panic(err)
+	}
+	return selector
+}
+
+// splitRequirements splits s on commas outside of parentheses
+func splitRequirements(s string) (parts []string) {
+	depth, start := 0, 0
+	for i, c := range s {
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				parts = append(parts, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(parts, s[start:])
+}
+
+func parseRequirement(s string) (r Requirement, err error) {
+	if m := setRequirement.FindStringSubmatch(s); m != nil {
+		r = Requirement{Key: m[1], Operator: Operator(m[2])}
+		for _, v := range strings.Split(m[3], ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				r.Values = append(r.Values, v)
+			}
+		}
+		if len(r.Values) == 0 {
+			return r, fmt.Errorf("invalid requirement: %s: no values", s)
+		}
+		return r, nil
+	}
+
+	switch {
+	case strings.HasPrefix(s, "!") && !strings.Contains(s, "="):
+		r = Requirement{Key: strings.TrimSpace(s[1:]), Operator: DoesNotExist}
+	case strings.Contains(s, "!="):
+		r = newValueRequirement(strings.SplitN(s, "!=", 2), NotEquals)
+	case strings.Contains(s, "=="):
+		r = newValueRequirement(strings.SplitN(s, "==", 2), Equals)
+	case strings.Contains(s, "="):
+		r = newValueRequirement(strings.SplitN(s, "=", 2), Equals)
+	default:
+		r = Requirement{Key: s, Operator: Exists}
+	}
+
+	if !validKey.MatchString(r.Key) {
+		return r, fmt.Errorf("invalid requirement: %s", s)
+	}
+	return r, nil
+}
+
+func newValueRequirement(pair []string, op Operator) Requirement {
+	return Requirement{
+		Key:      strings.TrimSpace(pair[0]),
+		Operator: op,
+		Values:   []string{strings.TrimSpace(pair[1])},
+	}
+}
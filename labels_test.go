@@ -0,0 +1,57 @@
+package quantum
+
+import "testing"
+
+func TestParseSelector(t *testing.T) {
+	selector, err := ParseSelector("env=prod, region in (us, eu),!gpu,tier!=db,ssd,os notin (windows)")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "env=prod,region in (us,eu),!gpu,tier!=db,ssd,os notin (windows)"
+	if selector.String() != expected {
+		t.Fatalf("'%s' != '%s'", selector.String(), expected)
+	}
+}
+
+func TestParseSelectorErr(t *testing.T) {
+	for _, s := range []string{"=prod", "region in ()", "a b"} {
+		if _, err := ParseSelector(s); err == nil {
+			t.Fatalf("expected error parsing '%s'", s)
+		}
+	}
+}
+
+func TestSelectorMatches(t *testing.T) {
+	labels := Labels{
+		"env":    "prod",
+		"region": "eu",
+		"ssd":    "true",
+	}
+
+	matches := map[string]bool{
+		"":                           true,
+		"env=prod":                   true,
+		"env==prod":                  true,
+		"env=dev":                    false,
+		"env!=dev":                   true,
+		"tier!=db":                   true,
+		"region in (us,eu)":          true,
+		"region in (us,ap)":          false,
+		"region notin (us)":          true,
+		"tier notin (db)":            true,
+		"ssd":                        true,
+		"gpu":                        false,
+		"!gpu":                       true,
+		"!ssd":                       false,
+		"env=prod,!gpu,region=eu":    true,
+		"env=prod,!gpu,region=us":    false,
+		"env=prod,region notin (eu)": false,
+	}
+
+	for s, expected := range matches {
+		if MustParseSelector(s).Matches(labels) != expected {
+			t.Fatalf("expected '%s' match to be %t", s, expected)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package mdns
+
+import (
+	"strings"
+
+	"github.com/doubledutch/quantum"
+)
+
+const (
+	// DefaultDomain is the mDNS domain
+	DefaultDomain = "local."
+	// agentKey is the TXT key of the agent name
+	agentKey = "quantum.agent"
+)
+
+// serviceName returns the DNS-SD service of type t, _t._tcp
+func serviceName(t string) string {
+	return "_" + t + "._tcp"
+}
+
+// instanceName returns a single label instance name for agent
+func instanceName(agent string) string {
+	return strings.Replace(agent, ".", "-", -1)
+}
+
+// encodeTXT returns the TXT records advertising agent and labels
+func encodeTXT(agent string, labels quantum.Labels) []string {
+	txt := []string{agentKey + "=" + agent}
+	for k, v := range labels {
+		txt = append(txt, k+"="+v)
+	}
+	return txt
+}
+
+// decodeTXT parses the agent name and labels from TXT records
+func decodeTXT(txt []string) (string, quantum.Labels) {
+	var agent string
+	labels := make(quantum.Labels)
+	for _, field := range txt {
+		kv := strings.SplitN(field, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		if kv[0] == agentKey {
+			agent = kv[1]
+			continue
+		}
+		labels[kv[0]] = kv[1]
+	}
+	return agent, labels
+}
@@ -0,0 +1,100 @@
+package mdns
+
+import (
+	"net"
+	"os"
+
+	"github.com/doubledutch/quantum"
+	"github.com/hashicorp/go-multierror"
+	"github.com/hashicorp/mdns"
+)
+
+// Registrator announces each job type over mDNS as _type._tcp
+type Registrator struct {
+	// Agent is the name of the agent, defaulting to the hostname
+	Agent string
+	// Domain is the mDNS domain, defaulting to DefaultDomain
+	Domain string
+	// IPs are the addresses announced, defaulting to the IP the agent is
+	// bound to, or those of the hostname when bound to every interface
+	IPs []net.IP
+	// Iface is the multicast interface, defaulting to the system default
+	Iface *net.Interface
+	// Labels of the agent, announced as TXT records
+	Labels quantum.Labels
+
+	servers []*mdns.Server
+}
+
+// NewRegistrator creates a Registrator announcing agent
+func NewRegistrator(agent string) *Registrator {
+	return &Registrator{
+		Agent: agent,
+	}
+}
+
+// SetLabels sets the labels advertised for the agent
+func (r *Registrator) SetLabels(labels quantum.Labels) {
+	r.Labels = labels
+}
+
+// Register starts an mDNS responder for each type of reg. Agents must
+// listen on TCP.
+func (r *Registrator) Register(addr net.Addr, reg quantum.Registry) error {
+	port, err := quantum.TCPPort(addr)
+	if err != nil {
+		return err
+	}
+
+	ips := r.IPs
+	if ips == nil {
+		if ip := addr.(*net.TCPAddr).IP; ip != nil && !ip.IsUnspecified() {
+			ips = []net.IP{ip}
+		}
+	}
+
+	agent := r.Agent
+	if agent == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return err
+		}
+		agent = hostname
+	}
+
+	domain := r.Domain
+	if domain == "" {
+		domain = DefaultDomain
+	}
+
+	var result error
+	for _, t := range reg.Types() {
+		service, err := mdns.NewMDNSService(instanceName(agent), serviceName(t), domain, "", port, ips, encodeTXT(agent, r.Labels))
+		if err != nil {
+			result = multierror.Append(result, err)
+			continue
+		}
+
+		server, err := mdns.NewServer(&mdns.Config{Zone: service, Iface: r.Iface})
+		if err != nil {
+			result = multierror.Append(result, err)
+			continue
+		}
+		r.servers = append(r.servers, server)
+	}
+
+	return result
+}
+
+// Deregister stops announcing the types
+func (r *Registrator) Deregister() error {
+	var result error
+	for _, server := range r.servers {
+		if err := server.Shutdown(); err != nil {
+			result = multierror.Append(result, err)
+		}
+	}
+	r.servers = nil
+
+	return result
+}
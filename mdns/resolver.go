@@ -0,0 +1,156 @@
+package mdns
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/doubledutch/lager"
+	"github.com/doubledutch/quantum"
+	"github.com/doubledutch/quantum/client"
+	"github.com/hashicorp/mdns"
+)
+
+// DefaultBrowseTimeout is the default time spent browsing for agents
+const DefaultBrowseTimeout = time.Second
+
+// ResolverConfig configures a ClientResolver
+type ResolverConfig struct {
+	// Domain is the mDNS domain, defaulting to DefaultDomain
+	Domain string
+	// Iface is the multicast interface, defaulting to the system default
+	Iface *net.Interface
+	// BrowseTimeout is the time spent browsing for agents
+	BrowseTimeout time.Duration
+
+	ConnConfig *quantum.ConnConfig
+}
+
+// ClientResolver browses for agents announced by Registrator
+type ClientResolver struct {
+	config *quantum.ConnConfig
+	lgr    lager.Lager
+
+	domain  string
+	iface   *net.Interface
+	timeout time.Duration
+}
+
+// NewClientResolver creates a ClientResolver using config
+func NewClientResolver(rc *ResolverConfig) *ClientResolver {
+	config := rc.ConnConfig
+	if config == nil {
+		config = quantum.DefaultConnConfig()
+	}
+	if config.Config == nil {
+		// Copied, so the caller's ConnConfig isn't modified
+		c := *config
+		c.Config = quantum.DefaultConfig()
+		config = &c
+	}
+
+	domain := rc.Domain
+	if domain == "" {
+		domain = DefaultDomain
+	}
+
+	timeout := rc.BrowseTimeout
+	if timeout == 0 {
+		timeout = DefaultBrowseTimeout
+	}
+
+	return &ClientResolver{
+		config:  config,
+		lgr:     config.Lager,
+		domain:  domain,
+		iface:   rc.Iface,
+		timeout: timeout,
+	}
+}
+
+// Resolve resolves a ClientConn using a ResolveRequest
+func (r *ClientResolver) Resolve(request quantum.ResolveRequest) (quantum.ClientConn, error) {
+	candidates, err := r.Candidates(request)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(candidates) == 0 {
+		return nil, quantum.NoAgentsFromRequest(request)
+	}
+
+	addresses := make([]string, len(candidates))
+	for i, candidate := range candidates {
+		addresses[i] = candidate.Address
+	}
+
+	return quantum.DialFirst(client.New(r.config), addresses, r.config.GetDialTimeout())
+}
+
+// Candidates browses for the agents serving request.Type
+func (r *ClientResolver) Candidates(request quantum.ResolveRequest) ([]quantum.Candidate, error) {
+	entriesCh := make(chan *mdns.ServiceEntry, 16)
+	done := make(chan []*mdns.ServiceEntry)
+	go func() {
+		var entries []*mdns.ServiceEntry
+		for entry := range entriesCh {
+			entries = append(entries, entry)
+		}
+		done <- entries
+	}()
+
+	err := mdns.Query(&mdns.QueryParam{
+		Service:   serviceName(request.Type),
+		Domain:    strings.Trim(r.domain, "."),
+		Timeout:   r.timeout,
+		Interface: r.iface,
+		Entries:   entriesCh,
+	})
+	close(entriesCh)
+	entries := <-done
+
+	if err != nil {
+		r.lgr.Errorf("mDNS query failed: %s\n", err)
+		return nil, err
+	}
+
+	return newCandidates(entries, request), nil
+}
+
+// newCandidates returns the candidates of entries matching request,
+// ignoring duplicate responses.
+func newCandidates(entries []*mdns.ServiceEntry, request quantum.ResolveRequest) []quantum.Candidate {
+	seen := make(map[string]bool)
+	var candidates []quantum.Candidate
+	for _, entry := range entries {
+		agent, labels := decodeTXT(entry.InfoFields)
+		if request.Agent != "" && agent != request.Agent {
+			continue
+		}
+		if !request.Selector.Matches(labels) {
+			continue
+		}
+
+		ip := entry.AddrV4
+		if ip == nil {
+			ip = entry.AddrV6
+		}
+		if ip == nil {
+			continue
+		}
+
+		address := net.JoinHostPort(ip.String(), strconv.Itoa(entry.Port))
+		if seen[address] {
+			continue
+		}
+		seen[address] = true
+
+		candidates = append(candidates, quantum.Candidate{
+			Address: address,
+			Agent:   agent,
+		})
+	}
+
+	return candidates
+}
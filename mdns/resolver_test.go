@@ -0,0 +1,61 @@
+package mdns
+
+import (
+	"net"
+	"testing"
+
+	"github.com/doubledutch/quantum"
+	"github.com/hashicorp/mdns"
+)
+
+func TestTXT(t *testing.T) {
+	agent, labels := decodeTXT(encodeTXT("agent1.example", quantum.Labels{"env": "prod"}))
+	if agent != "agent1.example" {
+		t.Fatalf("expected agent1.example, got %s", agent)
+	}
+	if len(labels) != 1 || labels["env"] != "prod" {
+		t.Fatalf("expected env=prod, got %v", labels)
+	}
+}
+
+func TestNewCandidates(t *testing.T) {
+	entries := []*mdns.ServiceEntry{
+		{
+			AddrV4:     net.ParseIP("10.0.0.1"),
+			Port:       8001,
+			InfoFields: encodeTXT("agent1", quantum.Labels{"env": "prod"}),
+		},
+		// Duplicate response
+		{
+			AddrV4:     net.ParseIP("10.0.0.1"),
+			Port:       8001,
+			InfoFields: encodeTXT("agent1", quantum.Labels{"env": "prod"}),
+		},
+		{
+			AddrV6:     net.ParseIP("fe80::1"),
+			Port:       8002,
+			InfoFields: encodeTXT("agent2", nil),
+		},
+	}
+
+	candidates := newCandidates(entries, quantum.ResolveRequest{Type: "test"})
+	if len(candidates) != 2 {
+		t.Fatalf("expected 2 candidates, got %v", candidates)
+	}
+	if candidates[1].Address != "[fe80::1]:8002" {
+		t.Fatalf("expected [fe80::1]:8002, got %s", candidates[1].Address)
+	}
+
+	candidates = newCandidates(entries, quantum.ResolveRequest{Type: "test", Agent: "agent2"})
+	if len(candidates) != 1 || candidates[0].Agent != "agent2" {
+		t.Fatalf("expected agent2, got %v", candidates)
+	}
+
+	candidates = newCandidates(entries, quantum.ResolveRequest{
+		Type:     "test",
+		Selector: quantum.MustParseSelector("env=prod"),
+	})
+	if len(candidates) != 1 || candidates[0].Agent != "agent1" {
+		t.Fatalf("expected agent1, got %v", candidates)
+	}
+}
@@ -0,0 +1,386 @@
+package plugin
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/rpc"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/doubledutch/lager"
+	"github.com/doubledutch/mux"
+	"github.com/doubledutch/quantum"
+)
+
+const (
+	// DefaultStartTimeout is the default time a plugin has to connect
+	DefaultStartTimeout = 10 * time.Second
+)
+
+// RestartInterval is the time waited before launching a plugin that exited,
+// read when the plugin is first launched
+var RestartInterval = time.Second
+
+// Discover returns the executables in dir
+func Discover(dir string) ([]string, error) {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var paths []string
+	for _, info := range infos {
+		if info.IsDir() || info.Mode()&0111 == 0 {
+			continue
+		}
+		paths = append(paths, filepath.Join(dir, info.Name()))
+	}
+
+	return paths, nil
+}
+
+// LoadDir launches every plugin in dir and adds their jobs to reg.
+// The returned processes should be closed when the agent shuts down.
+func LoadDir(dir string, reg quantum.Registry, lgr lager.Lager) ([]*Process, error) {
+	paths, err := Discover(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var procs []*Process
+	for _, path := range paths {
+		proc, err := Launch(path, lgr)
+		if err != nil {
+			lgr.Errorf("Unable to launch plugin %s: %s", path, err)
+			continue
+		}
+
+		jobs, err := proc.Jobs()
+		if err != nil {
+			lgr.Errorf("Unable to get jobs of plugin %s: %s", path, err)
+			proc.Close()
+			continue
+		}
+
+		for _, job := range jobs {
+			reg.Add(job)
+		}
+		procs = append(procs, proc)
+	}
+
+	return procs, nil
+}
+
+// Process is a running plugin. Plugins that exit are launched again, so
+// their jobs stay available until Close.
+type Process struct {
+	path            string
+	dir             string
+	lgr             lager.Lager
+	restartInterval time.Duration
+
+	mu     sync.Mutex
+	cmd    *exec.Cmd
+	client *rpc.Client
+	exited chan struct{}
+
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// Launch starts the plugin at path and waits for it to connect
+func Launch(path string, lgr lager.Lager) (*Process, error) {
+	dir, err := ioutil.TempDir("", "quantum-plugin")
+	if err != nil {
+		return nil, err
+	}
+
+	p := &Process{
+		path:            path,
+		dir:             dir,
+		lgr:             lgr,
+		restartInterval: RestartInterval,
+		closed:          make(chan struct{}),
+	}
+	if err := p.start(); err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+
+	go p.supervise()
+	return p, nil
+}
+
+// start starts the process of the plugin and waits for it to connect.
+// p.mu must be held once the Process is shared.
+func (p *Process) start() error {
+	addr := filepath.Join(p.dir, "plugin.sock")
+	ln, err := net.ListenUnix("unix", &net.UnixAddr{Name: addr, Net: "unix"})
+	if err != nil {
+		return err
+	}
+	defer ln.Close()
+
+	cmd := exec.Command(p.path)
+	cmd.Env = append(os.Environ(), EnvAddr+"="+addr)
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return err
+	}
+
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	exited := make(chan struct{})
+	go func() {
+		// Wait must not be called before stderr is read to completion
+		p.forward(stderr)
+		cmd.Wait()
+		close(exited)
+	}()
+
+	// Stop waiting if the plugin exits before connecting
+	accepted := make(chan struct{})
+	go func() {
+		select {
+		case <-exited:
+			ln.Close()
+		case <-accepted:
+		}
+	}()
+
+	ln.SetDeadline(time.Now().Add(DefaultStartTimeout))
+	conn, err := ln.Accept()
+	close(accepted)
+	if err != nil {
+		cmd.Process.Kill()
+		<-exited
+		return err
+	}
+
+	p.cmd = cmd
+	p.client = rpc.NewClient(conn)
+	p.exited = exited
+	return nil
+}
+
+// supervise launches the plugin again each time it exits, until Close
+func (p *Process) supervise() {
+	for {
+		select {
+		case <-p.Exited():
+		case <-p.closed:
+			return
+		}
+
+		p.lgr.Errorf("plugin %s exited, restarting", filepath.Base(p.path))
+		for {
+			select {
+			case <-time.After(p.restartInterval):
+			case <-p.closed:
+				return
+			}
+
+			p.mu.Lock()
+			select {
+			case <-p.closed:
+				p.mu.Unlock()
+				return
+			default:
+			}
+			err := p.start()
+			p.mu.Unlock()
+
+			if err == nil {
+				break
+			}
+			p.lgr.Errorf("Unable to restart plugin %s: %s", filepath.Base(p.path), err)
+		}
+	}
+}
+
+// forward logs the output of the plugin
+func (p *Process) forward(r io.Reader) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		p.lgr.Infof("plugin %s: %s", filepath.Base(p.path), scanner.Text())
+	}
+}
+
+// Jobs returns a Job for every type served by the plugin
+func (p *Process) Jobs() ([]quantum.Job, error) {
+	var types []string
+	if err := p.call("Types", 0, &types); err != nil {
+		return nil, err
+	}
+
+	jobs := make([]quantum.Job, len(types))
+	for i, t := range types {
+		jobs[i] = &Job{
+			typ:  t,
+			proc: p,
+		}
+	}
+
+	return jobs, nil
+}
+
+// Exited returns a chan that is closed when the current plugin process
+// exits
+func (p *Process) Exited() <-chan struct{} {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.exited
+}
+
+// Close stops the plugin
+func (p *Process) Close() error {
+	p.closeOnce.Do(func() { close(p.closed) })
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.client.Close()
+	select {
+	case <-p.exited:
+	default:
+		p.cmd.Process.Kill()
+		<-p.exited
+	}
+
+	return os.RemoveAll(p.dir)
+}
+
+func (p *Process) call(method string, args interface{}, reply interface{}) error {
+	p.mu.Lock()
+	client := p.client
+	p.mu.Unlock()
+
+	err := client.Call(serviceName+"."+method, args, reply)
+	if err == nil {
+		return nil
+	}
+
+	if serr, ok := err.(rpc.ServerError); ok {
+		return errors.New(string(serr))
+	}
+
+	// Any other error is a broken connection
+	p.lgr.Errorf("plugin %s call %s failed: %s", filepath.Base(p.path), method, err)
+	return ErrPluginExited
+}
+
+// Job proxies a job served by a plugin. Registries run a new Job for each
+// request, see NewJob.
+type Job struct {
+	typ  string
+	proc *Process
+
+	// id is the ID of the job configured within the plugin
+	id uint64
+}
+
+// Type returns the type of the job
+func (j *Job) Type() string {
+	return j.typ
+}
+
+// String describes the job in logs
+func (j *Job) String() string {
+	return "plugin " + filepath.Base(j.proc.path) + ": " + j.typ
+}
+
+// NewJob returns a Job of the same type, holding the data of a single request
+func (j *Job) NewJob() quantum.Job {
+	return &Job{
+		typ:  j.typ,
+		proc: j.proc,
+	}
+}
+
+// Configure configures a job within the plugin, which Run starts
+func (j *Job) Configure(p []byte) error {
+	return j.proc.call("Configure", ConfigureArgs{Type: j.typ, Data: p}, &j.id)
+}
+
+// Run runs the configured job within the plugin, relaying logs, signals,
+// stdin, window sizes and files
+func (j *Job) Run(conn quantum.AgentConn) error {
+	if j.id == 0 {
+		return ErrJobNotConfigured
+	}
+
+	args := StartArgs{
+		Job:       j.id,
+		Workspace: conn.Workspace(),
+		Handshake: conn.Handshake(),
+	}
+
+	var id uint64
+	if err := j.proc.call("Start", args, &id); err != nil {
+		return err
+	}
+
+	exitCh := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case sig, ok := <-conn.Signals():
+				if !ok {
+					return
+				}
+				if s, ok := sig.(syscall.Signal); ok {
+					j.proc.call("Signal", SignalArgs{ID: id, Signal: int(s)}, nil)
+				}
+			case data := <-conn.Stdin():
+				j.proc.call("Stdin", StdinArgs{ID: id, Data: data}, nil)
+			case size := <-conn.WindowSizes():
+				j.proc.call("WindowSize", WindowSizeArgs{ID: id, Size: size}, nil)
+			case chunk := <-conn.Files():
+				j.proc.call("File", FileArgs{ID: id, Chunk: chunk}, nil)
+			case <-exitCh:
+				return
+			}
+		}
+	}()
+
+	err := j.poll(conn, id)
+	close(exitCh)
+	wg.Wait()
+
+	return err
+}
+
+func (j *Job) poll(conn quantum.AgentConn, id uint64) error {
+	for {
+		var reply PollReply
+		if err := j.proc.call("Poll", PollArgs{ID: id}, &reply); err != nil {
+			return err
+		}
+
+		for _, log := range reply.Logs {
+			conn.Send(mux.LogType, log)
+		}
+		for _, chunk := range reply.Chunks {
+			conn.Send(quantum.FileChunkType, chunk)
+		}
+
+		if reply.Done {
+			if reply.Err != "" {
+				return quantum.NewError(reply.Code, reply.Err)
+			}
+			return nil
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package plugin
+
+import (
+	"errors"
+
+	"github.com/doubledutch/quantum"
+)
+
+const (
+	// EnvAddr is the environment variable holding the address of the unix
+	// socket a plugin connects to
+	EnvAddr = "QUANTUM_PLUGIN_ADDR"
+
+	// serviceName is the name of the RPC service served by plugins
+	serviceName = "Plugin"
+)
+
+var (
+	// ErrNotPlugin = the process was not launched as a plugin
+	ErrNotPlugin = errors.New("Not launched as a plugin")
+	// ErrPluginExited = the plugin process exited or closed its connection
+	ErrPluginExited = errors.New("Plugin exited")
+	// ErrRunNotFound = a run with the requested ID does not exist
+	ErrRunNotFound = errors.New("Plugin run not found")
+	// ErrJobNotConfigured = a job is started without being configured
+	ErrJobNotConfigured = errors.New("Plugin job not configured")
+)
+
+// ConfigureArgs are the arguments of Plugin.Configure, which replies with
+// the ID of the configured job
+type ConfigureArgs struct {
+	Type string
+	Data []byte
+}
+
+// StartArgs are the arguments of Plugin.Start. Job is the ID of a job
+// replied by Plugin.Configure, each configured job is started once.
+type StartArgs struct {
+	Job uint64
+	// Workspace is the workspace allocated to the job by the agent
+	Workspace string
+	// Handshake is the handshake negotiated by the agent with the client
+	Handshake *quantum.Handshake
+}
+
+// PollArgs are the arguments of Plugin.Poll
+type PollArgs struct {
+	ID uint64
+}
+
+// PollReply is the reply of Plugin.Poll. Logs holds the logs produced since
+// the last poll. Done is set once the job has returned, with Err holding the
+// error message of the job, if any, and Code its quantum.ErrorCode.
+type PollReply struct {
+	Logs   []string
+	Chunks []quantum.FileChunk
+	Done   bool
+	Err    string
+	Code   quantum.ErrorCode
+}
+
+// SignalArgs are the arguments of Plugin.Signal
+type SignalArgs struct {
+	ID     uint64
+	Signal int
+}
+
+// StdinArgs are the arguments of Plugin.Stdin
+type StdinArgs struct {
+	ID   uint64
+	Data []byte
+}
+
+// FileArgs are the arguments of Plugin.File
+type FileArgs struct {
+	ID    uint64
+	Chunk quantum.FileChunk
+}
+
+// WindowSizeArgs are the arguments of Plugin.WindowSize
+type WindowSizeArgs struct {
+	ID   uint64
+	Size quantum.WindowSize
+}
@@ -0,0 +1,220 @@
+package plugin
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/doubledutch/lager"
+	"github.com/doubledutch/mux"
+	"github.com/doubledutch/quantum"
+	"github.com/doubledutch/quantum/inmemory"
+	"github.com/doubledutch/quantum/internal/quantumtest"
+)
+
+const (
+	echoJob  = "echoJob"
+	failJob  = "failJob"
+	crashJob = "crashJob"
+)
+
+type testJob struct {
+	typ        string
+	data       []byte
+	configured int
+}
+
+func (j *testJob) Type() string {
+	return j.typ
+}
+
+func (j *testJob) NewJob() quantum.Job {
+	return &testJob{typ: j.typ}
+}
+
+func (j *testJob) Configure(p []byte) error {
+	if string(p) == "bad" {
+		return errors.New("bad data")
+	}
+	j.data = p
+	j.configured++
+	return nil
+}
+
+func (j *testJob) Run(conn quantum.AgentConn) error {
+	if j.configured != 1 {
+		return fmt.Errorf("configured %d times", j.configured)
+	}
+
+	switch j.typ {
+	case failJob:
+		return errors.New("job failed")
+	case crashJob:
+		os.Exit(1)
+	}
+
+	conn.Send(mux.LogType, "echo "+string(j.data))
+	return nil
+}
+
+// The test binary doubles as the plugin
+func TestMain(m *testing.M) {
+	if os.Getenv(EnvAddr) != "" {
+		Serve(&testJob{typ: echoJob}, &testJob{typ: failJob}, &testJob{typ: crashJob})
+		os.Exit(0)
+	}
+
+	os.Exit(m.Run())
+}
+
+func launch(t *testing.T) (*Process, map[string]quantum.Job) {
+	proc, err := Launch(os.Args[0], lager.NewLogLager(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jobs, err := proc.Jobs()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := make(map[string]quantum.Job)
+	for _, job := range jobs {
+		m[job.Type()] = job
+	}
+	if len(m) != 3 {
+		t.Fatalf("expected 3 jobs, got %d", len(m))
+	}
+
+	return proc, m
+}
+
+func TestPluginRun(t *testing.T) {
+	proc, jobs := launch(t)
+	defer proc.Close()
+
+	job := jobs[echoJob]
+	if err := job.Configure([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+
+	conn := quantumtest.NewAgentConn()
+	if err := job.Run(conn); err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Join(conn.Sent(), "") != "echo hello" {
+		t.Fatalf("wrong logs: %v", conn.Sent())
+	}
+}
+
+func TestPluginConfigureErr(t *testing.T) {
+	proc, jobs := launch(t)
+	defer proc.Close()
+
+	if err := jobs[echoJob].Configure([]byte("bad")); err == nil || err.Error() != "bad data" {
+		t.Fatalf("expected configure error, got %v", err)
+	}
+}
+
+func TestPluginRunErr(t *testing.T) {
+	proc, jobs := launch(t)
+	defer proc.Close()
+
+	job := jobs[failJob]
+	job.Configure(nil)
+	if err := job.Run(quantumtest.NewAgentConn()); err == nil || err.Error() != "job failed" {
+		t.Fatalf("expected job error, got %v", err)
+	}
+}
+
+func TestPluginCrash(t *testing.T) {
+	proc, jobs := launch(t)
+	defer proc.Close()
+
+	job := jobs[crashJob]
+	job.Configure(nil)
+	if err := job.Run(quantumtest.NewAgentConn()); err != ErrPluginExited {
+		t.Fatalf("expected plugin exited, got %v", err)
+	}
+}
+
+func TestPluginNotConfigured(t *testing.T) {
+	proc, jobs := launch(t)
+	defer proc.Close()
+
+	if err := jobs[echoJob].Run(quantumtest.NewAgentConn()); err != ErrJobNotConfigured {
+		t.Fatalf("expected not configured, got %v", err)
+	}
+}
+
+func TestPluginRestart(t *testing.T) {
+	RestartInterval = 10 * time.Millisecond
+	defer func() { RestartInterval = time.Second }()
+
+	proc, jobs := launch(t)
+	defer proc.Close()
+
+	proc.mu.Lock()
+	proc.cmd.Process.Kill()
+	proc.mu.Unlock()
+
+	job := jobs[echoJob]
+	if err := job.Configure([]byte("hello")); err != ErrPluginExited {
+		t.Fatalf("expected plugin exited, got %v", err)
+	}
+
+	// The plugin is launched again
+	deadline := time.Now().Add(5 * time.Second)
+	for job.Configure([]byte("hello")) != nil {
+		if time.Now().After(deadline) {
+			t.Fatal("expected plugin to restart")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	conn := quantumtest.NewAgentConn()
+	if err := job.Run(conn); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(conn.Sent(), "") != "echo hello" {
+		t.Fatalf("wrong logs: %v", conn.Sent())
+	}
+}
+
+func TestPluginConcurrentRuns(t *testing.T) {
+	proc, jobs := launch(t)
+	defer proc.Close()
+
+	reg := inmemory.NewRegistry(lager.NewLogLager(nil))
+	reg.Add(jobs[echoJob])
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(data string) {
+			defer wg.Done()
+
+			job, err := reg.Get(quantum.NewRequest(echoJob, data))
+			if err != nil {
+				t.Error(err)
+				return
+			}
+
+			conn := quantumtest.NewAgentConn()
+			if err := job.Run(conn); err != nil {
+				t.Error(err)
+				return
+			}
+			if logs := strings.Join(conn.Sent(), ""); logs != "echo "+data {
+				t.Errorf("expected echo %s, got %s", data, logs)
+			}
+		}(strconv.Itoa(i))
+	}
+	wg.Wait()
+}
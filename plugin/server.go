@@ -0,0 +1,379 @@
+package plugin
+
+import (
+	"fmt"
+	"net"
+	"net/rpc"
+	"os"
+	"runtime/debug"
+	"sync"
+	"syscall"
+
+	"github.com/doubledutch/lager"
+	"github.com/doubledutch/mux"
+	"github.com/doubledutch/quantum"
+)
+
+// Serve serves jobs to the agent that launched this process. It blocks
+// until the agent closes the connection.
+func Serve(jobs ...quantum.Job) error {
+	addr := os.Getenv(EnvAddr)
+	if addr == "" {
+		return ErrNotPlugin
+	}
+
+	conn, err := net.Dial("unix", addr)
+	if err != nil {
+		return err
+	}
+
+	// The agent forwards stderr to its own Lager
+	lgr := lager.NewLogLager(&lager.LogConfig{
+		Levels: lager.LevelsFromString("DIE"),
+		Output: os.Stderr,
+	})
+
+	srv := rpc.NewServer()
+	if err := srv.RegisterName(serviceName, newService(lgr, jobs)); err != nil {
+		return err
+	}
+
+	srv.ServeConn(conn)
+	return nil
+}
+
+// service is the RPC service of a plugin
+type service struct {
+	lgr  lager.Lager
+	jobs map[string]quantum.Job
+
+	mu         sync.Mutex
+	nextID     uint64
+	configured map[uint64]quantum.Job
+	runs       map[uint64]*run
+}
+
+func newService(lgr lager.Lager, jobs []quantum.Job) *service {
+	s := &service{
+		lgr:        lgr,
+		jobs:       make(map[string]quantum.Job),
+		configured: make(map[uint64]quantum.Job),
+		runs:       make(map[uint64]*run),
+	}
+	for _, job := range jobs {
+		s.jobs[job.Type()] = job
+	}
+
+	return s
+}
+
+// Types replies with the job types of the plugin
+func (s *service) Types(_ int, reply *[]string) error {
+	for t := range s.jobs {
+		*reply = append(*reply, t)
+	}
+	return nil
+}
+
+// Configure configures a job of args.Type with args.Data, replying with the
+// ID of the job to start. Jobs implementing quantum.JobFactory configure a
+// new job each time.
+func (s *service) Configure(args ConfigureArgs, reply *uint64) error {
+	job, err := s.job(args.Type)
+	if err != nil {
+		return err
+	}
+
+	if err := job.Configure(args.Data); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.nextID++
+	id := s.nextID
+	s.configured[id] = job
+	s.mu.Unlock()
+
+	*reply = id
+	return nil
+}
+
+// Start runs the configured job args.Job, replying with the ID of the run
+func (s *service) Start(args StartArgs, reply *uint64) error {
+	s.mu.Lock()
+	job, ok := s.configured[args.Job]
+	delete(s.configured, args.Job)
+	s.mu.Unlock()
+	if !ok {
+		return ErrJobNotConfigured
+	}
+
+	r := newRun(s.lgr)
+	r.workspace = args.Workspace
+	r.handshake = args.Handshake
+
+	s.mu.Lock()
+	s.nextID++
+	id := s.nextID
+	s.runs[id] = r
+	s.mu.Unlock()
+
+	go r.start(job)
+
+	*reply = id
+	return nil
+}
+
+// Poll blocks until the run has new logs or is done
+func (s *service) Poll(args PollArgs, reply *PollReply) error {
+	r, err := s.run(args.ID)
+	if err != nil {
+		return err
+	}
+
+	*reply = r.poll()
+	if reply.Done {
+		s.mu.Lock()
+		delete(s.runs, args.ID)
+		s.mu.Unlock()
+	}
+
+	return nil
+}
+
+// Signal sends a signal to the run
+func (s *service) Signal(args SignalArgs, _ *int) error {
+	r, err := s.run(args.ID)
+	if err != nil {
+		return err
+	}
+
+	select {
+	case r.sigCh <- syscall.Signal(args.Signal):
+	default:
+	}
+	return nil
+}
+
+// Stdin sends stdin to the run, blocking until the job receives it
+func (s *service) Stdin(args StdinArgs, _ *int) error {
+	r, err := s.run(args.ID)
+	if err != nil {
+		return err
+	}
+
+	select {
+	case r.stdinCh <- args.Data:
+	case <-r.shutdown:
+	}
+	return nil
+}
+
+// File sends a chunk of an uploaded file to the run, blocking until the job
+// receives it
+func (s *service) File(args FileArgs, _ *int) error {
+	r, err := s.run(args.ID)
+	if err != nil {
+		return err
+	}
+
+	select {
+	case r.fileCh <- args.Chunk:
+	case <-r.shutdown:
+	}
+	return nil
+}
+
+// WindowSize sends a window size change to the run
+func (s *service) WindowSize(args WindowSizeArgs, _ *int) error {
+	r, err := s.run(args.ID)
+	if err != nil {
+		return err
+	}
+
+	select {
+	case r.resizeCh <- args.Size:
+	default:
+	}
+	return nil
+}
+
+// job returns the job of type t, or a new one if it's a quantum.JobFactory
+func (s *service) job(t string) (quantum.Job, error) {
+	job, ok := s.jobs[t]
+	if !ok {
+		return nil, fmt.Errorf("job not found with type: %s", t)
+	}
+
+	if factory, ok := job.(quantum.JobFactory); ok {
+		job = factory.NewJob()
+	}
+	return job, nil
+}
+
+func (s *service) run(id uint64) (*run, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r, ok := s.runs[id]
+	if !ok {
+		return nil, ErrRunNotFound
+	}
+	return r, nil
+}
+
+// run is a single run of a job, and the quantum.AgentConn given to the job
+type run struct {
+	// Jobs only Send logs, the remaining methods are not supported
+	mux.Server
+
+	lgr       lager.Lager
+	workspace string
+	handshake *quantum.Handshake
+	outCh     chan string
+	sigCh     chan os.Signal
+	stdinCh   chan []byte
+	resizeCh  chan quantum.WindowSize
+	fileCh    chan quantum.FileChunk
+	shutdown  chan struct{}
+
+	mu     sync.Mutex
+	notify chan struct{}
+	logs   []string
+	chunks []quantum.FileChunk
+	done   bool
+	err    error
+}
+
+func newRun(lgr lager.Lager) *run {
+	return &run{
+		lgr:      lgr,
+		outCh:    make(chan string, 1),
+		sigCh:    make(chan os.Signal, 1),
+		stdinCh:  make(chan []byte, 1),
+		resizeCh: make(chan quantum.WindowSize, 1),
+		fileCh:   make(chan quantum.FileChunk, 1),
+		shutdown: make(chan struct{}),
+		notify:   make(chan struct{}, 1),
+	}
+}
+
+func (r *run) start(job quantum.Job) {
+	err := r.runJob(job)
+
+	r.mu.Lock()
+	r.done = true
+	r.err = err
+	r.mu.Unlock()
+	close(r.shutdown)
+	r.wake()
+}
+
+func (r *run) runJob(job quantum.Job) (err error) {
+	defer func() {
+		if rec := recover(); rec != nil {
+			r.lgr.Errorf("job err: %s\n%s", rec, debug.Stack())
+			err = quantum.ErrJobPanicked
+		}
+	}()
+
+	return job.Run(r)
+}
+
+func (r *run) poll() PollReply {
+	for {
+		r.mu.Lock()
+		if len(r.logs) > 0 || len(r.chunks) > 0 || r.done {
+			reply := PollReply{
+				Logs:   r.logs,
+				Chunks: r.chunks,
+				Done:   r.done,
+			}
+			if r.err != nil {
+				reply.Err = r.err.Error()
+				reply.Code = quantum.CodeOf(r.err)
+			}
+			r.logs = nil
+			r.chunks = nil
+			r.mu.Unlock()
+			return reply
+		}
+		r.mu.Unlock()
+
+		<-r.notify
+	}
+}
+
+func (r *run) wake() {
+	select {
+	case r.notify <- struct{}{}:
+	default:
+	}
+}
+
+// Send buffers logs and file chunks for the agent to poll
+func (r *run) Send(t uint8, v interface{}) error {
+	r.mu.Lock()
+	switch t {
+	case mux.LogType:
+		r.logs = append(r.logs, fmt.Sprint(v))
+	case quantum.FileChunkType:
+		chunk, ok := v.(quantum.FileChunk)
+		if !ok {
+			r.mu.Unlock()
+			return fmt.Errorf("unsupported file chunk: %T", v)
+		}
+		r.chunks = append(r.chunks, chunk)
+	default:
+		r.mu.Unlock()
+		return fmt.Errorf("unsupported type: %d", t)
+	}
+	r.mu.Unlock()
+	r.wake()
+	return nil
+}
+
+// IsShutdown returns a chan that is closed when the job returns
+func (r *run) IsShutdown() chan struct{} {
+	return r.shutdown
+}
+
+// Logs returns the logs channel of the run
+func (r *run) Logs() chan string {
+	return r.outCh
+}
+
+// Signals returns the signals channel of the run
+func (r *run) Signals() chan os.Signal {
+	return r.sigCh
+}
+
+// Stdin returns the stdin channel of the run
+func (r *run) Stdin() chan []byte {
+	return r.stdinCh
+}
+
+// Files returns the channel of files uploaded to the run
+func (r *run) Files() chan quantum.FileChunk {
+	return r.fileCh
+}
+
+// WindowSizes returns the window size channel of the run
+func (r *run) WindowSizes() chan quantum.WindowSize {
+	return r.resizeCh
+}
+
+// Workspace returns the workspace allocated to the run by the agent
+func (r *run) Workspace() string {
+	return r.workspace
+}
+
+// Handshake returns the handshake negotiated by the agent
+func (r *run) Handshake() *quantum.Handshake {
+	return r.handshake
+}
+
+// Lager returns the Lager of the plugin
+func (r *run) Lager() lager.Lager {
+	return r.lgr
+}
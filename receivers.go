@@ -2,7 +2,6 @@ package quantum
 
 import (
 	"github.com/doubledutch/mux"
-	"github.com/doubledutch/mux/gob"
 )
 
 // RequestReceiver receives Request
@@ -11,7 +10,7 @@ type RequestReceiver struct {
 	ch  chan Request
 }
 
-// NewRequestReceiver creates a new request receiver
-func NewRequestReceiver(ch chan Request) mux.Receiver {
-	return mux.NewReceiver(ch, new(gob.Pool))
+// NewRequestReceiver creates a new request receiver decoding with pool
+func NewRequestReceiver(ch chan Request, pool mux.Pool) mux.Receiver {
+	return mux.NewReceiver(ch, pool)
 }
@@ -1,10 +1,15 @@
 package quantum
 
-import "github.com/hashicorp/go-multierror"
+import (
+	"net"
 
-// Registrator registers and deregisters services
+	"github.com/hashicorp/go-multierror"
+)
+
+// Registrator registers and deregisters services. Register is given the
+// address the agent is bound to.
 type Registrator interface {
-	Register(port int, reg Registry) error
+	Register(addr net.Addr, reg Registry) error
 	Deregister() error
 }
 
@@ -14,11 +19,11 @@ type MultiRegistrator struct {
 }
 
 // Register calls Register on Registries
-func (r *MultiRegistrator) Register(port int, reg Registry) error {
+func (r *MultiRegistrator) Register(addr net.Addr, reg Registry) error {
 	var result error
 
 	for _, registrator := range r.Registrators {
-		if err := registrator.Register(port, reg); err != nil {
+		if err := registrator.Register(addr, reg); err != nil {
 			result = multierror.Append(result, err)
 		}
 	}
@@ -26,6 +31,15 @@ func (r *MultiRegistrator) Register(port int, reg Registry) error {
 	return result
 }
 
+// SetLabels calls SetLabels on Registries that advertise labels
+func (r *MultiRegistrator) SetLabels(labels Labels) {
+	for _, registrator := range r.Registrators {
+		if lr, ok := registrator.(LabeledRegistrator); ok {
+			lr.SetLabels(labels)
+		}
+	}
+}
+
 // Deregister calls Deregister on Registries
 func (r *MultiRegistrator) Deregister() error {
 	var result error
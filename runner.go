@@ -2,6 +2,7 @@ package quantum
 
 import (
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"runtime"
@@ -22,13 +23,32 @@ func NewBasicRunner() (r Runner) {
 }
 
 // BasicRunner is a basic implementation of Runner
-type BasicRunner struct{}
+type BasicRunner struct {
+	// Stdin is written to the stdin of commands, an empty message closes
+	// it. Messages are only taken while a command runs, so commands that
+	// exit leave the remaining stdin to later commands. Commands have no
+	// stdin when nil.
+	Stdin <-chan []byte
+	// Dir is the working directory of commands, defaulting to the
+	// working directory of the agent
+	Dir string
+}
 
 // Run runs a command and captures the output of the command, while listening
 // for and sending signals to the process.
 func (r *BasicRunner) Run(cmd string,
 	outCh chan<- string,
 	sigCh <-chan os.Signal) error {
+	// Tell the client what we're running.
+	// Note: the tests expect this
+	outCh <- "Running " + cmd + "\n"
+	ec := shellCommand(cmd)
+	ec.Dir = r.Dir
+	return run(ec, r.Stdin, outCh, sigCh)
+}
+
+// shellCommand returns a command running cmd with the shell
+func shellCommand(cmd string) *exec.Cmd {
 	var shell, flag string
 	if runtime.GOOS == "windows" {
 		shell = "cmd"
@@ -42,14 +62,20 @@ func (r *BasicRunner) Run(cmd string,
 		}
 		flag = "-c"
 	}
-	// Tell the client what we're running.
-	// Note: the tests expect this
-	outCh <- "Running " + cmd + "\n"
-	ec := exec.Command(shell, flag, cmd)
-	return run(ec, outCh, sigCh)
+	return exec.Command(shell, flag, cmd)
 }
 
-func run(cmd *exec.Cmd, outCh chan<- string, sigCh <-chan os.Signal) error {
+func run(cmd *exec.Cmd, stdin <-chan []byte, outCh chan<- string, sigCh <-chan os.Signal) error {
+	// Stdin is written to a pipe rather than set as cmd.Stdin, since Wait
+	// would wait for the copy of cmd.Stdin, and so for the client to
+	// close stdin, even once the command exits
+	var inPipe io.WriteCloser
+	if stdin != nil {
+		var err error
+		if inPipe, err = cmd.StdinPipe(); err != nil {
+			return err
+		}
+	}
 	outPipe, err := cmd.StdoutPipe()
 	if err != nil {
 		return err
@@ -77,25 +103,17 @@ func run(cmd *exec.Cmd, outCh chan<- string, sigCh <-chan os.Signal) error {
 		}
 	}()
 
-	// Start the goroutine to watch for the exit
-	go func() {
-		exitStatus := 0
-
-		err := cmd.Wait()
-		doneCh <- struct{}{}
-
-		if exitErr, ok := err.(*exec.ExitError); ok {
-			exitStatus = 1
-
-			// There is no process-independent way to get the REAL
-			// exit status so we just try to go deeper.
-			if status, ok := exitErr.Sys().(syscall.WaitStatus); ok {
-				exitStatus = status.ExitStatus()
-			}
-		}
-
-		exitCh <- exitStatus
-	}()
+	// Stdin sent once run returns is left to the next command
+	exited := make(chan struct{})
+	stdinDone := make(chan struct{})
+	if inPipe != nil {
+		go func() {
+			writeStdin(inPipe, stdin, exited)
+			close(stdinDone)
+		}()
+	} else {
+		close(stdinDone)
+	}
 
 	var streamWg sync.WaitGroup
 	streamWg.Add(2)
@@ -112,11 +130,54 @@ func run(cmd *exec.Cmd, outCh chan<- string, sigCh <-chan os.Signal) error {
 	go streamFunc(stdoutCh)
 	go streamFunc(stderrCh)
 
+	// Start the goroutine to watch for the exit. Wait closes the pipes, so
+	// it's called once the output is read.
+	go func() {
+		streamWg.Wait()
+		err := cmd.Wait()
+		close(exited)
+		doneCh <- struct{}{}
+		exitCh <- exitStatus(err)
+	}()
+
 	exitStatus := <-exitCh
-	streamWg.Wait()
+	<-stdinDone
 
 	if exitStatus != 0 {
 		return fmt.Errorf("run failed with exit code: %v", exitStatus)
 	}
 	return nil
 }
+
+// writeStdin writes stdin to w until an empty message, or the command exits
+func writeStdin(w io.WriteCloser, stdin <-chan []byte, exited <-chan struct{}) {
+	defer w.Close()
+	for {
+		select {
+		case data, ok := <-stdin:
+			if !ok || len(data) == 0 {
+				return
+			}
+			if _, err := w.Write(data); err != nil {
+				return
+			}
+		case <-exited:
+			return
+		}
+	}
+}
+
+// exitStatus returns the exit status of the error returned by exec.Cmd.Wait
+func exitStatus(err error) int {
+	exitErr, ok := err.(*exec.ExitError)
+	if !ok {
+		return 0
+	}
+
+	// There is no process-independent way to get the REAL
+	// exit status so we just try to go deeper.
+	if status, ok := exitErr.Sys().(syscall.WaitStatus); ok {
+		return status.ExitStatus()
+	}
+	return 1
+}
@@ -0,0 +1,117 @@
+package quantum
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/creack/pty"
+)
+
+// DefaultWindowSize is the size of terminals before the client sends one
+var DefaultWindowSize = WindowSize{Rows: 24, Cols: 80}
+
+// HangupTimeout is how long commands have to exit once their terminal
+// hangs up, before they're killed
+var HangupTimeout = 5 * time.Second
+
+// PTYRunner runs commands in a pseudo-terminal, so jobs can host
+// interactive sessions. Output is sent as it is read, rather than by line.
+type PTYRunner struct {
+	// Stdin is written to the terminal. An empty message sends EOF.
+	Stdin <-chan []byte
+	// WindowSizes resize the terminal
+	WindowSizes <-chan WindowSize
+	// Size is the initial size of the terminal
+	Size WindowSize
+	// Dir is the working directory of commands
+	Dir string
+}
+
+// NewPTYRunner creates a PTYRunner for the stdin, window sizes and
+// workspace of conn
+func NewPTYRunner(conn AgentConn) *PTYRunner {
+	return &PTYRunner{
+		Stdin:       conn.Stdin(),
+		WindowSizes: conn.WindowSizes(),
+		Size:        DefaultWindowSize,
+		Dir:         conn.Workspace(),
+	}
+}
+
+// Run runs a command in a pseudo-terminal, forwarding stdin, window sizes
+// and signals until the command exits. Once sigCh closes, as it does when
+// the connection shuts down, the terminal hangs up.
+func (r *PTYRunner) Run(cmd string, outCh chan<- string, sigCh <-chan os.Signal) error {
+	outCh <- "Running " + cmd + "\r\n"
+
+	size := r.Size
+	if size.Rows == 0 || size.Cols == 0 {
+		size = DefaultWindowSize
+	}
+
+	ec := shellCommand(cmd)
+	ec.Dir = r.Dir
+	ptmx, err := pty.StartWithSize(ec, &pty.Winsize{Rows: size.Rows, Cols: size.Cols})
+	if err != nil {
+		return err
+	}
+	defer ptmx.Close()
+
+	doneCh := make(chan struct{})
+	defer close(doneCh)
+	go func() {
+		stdin, sizes := r.Stdin, r.WindowSizes
+		var killCh <-chan time.Time
+		for {
+			select {
+			case data, ok := <-stdin:
+				if !ok {
+					stdin = nil
+					continue
+				}
+				if len(data) == 0 {
+					// The terminal's EOF character
+					data = []byte{4}
+				}
+				ptmx.Write(data)
+			case size, ok := <-sizes:
+				if !ok {
+					sizes = nil
+					continue
+				}
+				pty.Setsize(ptmx, &pty.Winsize{Rows: size.Rows, Cols: size.Cols})
+			case sig, ok := <-sigCh:
+				if !ok {
+					sigCh = nil
+					ec.Process.Signal(syscall.SIGHUP)
+					killCh = time.After(HangupTimeout)
+					continue
+				}
+				ec.Process.Signal(sig)
+			case <-killCh:
+				ec.Process.Kill()
+			case <-doneCh:
+				return
+			}
+		}
+	}()
+
+	// Reading fails once the command exits and the terminal closes
+	buf := make([]byte, 4096)
+	for {
+		n, err := ptmx.Read(buf)
+		if n > 0 {
+			outCh <- string(buf[:n])
+		}
+		if err != nil {
+			break
+		}
+	}
+
+	if status := exitStatus(ec.Wait()); status != 0 {
+		return fmt.Errorf("run failed with exit code: %v", status)
+	}
+	return nil
+}
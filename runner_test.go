@@ -1,9 +1,13 @@
 package quantum
 
 import (
+	"io/ioutil"
 	"log"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestRunner(t *testing.T) {
@@ -39,6 +43,26 @@ func TestRunnerErr(t *testing.T) {
 	}
 }
 
+func TestRunnerDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "runner")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	runner := &BasicRunner{Dir: dir}
+
+	outCh := make(chan string, 2)
+	sigCh := make(chan os.Signal, 1)
+	if err := runner.Run("touch created", outCh, sigCh); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "created")); err != nil {
+		t.Fatalf("expected command to run in dir: %s", err)
+	}
+}
+
 func TestRunnerCancel(t *testing.T) {
 	runner := NewBasicRunner()
 
@@ -56,3 +80,105 @@ func TestRunnerCancel(t *testing.T) {
 		t.Fatal("runner did not exit with error")
 	}
 }
+
+func TestPTYRunner(t *testing.T) {
+	stdinCh := make(chan []byte, 1)
+	runner := &PTYRunner{Stdin: stdinCh}
+
+	outCh := make(chan string, 1)
+	sigCh := make(chan os.Signal, 1)
+	outputCh := make(chan string)
+	go func() {
+		var output string
+		for out := range outCh {
+			output += out
+		}
+		outputCh <- output
+	}()
+
+	stdinCh <- []byte("world\n")
+	if err := runner.Run("[ -t 0 ] && read line && echo got $line", outCh, sigCh); err != nil {
+		t.Fatal(err)
+	}
+	close(outCh)
+
+	if output := <-outputCh; !strings.Contains(output, "got world") {
+		t.Fatalf("expected got world, got %q", output)
+	}
+}
+
+func TestPTYRunnerHangup(t *testing.T) {
+	stdinCh := make(chan []byte)
+	sizeCh := make(chan WindowSize)
+	runner := &PTYRunner{Stdin: stdinCh, WindowSizes: sizeCh}
+
+	outCh := make(chan string, 1)
+	sigCh := make(chan os.Signal)
+	go func() {
+		for range outCh {
+		}
+	}()
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- runner.Run("sleep 10", outCh, sigCh)
+	}()
+
+	// Commands keep running without stdin or window sizes
+	close(stdinCh)
+	close(sizeCh)
+	select {
+	case err := <-errCh:
+		t.Fatalf("expected command to keep running, got %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// The connection shut down
+	close(sigCh)
+	select {
+	case err := <-errCh:
+		if err == nil {
+			t.Fatal("expected hung up command to fail")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected command to exit once hung up")
+	}
+}
+
+func TestRunnerStdin(t *testing.T) {
+	stdin := make(chan []byte, 2)
+	runner := &BasicRunner{Stdin: stdin}
+
+	outCh := make(chan string, 10)
+	sigCh := make(chan os.Signal, 1)
+
+	// Commands not reading stdin exit without waiting for it
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- runner.Run("true", outCh, sigCh)
+	}()
+	select {
+	case err := <-errCh:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("runner waited for stdin")
+	}
+
+	// Stdin is left to the next command
+	stdin <- []byte("hello\n")
+	stdin <- []byte{}
+	if err := runner.Run("cat", outCh, sigCh); err != nil {
+		t.Fatal(err)
+	}
+
+	close(outCh)
+	var out []string
+	for o := range outCh {
+		out = append(out, o)
+	}
+	if !strings.Contains(strings.Join(out, ""), "Running cat\nhello\n") {
+		t.Fatalf("expected stdin to be read by cat, got %q", out)
+	}
+}
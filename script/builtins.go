@@ -0,0 +1,110 @@
+package script
+
+import (
+	"os"
+
+	"github.com/doubledutch/quantum"
+	"go.starlark.net/lib/json"
+	"go.starlark.net/starlark"
+)
+
+// builtins binds the agent to a script's predeclared functions
+type builtins struct {
+	job    *Job
+	runner quantum.Runner
+	ui     quantum.UI
+	outCh  chan<- string
+	sigCh  <-chan os.Signal
+}
+
+func (b *builtins) predeclared() starlark.StringDict {
+	return starlark.StringDict{
+		"run":        starlark.NewBuiltin("run", b.run),
+		"log":        starlark.NewBuiltin("log", b.log),
+		"client":     starlark.NewBuiltin("client", b.client),
+		"both":       starlark.NewBuiltin("both", b.both),
+		"data":       starlark.NewBuiltin("data", b.data),
+		"set_result": starlark.NewBuiltin("set_result", b.setResult),
+		"json":       json.Module,
+	}
+}
+
+// run(cmd, check=True) runs cmd. If check is True, a failing command fails
+// the script, otherwise run returns whether the command succeeded.
+func (b *builtins) run(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var cmd string
+	check := true
+	if err := starlark.UnpackArgs(fn.Name(), args, kwargs, "cmd", &cmd, "check?", &check); err != nil {
+		return nil, err
+	}
+
+	if err := b.runner.Run(cmd, b.outCh, b.sigCh); err != nil {
+		if check {
+			return nil, err
+		}
+		return starlark.False, nil
+	}
+
+	return starlark.True, nil
+}
+
+func (b *builtins) log(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var msg string
+	if err := starlark.UnpackPositionalArgs(fn.Name(), args, kwargs, 1, &msg); err != nil {
+		return nil, err
+	}
+
+	b.ui.Infof("%s", msg)
+	return starlark.None, nil
+}
+
+func (b *builtins) client(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var msg string
+	if err := starlark.UnpackPositionalArgs(fn.Name(), args, kwargs, 1, &msg); err != nil {
+		return nil, err
+	}
+
+	b.ui.Client(msg)
+	return starlark.None, nil
+}
+
+func (b *builtins) both(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var msg string
+	if err := starlark.UnpackPositionalArgs(fn.Name(), args, kwargs, 1, &msg); err != nil {
+		return nil, err
+	}
+
+	b.ui.Both(msg)
+	return starlark.None, nil
+}
+
+func (b *builtins) data(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	if err := starlark.UnpackPositionalArgs(fn.Name(), args, kwargs, 0); err != nil {
+		return nil, err
+	}
+
+	return starlark.String(b.job.data), nil
+}
+
+// set_result(value) sets the result of the job. Strings are stored as is,
+// other values are encoded as JSON.
+func (b *builtins) setResult(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var value starlark.Value
+	if err := starlark.UnpackPositionalArgs(fn.Name(), args, kwargs, 1, &value); err != nil {
+		return nil, err
+	}
+
+	if s, ok := value.(starlark.String); ok {
+		b.job.result = string(s)
+		return starlark.None, nil
+	}
+
+	encode := json.Module.Members["encode"]
+	encoded, err := starlark.Call(thread, encode, starlark.Tuple{value}, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	b.job.result = string(encoded.(starlark.String))
+	return starlark.None, nil
+}
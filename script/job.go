@@ -0,0 +1,222 @@
+package script
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/doubledutch/mux"
+	"github.com/doubledutch/quantum"
+	"go.starlark.net/starlark"
+)
+
+const (
+	// Ext is the file extension of scripts loaded by LoadDir
+	Ext = ".star"
+
+	// DefaultMaxSteps is the default execution step limit of a script
+	DefaultMaxSteps = 10000000
+)
+
+var (
+	// ErrInvalidType = a script job requires a type
+	ErrInvalidType = errors.New("Invalid Script Type")
+)
+
+// Job is a quantum.Job that executes a sandboxed Starlark script.
+//
+// Scripts only have access to the builtins bound by Job:
+//
+//	run(cmd, check=True)  runs cmd with the Runner, streaming output to the client
+//	log(msg)              logs msg on the agent
+//	client(msg)           logs msg to the client
+//	both(msg)             logs msg to the agent and the client
+//	data()                returns the request data as a string
+//	set_result(value)     sets the result of the job
+//	json                  the Starlark json module
+//
+// Registries run a copy of the Job for each request, from Job.NewJob.
+type Job struct {
+	typ      string
+	filename string
+	prog     *starlark.Program
+
+	// Runner runs commands for run(). Defaults to a quantum.BasicRunner
+	// in the workspace of the job.
+	Runner quantum.Runner
+	// MaxSteps limits the number of steps a script may execute
+	MaxSteps uint64
+
+	data   []byte
+	result string
+}
+
+// NewJob creates a new Job of type t from the script src.
+// filename is used for error messages. The script is compiled, but not
+// executed, so syntax errors are reported here.
+func NewJob(t, filename string, src []byte) (*Job, error) {
+	if t == "" {
+		return nil, ErrInvalidType
+	}
+
+	_, prog, err := starlark.SourceProgram(filename, src, isPredeclared)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Job{
+		typ:      t,
+		filename: filename,
+		prog:     prog,
+		MaxSteps: DefaultMaxSteps,
+	}, nil
+}
+
+// LoadJob creates a new Job from the script at path. The type of the job
+// is the name of the file without its extension.
+func LoadJob(path string) (*Job, error) {
+	src, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	base := filepath.Base(path)
+	return NewJob(strings.TrimSuffix(base, filepath.Ext(base)), path, src)
+}
+
+// LoadDir adds a Job to reg for every script in dir
+func LoadDir(dir string, reg quantum.Registry) error {
+	paths, err := filepath.Glob(filepath.Join(dir, "*"+Ext))
+	if err != nil {
+		return err
+	}
+
+	for _, path := range paths {
+		job, err := LoadJob(path)
+		if err != nil {
+			return err
+		}
+		reg.Add(job)
+	}
+
+	return nil
+}
+
+// Type returns the type of the script job
+func (j *Job) Type() string {
+	return j.typ
+}
+
+// NewJob returns a copy of the Job sharing its compiled script, holding
+// the data and result of a single request
+func (j *Job) NewJob() quantum.Job {
+	return &Job{
+		typ:      j.typ,
+		filename: j.filename,
+		prog:     j.prog,
+		Runner:   j.Runner,
+		MaxSteps: j.MaxSteps,
+	}
+}
+
+// Configure stores the request data for the script
+func (j *Job) Configure(p []byte) error {
+	j.data = p
+	j.result = ""
+	return nil
+}
+
+// Result returns the value set by set_result during the last Run
+func (j *Job) Result() string {
+	return j.result
+}
+
+// Run executes the script, sending logs to conn
+func (j *Job) Run(conn quantum.AgentConn) error {
+	outCh := conn.Logs()
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		for output := range outCh {
+			conn.Send(mux.LogType, output)
+		}
+		wg.Done()
+	}()
+
+	err := j.exec(conn)
+
+	close(outCh)
+	wg.Wait()
+
+	return err
+}
+
+func (j *Job) exec(conn quantum.AgentConn) error {
+	thread := &starlark.Thread{
+		Name:  j.typ,
+		Print: func(_ *starlark.Thread, msg string) { conn.Logs() <- msg },
+	}
+	if j.MaxSteps > 0 {
+		thread.SetMaxExecutionSteps(j.MaxSteps)
+	}
+
+	// Signals cancel the script, and the command being run if any
+	sigCh := make(chan os.Signal, 1)
+	exitCh := make(chan struct{})
+	var signaled bool
+	var mu sync.Mutex
+	go func() {
+		for {
+			select {
+			case sig, ok := <-conn.Signals():
+				if !ok {
+					return
+				}
+				mu.Lock()
+				signaled = true
+				mu.Unlock()
+				thread.Cancel("signal received")
+				select {
+				case sigCh <- sig:
+				default:
+				}
+			case <-exitCh:
+				return
+			}
+		}
+	}()
+
+	runner := j.Runner
+	if runner == nil {
+		runner = &quantum.BasicRunner{Dir: conn.Workspace()}
+	}
+
+	b := &builtins{
+		job:    j,
+		runner: runner,
+		ui:     quantum.NewUI(conn),
+		outCh:  conn.Logs(),
+		sigCh:  sigCh,
+	}
+	_, err := j.prog.Init(thread, b.predeclared())
+	close(exitCh)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if signaled {
+		return quantum.ErrSigReceived
+	}
+	return err
+}
+
+func isPredeclared(name string) bool {
+	switch name {
+	case "run", "log", "client", "both", "data", "set_result", "json":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,108 @@
+package script
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/doubledutch/quantum/internal/quantumtest"
+)
+
+func TestNewJobSyntaxErr(t *testing.T) {
+	if _, err := NewJob("bad", "bad.star", []byte("def (")); err == nil {
+		t.Fatal("expected syntax error")
+	}
+}
+
+func TestNewJobInvalidType(t *testing.T) {
+	if _, err := NewJob("", "empty.star", []byte("")); err != ErrInvalidType {
+		t.Fatal("expected invalid type")
+	}
+}
+
+func TestJobRun(t *testing.T) {
+	src := `
+request = json.decode(data())
+client("hello " + request["name"])
+run("echo from runner")
+set_result({"ok": True})
+`
+	job, err := NewJob("greet", "greet.star", []byte(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := job.Configure([]byte(`{"name": "world"}`)); err != nil {
+		t.Fatal(err)
+	}
+
+	conn := quantumtest.NewAgentConn()
+	if err := job.Run(conn); err != nil {
+		t.Fatal(err)
+	}
+
+	logs := strings.Join(conn.Sent(), "")
+	if !strings.Contains(logs, "hello world") {
+		t.Fatalf("missing client log: %q", logs)
+	}
+	if !strings.Contains(logs, "from runner") {
+		t.Fatalf("missing runner output: %q", logs)
+	}
+	if job.Result() != `{"ok":true}` {
+		t.Fatalf("wrong result: %s", job.Result())
+	}
+}
+
+func TestJobNewJob(t *testing.T) {
+	job, err := NewJob("echo", "echo.star", []byte(`set_result(data())`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	job.MaxSteps = 1000
+
+	first := job.NewJob().(*Job)
+	second := job.NewJob().(*Job)
+	if first.MaxSteps != 1000 {
+		t.Fatalf("expected MaxSteps to be copied, got %d", first.MaxSteps)
+	}
+
+	first.Configure([]byte("first"))
+	second.Configure([]byte("second"))
+	if err := first.Run(quantumtest.NewAgentConn()); err != nil {
+		t.Fatal(err)
+	}
+	if err := second.Run(quantumtest.NewAgentConn()); err != nil {
+		t.Fatal(err)
+	}
+
+	if first.Result() != "first" || second.Result() != "second" {
+		t.Fatalf("expected separate results, got %q and %q", first.Result(), second.Result())
+	}
+	if job.Result() != "" {
+		t.Fatalf("expected job to be unchanged, got %q", job.Result())
+	}
+}
+
+func TestJobRunErr(t *testing.T) {
+	job, err := NewJob("fail", "fail.star", []byte(`run("exit 3")`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := job.Run(quantumtest.NewAgentConn()); err == nil {
+		t.Fatal("expected run error")
+	}
+}
+
+func TestJobRunUnchecked(t *testing.T) {
+	job, err := NewJob("unchecked", "unchecked.star", []byte(`set_result(str(run("exit 3", check=False)))`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := job.Run(quantumtest.NewAgentConn()); err != nil {
+		t.Fatal(err)
+	}
+	if job.Result() != "False" {
+		t.Fatalf("wrong result: %s", job.Result())
+	}
+}
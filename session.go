@@ -0,0 +1,180 @@
+package quantum
+
+import (
+	"bytes"
+	"os"
+	"sync"
+	"syscall"
+
+	"github.com/doubledutch/mux"
+)
+
+const (
+	// EnvelopeType is a mux type for the messages of a stream within a
+	// session. Sessions run many jobs concurrently over one connection,
+	// each job in its own stream.
+	EnvelopeType = uint8(72)
+	// StreamDoneType is an envelope type completing a stream, holding
+	// a StreamResult
+	StreamDoneType = uint8(73)
+
+	// DefaultStreamBacklog is the number of envelopes queued for a stream
+	// before the session waits for the stream to catch up
+	DefaultStreamBacklog = 1024
+)
+
+// Envelope is a message of Type sent within a stream. Data is the message,
+// encoded by the Pool of the connection.
+type Envelope struct {
+	Stream uint64
+	Type   uint8
+	Data   []byte
+}
+
+// NewEnvelope encodes v as a message of type t within stream
+func NewEnvelope(pool mux.Pool, stream uint64, t uint8, v interface{}) (Envelope, error) {
+	// Signals are sent by number, like mux does
+	if sig, ok := v.(syscall.Signal); ok {
+		v = int(sig)
+	}
+
+	var buf bytes.Buffer
+	if err := pool.NewEncoder(&buf).Encode(v); err != nil {
+		return Envelope{}, err
+	}
+
+	return Envelope{
+		Stream: stream,
+		Type:   t,
+		Data:   buf.Bytes(),
+	}, nil
+}
+
+// Decode decodes the message of the envelope into v
+func (e Envelope) Decode(pool mux.Pool, v interface{}) error {
+	return pool.NewDecoder(bytes.NewReader(e.Data)).Decode(v)
+}
+
+// DecodeSignal decodes the signal of a SignalType envelope
+func (e Envelope) DecodeSignal(pool mux.Pool) (os.Signal, error) {
+	var sig int
+	if err := e.Decode(pool, &sig); err != nil {
+		return nil, err
+	}
+	return syscall.Signal(sig), nil
+}
+
+// StreamResult is the result of the job of a stream. Error is set when
+// the job failed.
+type StreamResult struct {
+	Failed bool
+	Error  Error
+}
+
+// NewStreamResult returns the StreamResult of a job returning err
+func NewStreamResult(err error) StreamResult {
+	if err == nil {
+		return StreamResult{}
+	}
+	return StreamResult{Failed: true, Error: *ToError(err)}
+}
+
+// Err returns the error of the job, if it failed
+func (r StreamResult) Err() error {
+	if !r.Failed {
+		return nil
+	}
+	return &r.Error
+}
+
+// EnvelopeQueue queues the envelopes of a stream until they're delivered.
+// Once max envelopes are queued, pushing waits for the stream to catch up,
+// so slow streams apply backpressure instead of losing messages. Signals
+// and StreamDoneType envelopes are always queued, a stream can't stop
+// its own job from being signalled or completed.
+type EnvelopeQueue struct {
+	mu        sync.Mutex
+	envelopes []Envelope
+	max       int
+	closed    bool
+	ready     chan struct{}
+	space     chan struct{}
+}
+
+// NewEnvelopeQueue returns a new EnvelopeQueue holding up to max envelopes
+func NewEnvelopeQueue(max int) *EnvelopeQueue {
+	return &EnvelopeQueue{
+		max:   max,
+		ready: make(chan struct{}, 1),
+		space: make(chan struct{}, 1),
+	}
+}
+
+// Push queues envelope, waiting while the queue is full. Push returns
+// false without queueing envelope once the queue or cancel is closed.
+func (q *EnvelopeQueue) Push(envelope Envelope, cancel <-chan struct{}) bool {
+	for {
+		q.mu.Lock()
+		if q.closed {
+			q.mu.Unlock()
+			return false
+		}
+		if len(q.envelopes) < q.max || isControl(envelope) {
+			q.envelopes = append(q.envelopes, envelope)
+			wake(q.ready)
+			q.mu.Unlock()
+			return true
+		}
+		q.mu.Unlock()
+
+		select {
+		case <-q.space:
+		case <-cancel:
+			return false
+		}
+	}
+}
+
+// Pop waits for the next envelope, returning false once the queue is
+// closed and empty
+func (q *EnvelopeQueue) Pop() (Envelope, bool) {
+	for {
+		q.mu.Lock()
+		if len(q.envelopes) > 0 {
+			envelope := q.envelopes[0]
+			q.envelopes[0] = Envelope{}
+			q.envelopes = q.envelopes[1:]
+			wake(q.space)
+			q.mu.Unlock()
+			return envelope, true
+		}
+		closed := q.closed
+		q.mu.Unlock()
+
+		if closed {
+			return Envelope{}, false
+		}
+		<-q.ready
+	}
+}
+
+// Close closes the queue. Queued envelopes are still popped.
+func (q *EnvelopeQueue) Close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.closed = true
+	wake(q.ready)
+	wake(q.space)
+}
+
+// isControl returns true for envelopes queued regardless of the backlog
+func isControl(envelope Envelope) bool {
+	return envelope.Type == mux.SignalType || envelope.Type == StreamDoneType
+}
+
+func wake(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,94 @@
+package quantum
+
+import (
+	"testing"
+	"time"
+
+	"github.com/doubledutch/mux"
+)
+
+func TestEnvelopeQueue(t *testing.T) {
+	q := NewEnvelopeQueue(2)
+	for i := uint64(1); i <= 2; i++ {
+		if !q.Push(Envelope{Stream: i}, nil) {
+			t.Fatalf("expected envelope %d to be queued", i)
+		}
+	}
+
+	// Signals and results are queued even when the queue is full
+	if !q.Push(Envelope{Stream: 3, Type: mux.SignalType}, nil) {
+		t.Fatal("expected signal to be queued")
+	}
+	if !q.Push(Envelope{Stream: 4, Type: StreamDoneType}, nil) {
+		t.Fatal("expected result to be queued")
+	}
+
+	cancel := make(chan struct{})
+	close(cancel)
+	if q.Push(Envelope{Stream: 5}, cancel) {
+		t.Fatal("expected cancelled push not to queue envelope")
+	}
+
+	q.Close()
+	if q.Push(Envelope{Stream: 6, Type: StreamDoneType}, nil) {
+		t.Fatal("expected closed queue not to queue envelope")
+	}
+
+	for i := uint64(1); i <= 4; i++ {
+		envelope, ok := q.Pop()
+		if !ok || envelope.Stream != i {
+			t.Fatalf("expected envelope %d, got %d, %t", i, envelope.Stream, ok)
+		}
+	}
+	if _, ok := q.Pop(); ok {
+		t.Fatal("expected closed queue to be empty")
+	}
+}
+
+func TestEnvelopeQueueWaits(t *testing.T) {
+	q := NewEnvelopeQueue(DefaultStreamBacklog)
+	popped := make(chan Envelope)
+	go func() {
+		envelope, _ := q.Pop()
+		popped <- envelope
+	}()
+
+	q.Push(Envelope{Stream: 1}, nil)
+	if envelope := <-popped; envelope.Stream != 1 {
+		t.Fatalf("expected envelope 1, got %d", envelope.Stream)
+	}
+}
+
+func TestEnvelopeQueueBackpressure(t *testing.T) {
+	q := NewEnvelopeQueue(1)
+	q.Push(Envelope{Stream: 1}, nil)
+
+	pushed := make(chan bool)
+	go func() {
+		pushed <- q.Push(Envelope{Stream: 2}, nil)
+	}()
+
+	select {
+	case <-pushed:
+		t.Fatal("expected push to wait for a full queue")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	q.Pop()
+	if !<-pushed {
+		t.Fatal("expected push to queue envelope once popped")
+	}
+	if envelope, _ := q.Pop(); envelope.Stream != 2 {
+		t.Fatalf("expected envelope 2, got %d", envelope.Stream)
+	}
+
+	// Closing the queue stops a waiting push
+	q.Push(Envelope{Stream: 3}, nil)
+	go func() {
+		pushed <- q.Push(Envelope{Stream: 4}, nil)
+	}()
+	q.Close()
+	if <-pushed {
+		t.Fatal("expected closed queue not to queue envelope")
+	}
+}
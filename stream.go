@@ -0,0 +1,72 @@
+package quantum
+
+import (
+	"io"
+)
+
+const (
+	// StdinType is a mux type for stdin sent from clients to agents.
+	// An empty message is the end of stdin.
+	StdinType = uint8(69)
+	// WindowSizeType is a mux type for terminal window size changes
+	WindowSizeType = uint8(70)
+)
+
+// WindowSize is the size of a client's terminal
+type WindowSize struct {
+	Rows uint16
+	Cols uint16
+}
+
+// StdinReader reads the stdin received from a client. Read returns io.EOF
+// once the client sends an empty message, or ch closes.
+type StdinReader struct {
+	ch  <-chan []byte
+	buf []byte
+	eof bool
+}
+
+// NewStdinReader creates a StdinReader reading from ch
+func NewStdinReader(ch <-chan []byte) *StdinReader {
+	return &StdinReader{ch: ch}
+}
+
+func (r *StdinReader) Read(p []byte) (int, error) {
+	if len(r.buf) == 0 {
+		if r.eof {
+			return 0, io.EOF
+		}
+
+		data, ok := <-r.ch
+		if !ok || len(data) == 0 {
+			r.eof = true
+			return 0, io.EOF
+		}
+		r.buf = data
+	}
+
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+// CopyStdin sends the contents of r to ch, followed by an empty message
+// marking the end of stdin
+func CopyStdin(ch chan<- []byte, r io.Reader) error {
+	buf := make([]byte, 4096)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			data := make([]byte, n)
+			copy(data, buf[:n])
+			ch <- data
+		}
+
+		if err == io.EOF {
+			ch <- []byte{}
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package quantum
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestStdin(t *testing.T) {
+	ch := make(chan []byte, 10)
+	input := strings.Repeat("quantum ", 1000)
+	if err := CopyStdin(ch, strings.NewReader(input)); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := ioutil.ReadAll(NewStdinReader(ch))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, []byte(input)) {
+		t.Fatalf("expected %d bytes, got %d", len(input), len(b))
+	}
+}
+
+func TestStdinClosed(t *testing.T) {
+	ch := make(chan []byte, 1)
+	ch <- []byte("partial")
+	close(ch)
+
+	b, err := ioutil.ReadAll(NewStdinReader(ch))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "partial" {
+		t.Fatalf("expected partial, got %s", b)
+	}
+}
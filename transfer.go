@@ -0,0 +1,263 @@
+package quantum
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/doubledutch/mux"
+)
+
+const (
+	// FileChunkType is a mux type for chunks of files, sent by clients to
+	// upload files and by jobs to download files
+	FileChunkType = uint8(71)
+
+	// DefaultChunkSize is the size of the chunks sent by SendFile
+	DefaultChunkSize = 64 * 1024
+)
+
+var (
+	// ErrChecksumMismatch = a received file doesn't match its checksum
+	ErrChecksumMismatch = errors.New("File Checksum Mismatch")
+	// ErrTransferIncomplete = chunks stopped before the end of a file
+	ErrTransferIncomplete = errors.New("File Transfer Incomplete")
+	// ErrInvalidOffset = a chunk starts past the end of the received file
+	ErrInvalidOffset = errors.New("Invalid File Offset")
+	// ErrUnexpectedFile = a chunk belongs to another file
+	ErrUnexpectedFile = errors.New("Unexpected File")
+	// ErrFileChanged = a file changed size while being sent
+	ErrFileChanged = errors.New("File Changed During Transfer")
+)
+
+// FileChunk is a chunk of a file starting at Offset. The last chunk of a
+// file sets EOF, with Checksum holding the hex sha256 of the whole file.
+//
+// Chunks setting Resume query the offset to resume sending Name from, see
+// ResumeFile. The receiver answers with a chunk setting Resume and Offset.
+type FileChunk struct {
+	Name     string
+	Offset   int64
+	Data     []byte
+	EOF      bool
+	Checksum string
+	Resume   bool
+}
+
+// ConnSender returns a func sending chunks over conn, for SendFile
+func ConnSender(conn mux.Conn) func(FileChunk) error {
+	return func(chunk FileChunk) error {
+		return conn.Send(FileChunkType, chunk)
+	}
+}
+
+// ChanSender returns a func sending chunks to ch, for SendFile
+func ChanSender(ch chan<- FileChunk) func(FileChunk) error {
+	return func(chunk FileChunk) error {
+		ch <- chunk
+		return nil
+	}
+}
+
+// SendFile sends r as name using send, starting at offset to resume a
+// previous transfer.
+func SendFile(send func(FileChunk) error, name string, r io.ReadSeeker, offset int64) error {
+	// The checksum covers the whole file, even when resuming
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	h := sha256.New()
+	size, err := io.Copy(h, r)
+	if err != nil {
+		return err
+	}
+	checksum := hex.EncodeToString(h.Sum(nil))
+
+	if offset > size {
+		return ErrInvalidOffset
+	}
+	if _, err := r.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	// Only what was hashed is sent, should the file grow
+	lr := io.LimitReader(r, size-offset)
+
+	buf := make([]byte, DefaultChunkSize)
+	for {
+		n, err := io.ReadFull(lr, buf)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return err
+		}
+
+		chunk := FileChunk{
+			Name:   name,
+			Offset: offset,
+			Data:   append([]byte(nil), buf[:n]...),
+		}
+		offset += int64(n)
+
+		if offset == size {
+			chunk.EOF = true
+			chunk.Checksum = checksum
+			return send(chunk)
+		}
+		if n == 0 {
+			// The file shrank since it was hashed
+			return ErrFileChanged
+		}
+
+		if err := send(chunk); err != nil {
+			return err
+		}
+	}
+}
+
+// ResumeFile sends r as name using send, resuming from the offset the
+// receiver replies with on replies. Chunks on replies other than the reply
+// are dropped.
+func ResumeFile(send func(FileChunk) error, replies <-chan FileChunk, name string, r io.ReadSeeker) error {
+	if err := send(FileChunk{Name: name, Resume: true}); err != nil {
+		return err
+	}
+
+	for chunk := range replies {
+		if chunk.Resume && chunk.Name == name {
+			return SendFile(send, name, r, chunk.Offset)
+		}
+	}
+	return ErrTransferIncomplete
+}
+
+// ReceiveFile receives a file from ch into dir, returning its path. Chunks
+// are written at their offset, so a transfer resumed from ResumeOffset
+// completes the partial file. Resume queries are answered using reply, and
+// ignored when reply is nil. The file is removed if its checksum doesn't
+// match, and kept for resuming if ch closes early.
+func ReceiveFile(ch <-chan FileChunk, dir string, reply func(FileChunk) error) (string, error) {
+	var f *os.File
+	var name, path string
+	defer func() {
+		if f != nil {
+			f.Close()
+		}
+	}()
+
+	for {
+		chunk, ok := <-ch
+		if !ok {
+			return path, ErrTransferIncomplete
+		}
+
+		if chunk.Resume {
+			if f != nil && chunk.Name != name {
+				return path, ErrUnexpectedFile
+			}
+			if reply != nil {
+				offset := ResumeOffset(dir, chunk.Name)
+				if err := reply(FileChunk{Name: chunk.Name, Offset: offset, Resume: true}); err != nil {
+					return path, err
+				}
+			}
+			continue
+		}
+
+		if f == nil {
+			name = chunk.Name
+			path = filePath(dir, name)
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				return path, err
+			}
+
+			var err error
+			if f, err = os.OpenFile(path, os.O_CREATE|os.O_WRONLY, 0644); err != nil {
+				return path, err
+			}
+		} else if chunk.Name != name {
+			return path, ErrUnexpectedFile
+		}
+
+		info, err := f.Stat()
+		if err != nil {
+			return path, err
+		}
+		if chunk.Offset > info.Size() {
+			return path, ErrInvalidOffset
+		}
+
+		if _, err := f.WriteAt(chunk.Data, chunk.Offset); err != nil {
+			return path, err
+		}
+
+		if chunk.EOF {
+			// Drop what a longer, earlier transfer left behind
+			if err := f.Truncate(chunk.Offset + int64(len(chunk.Data))); err != nil {
+				return path, err
+			}
+			return path, verify(f, path, chunk.Checksum)
+		}
+	}
+}
+
+// ResumeOffset returns the offset to resume sending name into dir from
+func ResumeOffset(dir, name string) int64 {
+	info, err := os.Stat(filePath(dir, name))
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}
+
+// filePath returns the path of name within dir, preventing names from
+// escaping dir
+func filePath(dir, name string) string {
+	return filepath.Join(dir, filepath.Clean(string(filepath.Separator)+name))
+}
+
+// verify removes the file at path if it doesn't match checksum
+func verify(f *os.File, path, checksum string) error {
+	rf, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer rf.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, rf); err != nil {
+		return err
+	}
+
+	if hex.EncodeToString(h.Sum(nil)) != checksum {
+		f.Close()
+		os.Remove(path)
+		return ErrChecksumMismatch
+	}
+	return nil
+}
+
+// FileTransfer sends and receives the files of a job. BasicJob puts a
+// FileTransfer in the StateBag as "files".
+type FileTransfer struct {
+	conn AgentConn
+}
+
+// NewFileTransfer creates a FileTransfer for conn
+func NewFileTransfer(conn AgentConn) *FileTransfer {
+	return &FileTransfer{
+		conn: conn,
+	}
+}
+
+// Receive receives the next file uploaded by the client into dir. Uploads
+// only resume partial files kept in dirs outliving the job, since
+// workspaces are removed once jobs complete.
+func (t *FileTransfer) Receive(dir string) (string, error) {
+	return ReceiveFile(t.conn.Files(), dir, ConnSender(t.conn))
+}
+
+// Send sends r to the client as name, starting at offset
+func (t *FileTransfer) Send(name string, r io.ReadSeeker, offset int64) error {
+	return SendFile(ConnSender(t.conn), name, r, offset)
+}
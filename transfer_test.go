@@ -0,0 +1,170 @@
+package quantum
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func testFileData() []byte {
+	return bytes.Repeat([]byte("quantum"), DefaultChunkSize/3)
+}
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "quantum-transfer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
+
+// transfer sends data from offset and receives it into dir
+func transfer(t *testing.T, dir, name string, data []byte, offset int64, corrupt bool) (string, error) {
+	ch := make(chan FileChunk, 100)
+	send := ChanSender(ch)
+	if corrupt {
+		send = func(chunk FileChunk) error {
+			chunk.Checksum = "bad"
+			ch <- chunk
+			return nil
+		}
+	}
+
+	if err := SendFile(send, name, bytes.NewReader(data), offset); err != nil {
+		t.Fatal(err)
+	}
+	close(ch)
+
+	return ReceiveFile(ch, dir, nil)
+}
+
+func TestTransfer(t *testing.T) {
+	dir := tempDir(t)
+	data := testFileData()
+
+	path, err := transfer(t, dir, "build/out.bin", data, 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if path != filepath.Join(dir, "build", "out.bin") {
+		t.Fatalf("unexpected path %s", path)
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, data) {
+		t.Fatal("received file doesn't match")
+	}
+}
+
+func TestTransferResume(t *testing.T) {
+	dir := tempDir(t)
+	data := testFileData()
+
+	// A previous transfer stopped part way
+	half := int64(len(data) / 2)
+	if err := ioutil.WriteFile(filepath.Join(dir, "out.bin"), data[:half], 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ch := make(chan FileChunk, 100)
+	replies := make(chan FileChunk, 1)
+	type received struct {
+		path string
+		err  error
+	}
+	receivedCh := make(chan received, 1)
+	go func() {
+		path, err := ReceiveFile(ch, dir, ChanSender(replies))
+		receivedCh <- received{path, err}
+	}()
+
+	var offsets []int64
+	send := func(chunk FileChunk) error {
+		if !chunk.Resume {
+			offsets = append(offsets, chunk.Offset)
+		}
+		ch <- chunk
+		return nil
+	}
+	if err := ResumeFile(send, replies, "out.bin", bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+	if offsets[0] != half {
+		t.Fatalf("expected to resume from %d, got %d", half, offsets[0])
+	}
+
+	r := <-receivedCh
+	if r.err != nil {
+		t.Fatal(r.err)
+	}
+	path := r.path
+
+	b, _ := ioutil.ReadFile(path)
+	if !bytes.Equal(b, data) {
+		t.Fatal("resumed file doesn't match")
+	}
+}
+
+func TestTransferChecksum(t *testing.T) {
+	dir := tempDir(t)
+
+	path, err := transfer(t, dir, "out.bin", testFileData(), 0, true)
+	if err != ErrChecksumMismatch {
+		t.Fatalf("expected checksum mismatch, got %v", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatal("expected mismatched file to be removed")
+	}
+}
+
+func TestTransferIncomplete(t *testing.T) {
+	ch := make(chan FileChunk, 1)
+	ch <- FileChunk{Name: "../../escape.bin", Data: []byte("partial")}
+	close(ch)
+
+	dir := tempDir(t)
+	path, err := ReceiveFile(ch, dir, nil)
+	if err != ErrTransferIncomplete {
+		t.Fatalf("expected incomplete transfer, got %v", err)
+	}
+	if path != filepath.Join(dir, "escape.bin") {
+		t.Fatalf("expected file within %s, got %s", dir, path)
+	}
+}
+
+// shrinkingReader shrinks to half its data once hashed by SendFile
+type shrinkingReader struct {
+	*bytes.Reader
+	data  []byte
+	seeks int
+}
+
+func (r *shrinkingReader) Seek(offset int64, whence int) (int64, error) {
+	r.seeks++
+	if r.seeks == 2 {
+		r.Reader = bytes.NewReader(r.data[:len(r.data)/2])
+	}
+	return r.Reader.Seek(offset, whence)
+}
+
+func TestTransferFileChanged(t *testing.T) {
+	data := testFileData()
+	r := &shrinkingReader{Reader: bytes.NewReader(data), data: data}
+
+	var chunks int
+	send := func(chunk FileChunk) error {
+		if chunks++; chunks > 10 {
+			t.Fatal("expected sending to stop")
+		}
+		return nil
+	}
+	if err := SendFile(send, "out.bin", r, 0); err != ErrFileChanged {
+		t.Fatalf("expected file changed, got %v", err)
+	}
+}
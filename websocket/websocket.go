@@ -0,0 +1,251 @@
+// Package websocket carries quantum connections over WebSockets, so
+// browsers can attach to agents. Messages keep the framing of the codec
+// negotiated in the handshake, sent as binary frames.
+package websocket
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/doubledutch/lager"
+	"github.com/doubledutch/quantum"
+	"github.com/doubledutch/quantum/client"
+	"golang.org/x/net/websocket"
+)
+
+// DefaultPath is the path agents accept WebSockets on
+const DefaultPath = "/quantum"
+
+var (
+	// ErrListenerClosed = the listener is closed
+	ErrListenerClosed = errors.New("WebSocket Listener Closed")
+	// ErrOriginNotAllowed = a handshake came from another origin than the
+	// agent
+	ErrOriginNotAllowed = errors.New("WebSocket Origin Not Allowed")
+)
+
+// Listener is a net.Listener accepting WebSocket connections from its
+// ServeHTTP, so agents can Accept them like TCP connections
+type Listener struct {
+	// Handshake checks WebSocket handshakes, such as their origin. When
+	// nil, handshakes must come from the host of the request, see
+	// SameOrigin, unless AllowAnyOrigin is set.
+	Handshake func(*websocket.Config, *http.Request) error
+	// AllowAnyOrigin accepts handshakes from any origin when Handshake is
+	// nil, such as from pages served by other hosts
+	AllowAnyOrigin bool
+
+	addr      net.Addr
+	conns     chan net.Conn
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// NewListener creates a Listener for connections to addr
+func NewListener(addr net.Addr) *Listener {
+	return &Listener{
+		addr:   addr,
+		conns:  make(chan net.Conn),
+		closed: make(chan struct{}),
+	}
+}
+
+// ServeHTTP upgrades requests to WebSockets for Accept
+func (l *Listener) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	handshake := l.Handshake
+	if handshake == nil && !l.AllowAnyOrigin {
+		handshake = SameOrigin
+	}
+
+	srv := websocket.Server{
+		Handshake: handshake,
+		Handler:   l.handle,
+	}
+	srv.ServeHTTP(w, r)
+}
+
+// SameOrigin accepts handshakes whose Origin is the host of the request,
+// as sent by Clients and by pages served alongside the agent
+func SameOrigin(config *websocket.Config, r *http.Request) error {
+	origin, err := websocket.Origin(config, r)
+	if err != nil {
+		return err
+	}
+	if origin == nil || origin.Host != r.Host {
+		return ErrOriginNotAllowed
+	}
+
+	config.Origin = origin
+	return nil
+}
+
+// handle passes ws to Accept, holding the request until ws is closed
+func (l *Listener) handle(ws *websocket.Conn) {
+	c := newConn(ws)
+	select {
+	case l.conns <- c:
+		<-c.done
+	case <-l.closed:
+		ws.Close()
+	}
+}
+
+// Accept waits for the next WebSocket connection
+func (l *Listener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.conns:
+		return c, nil
+	case <-l.closed:
+		return nil, ErrListenerClosed
+	}
+}
+
+// Close stops accepting connections
+func (l *Listener) Close() error {
+	l.closeOnce.Do(func() {
+		close(l.closed)
+	})
+	return nil
+}
+
+// Addr returns the address of the listener
+func (l *Listener) Addr() net.Addr {
+	return l.addr
+}
+
+// ListenAndServe accepts WebSockets on path at address for a, until a
+// shuts down
+func ListenAndServe(a quantum.Acceptor, address, path string, lgr lager.Lager) error {
+	ln, err := net.Listen("tcp", address)
+	if err != nil {
+		a.Close()
+		return fmt.Errorf("%w: %s", quantum.ErrListen, err)
+	}
+
+	l := NewListener(ln.Addr())
+	mux := http.NewServeMux()
+	mux.Handle(path, l)
+	srv := &http.Server{Handler: mux}
+	go srv.Serve(ln)
+
+	go func() {
+		<-a.IsShutdown()
+		l.Close()
+		srv.Close()
+	}()
+
+	lgr.Infof("Listening for WebSockets on %s%s", address, path)
+	for {
+		if err := a.Accept(l); err != nil {
+			if err == ErrListenerClosed {
+				a.Close()
+				return nil
+			}
+			lgr.Errorf("Accepter accept err: %s", err)
+		}
+	}
+}
+
+// conn is a WebSocket connection sending binary frames, and closing done
+// once closed
+type conn struct {
+	*websocket.Conn
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func newConn(ws *websocket.Conn) *conn {
+	ws.PayloadType = websocket.BinaryFrame
+	return &conn{
+		Conn: ws,
+		done: make(chan struct{}),
+	}
+}
+
+func (c *conn) Close() error {
+	err := c.Conn.Close()
+	c.closeOnce.Do(func() {
+		close(c.done)
+	})
+	return err
+}
+
+// Client is a quantum.Client dialing agents over WebSockets
+type Client struct {
+	*quantum.ConnConfig
+
+	// Path is the path agents accept WebSockets on, defaulting to
+	// DefaultPath
+	Path string
+	// Origin is the origin of handshakes, defaulting to the agent's URL
+	Origin string
+}
+
+// NewClient returns a new Client
+func NewClient(config *quantum.ConnConfig) *Client {
+	if config == nil {
+		config = quantum.DefaultConnConfig()
+	}
+
+	return &Client{
+		ConnConfig: config,
+		Path:       DefaultPath,
+	}
+}
+
+// Dial connects to the agent at address, which is host:port or a ws://
+// or wss:// URL
+func (c *Client) Dial(address string) (quantum.ClientConn, error) {
+	return c.DialTimeout(address, 0)
+}
+
+// DialTimeout connects to the agent at address, timing out after time
+func (c *Client) DialTimeout(address string, time time.Duration) (quantum.ClientConn, error) {
+	config, err := c.wsConfig(address)
+	if err != nil {
+		return nil, err
+	}
+	config.Dialer = &net.Dialer{Timeout: time}
+
+	ws, err := websocket.DialConfig(config)
+	if err != nil {
+		if dialErr, ok := err.(*websocket.DialError); ok {
+			err = dialErr.Err
+		}
+		if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+			return nil, quantum.WrapError(quantum.CodeTimeout, err)
+		}
+		return nil, err
+	}
+
+	netConn := newConn(ws)
+	cc, err := client.Negotiate(netConn, c.ConnConfig)
+	if err != nil {
+		netConn.Close()
+		return nil, err
+	}
+	return cc, nil
+}
+
+// wsConfig returns the WebSocket config for address
+func (c *Client) wsConfig(address string) (*websocket.Config, error) {
+	url := address
+	if !strings.HasPrefix(url, "ws://") && !strings.HasPrefix(url, "wss://") {
+		path := c.Path
+		if path == "" {
+			path = DefaultPath
+		}
+		url = "ws://" + address + path
+	}
+
+	origin := c.Origin
+	if origin == "" {
+		origin = "http" + strings.TrimPrefix(url, "ws")
+	}
+	return websocket.NewConfig(url, origin)
+}
@@ -0,0 +1,147 @@
+package websocket
+
+import (
+	"errors"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/doubledutch/mux"
+	"github.com/doubledutch/quantum"
+	"github.com/doubledutch/quantum/agent"
+	"golang.org/x/net/websocket"
+)
+
+type testJob struct{}
+
+func (j *testJob) Type() string {
+	return "echo"
+}
+
+func (j *testJob) Configure(p []byte) error {
+	return nil
+}
+
+func (j *testJob) Run(conn quantum.AgentConn) error {
+	conn.Send(mux.LogType, "over websocket")
+	return nil
+}
+
+func TestWebSocket(t *testing.T) {
+	a := agent.New(&agent.Config{Name: "ws-agent"})
+	a.Add(new(testJob))
+
+	l := NewListener(nil)
+	defer l.Close()
+	srv := httptest.NewServer(l)
+	defer srv.Close()
+
+	go func() {
+		for a.Accept(l) == nil {
+		}
+	}()
+
+	c := NewClient(nil)
+	url := "ws" + strings.TrimPrefix(srv.URL, "http")
+	for _, request := range []quantum.Request{
+		quantum.NewRequest("echo", ""),
+		quantum.NewRequest("missing", ""),
+	} {
+		conn, err := c.Dial(url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if conn.Handshake().Agent != "ws-agent" {
+			t.Fatalf("unexpected handshake: %+v", conn.Handshake())
+		}
+
+		logsDone := make(chan []string)
+		go func() {
+			var logs []string
+			for log := range conn.Logs() {
+				logs = append(logs, log)
+			}
+			logsDone <- logs
+		}()
+
+		err = conn.Run(request)
+		logs := <-logsDone
+
+		if request.Type == "missing" {
+			if !errors.Is(err, quantum.ErrJobNotFound) {
+				t.Fatalf("expected job not found, got %v", err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(logs) != 1 || logs[0] != "over websocket" {
+			t.Fatalf("unexpected logs: %v", logs)
+		}
+	}
+}
+
+func TestListenerClose(t *testing.T) {
+	l := NewListener(nil)
+	l.Close()
+	if _, err := l.Accept(); err != ErrListenerClosed {
+		t.Fatalf("expected listener closed, got %v", err)
+	}
+}
+
+func TestListenerOrigin(t *testing.T) {
+	tests := []struct {
+		allowAny bool
+		origin   string
+		ok       bool
+	}{
+		{false, "", true},
+		{false, "http://other.example", false},
+		{true, "http://other.example", true},
+	}
+
+	for _, test := range tests {
+		l := NewListener(nil)
+		l.AllowAnyOrigin = test.allowAny
+		srv := httptest.NewServer(l)
+
+		url := "ws" + strings.TrimPrefix(srv.URL, "http") + DefaultPath
+		origin := test.origin
+		if origin == "" {
+			origin = srv.URL
+		}
+		config, err := websocket.NewConfig(url, origin)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ws, err := websocket.DialConfig(config)
+		if test.ok != (err == nil) {
+			t.Fatalf("origin %s, allow any %t: expected ok %t, got %v", origin, test.allowAny, test.ok, err)
+		}
+		if ws != nil {
+			ws.Close()
+		}
+		l.Close()
+		srv.Close()
+	}
+}
+
+func TestListenAndServeInUse(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	a := agent.New(&agent.Config{Name: "ws-agent"})
+	err = ListenAndServe(a, ln.Addr().String(), DefaultPath, quantum.DefaultConnConfig().Lager)
+	if !errors.Is(err, quantum.ErrListen) {
+		t.Fatalf("expected listen error, got %v", err)
+	}
+	if err == quantum.ErrListen {
+		t.Fatal("expected listen error to wrap its cause")
+	}
+}
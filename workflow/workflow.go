@@ -0,0 +1,295 @@
+// Package workflow runs sub-requests on other agents in dependency order.
+//
+// A job declares the Tasks of a Workflow and calls Run with its AgentConn.
+// Each task is resolved with a ClientResolver and run through a
+// Communicator, its logs prefixed with the name of the task. Signals
+// received by the job are forwarded to every running task, and tasks that
+// haven't started are cancelled.
+package workflow
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/doubledutch/quantum"
+	"github.com/hashicorp/go-multierror"
+)
+
+var (
+	// ErrDuplicateTask = two tasks have the same name
+	ErrDuplicateTask = errors.New("Duplicate Task")
+	// ErrUnknownDependency = a task depends on a task that doesn't exist
+	ErrUnknownDependency = errors.New("Unknown Dependency")
+	// ErrCycle = tasks depend on each other
+	ErrCycle = errors.New("Dependency Cycle")
+	// ErrDependencyFailed = a task was skipped since a dependency failed
+	ErrDependencyFailed = errors.New("Dependency Failed")
+	// ErrCancelled = a task was cancelled before it started
+	ErrCancelled = errors.New("Cancelled")
+)
+
+// Task is a sub-request of a Workflow
+type Task struct {
+	// Name identifies the task, and prefixes its logs
+	Name string
+	// Resolve resolves the agent running the task
+	Resolve quantum.ResolveRequest
+	// Request is run on the resolved agent
+	Request quantum.Request
+	// DependsOn are the names of the tasks that must succeed first
+	DependsOn []string
+}
+
+// Status is the status of a task
+type Status int
+
+const (
+	// Pending tasks are waiting for dependencies
+	Pending Status = iota
+	// Running tasks are running on an agent
+	Running
+	// Succeeded tasks ran successfully
+	Succeeded
+	// Failed tasks failed to resolve or run
+	Failed
+	// Skipped tasks didn't run since a dependency failed
+	Skipped
+	// Cancelled tasks didn't run since the workflow was signalled
+	Cancelled
+)
+
+var statusNames = []string{"pending", "running", "succeeded", "failed", "skipped", "cancelled"}
+
+func (s Status) String() string {
+	if int(s) < len(statusNames) {
+		return statusNames[s]
+	}
+	return "unknown"
+}
+
+// Result is the outcome of a task
+type Result struct {
+	Task   string
+	Status Status
+	Err    error
+}
+
+// Outcome holds the result of each task, in the order of the tasks
+type Outcome []Result
+
+// Err returns the errors of tasks that didn't succeed, or nil
+func (o Outcome) Err() error {
+	var result error
+	for _, r := range o {
+		if r.Status != Succeeded {
+			result = multierror.Append(result, fmt.Errorf("%s: %w", r.Task, r.Err))
+		}
+	}
+	return result
+}
+
+// Workflow runs Tasks in dependency order, concurrently where possible
+type Workflow struct {
+	Tasks    []Task
+	Resolver quantum.ClientResolver
+}
+
+// New creates a Workflow of tasks resolved with resolver
+func New(resolver quantum.ClientResolver, tasks ...Task) *Workflow {
+	return &Workflow{
+		Tasks:    tasks,
+		Resolver: resolver,
+	}
+}
+
+// Validate checks that task names are unique, dependencies exist, and
+// dependencies don't form a cycle
+func (w *Workflow) Validate() error {
+	index := make(map[string]int, len(w.Tasks))
+	for i, task := range w.Tasks {
+		if _, ok := index[task.Name]; ok {
+			return fmt.Errorf("%s: %w", task.Name, ErrDuplicateTask)
+		}
+		index[task.Name] = i
+	}
+
+	for _, task := range w.Tasks {
+		for _, dep := range task.DependsOn {
+			if _, ok := index[dep]; !ok {
+				return fmt.Errorf("%s: %w %s", task.Name, ErrUnknownDependency, dep)
+			}
+		}
+	}
+
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	marks := make([]int, len(w.Tasks))
+	var visit func(i int) error
+	visit = func(i int) error {
+		switch marks[i] {
+		case visiting:
+			return fmt.Errorf("%s: %w", w.Tasks[i].Name, ErrCycle)
+		case visited:
+			return nil
+		}
+
+		marks[i] = visiting
+		for _, dep := range w.Tasks[i].DependsOn {
+			if err := visit(index[dep]); err != nil {
+				return err
+			}
+		}
+		marks[i] = visited
+		return nil
+	}
+
+	for i := range w.Tasks {
+		if err := visit(i); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+type taskDone struct {
+	index int
+	err   error
+}
+
+// Run runs the tasks, relaying their logs to conn. The returned error
+// aggregates the errors of the tasks that didn't succeed.
+func (w *Workflow) Run(conn quantum.AgentConn) (Outcome, error) {
+	if err := w.Validate(); err != nil {
+		return nil, err
+	}
+
+	outcome := make(Outcome, len(w.Tasks))
+	index := make(map[string]int, len(w.Tasks))
+	for i, task := range w.Tasks {
+		outcome[i] = Result{Task: task.Name, Status: Pending}
+		index[task.Name] = i
+	}
+
+	doneCh := make(chan taskDone, len(w.Tasks))
+	sigChs := make(map[int]chan os.Signal)
+	cancelled := false
+
+	// start runs every pending task whose dependencies succeeded, and skips
+	// those with a dependency that didn't
+	start := func() {
+		for changed := true; changed; {
+			changed = false
+			for i, task := range w.Tasks {
+				if outcome[i].Status != Pending {
+					continue
+				}
+
+				if cancelled {
+					outcome[i].Status, outcome[i].Err = Cancelled, ErrCancelled
+					changed = true
+					continue
+				}
+
+				ready := true
+				for _, dep := range task.DependsOn {
+					switch outcome[index[dep]].Status {
+					case Succeeded:
+					case Failed, Skipped, Cancelled:
+						outcome[i].Status = Skipped
+						outcome[i].Err = fmt.Errorf("%w: %s", ErrDependencyFailed, dep)
+						changed = true
+						ready = false
+					default:
+						ready = false
+					}
+					if !ready {
+						break
+					}
+				}
+				if !ready {
+					continue
+				}
+
+				outcome[i].Status = Running
+				sigCh := make(chan os.Signal, 1)
+				sigChs[i] = sigCh
+				go func(i int, task Task) {
+					doneCh <- taskDone{index: i, err: w.runTask(conn, task, sigCh)}
+				}(i, task)
+			}
+		}
+	}
+
+	cancel := func(sig os.Signal) {
+		cancelled = true
+		for _, sigCh := range sigChs {
+			select {
+			case sigCh <- sig:
+			default:
+			}
+		}
+	}
+
+	start()
+	shutdown := conn.IsShutdown()
+	signals := conn.Signals()
+	for len(sigChs) > 0 {
+		select {
+		case done := <-doneCh:
+			delete(sigChs, done.index)
+			if done.err == nil {
+				outcome[done.index].Status = Succeeded
+			} else {
+				outcome[done.index].Status = Failed
+				outcome[done.index].Err = done.err
+			}
+		case sig, ok := <-signals:
+			if !ok {
+				// Closed once the connection shuts down
+				signals = nil
+				break
+			}
+			conn.Lager().Infof("Workflow received signal: %s\n", sig)
+			cancel(sig)
+		case <-shutdown:
+			shutdown = nil
+			cancel(os.Interrupt)
+		}
+		start()
+	}
+
+	return outcome, outcome.Err()
+}
+
+// runTask resolves task and runs it, forwarding signals from sigCh
+func (w *Workflow) runTask(conn quantum.AgentConn, task Task, sigCh chan os.Signal) error {
+	child, err := w.Resolver.Resolve(task.Resolve)
+	if err != nil {
+		return err
+	}
+
+	exitCh := make(chan struct{})
+	comm := quantum.NewPrefixCommunicator(&taskConn{AgentConn: conn, sigCh: sigCh}, child, "["+task.Name+"] ")
+	comm.Communicate(exitCh)
+
+	err = child.Run(task.Request)
+	close(exitCh)
+	comm.Wait()
+
+	return err
+}
+
+// taskConn is the parent of a task, receiving the signals of that task only
+type taskConn struct {
+	quantum.AgentConn
+	sigCh chan os.Signal
+}
+
+func (c *taskConn) Signals() chan os.Signal {
+	return c.sigCh
+}
@@ -0,0 +1,192 @@
+package workflow
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/doubledutch/quantum"
+	"github.com/doubledutch/quantum/internal/quantumtest"
+)
+
+// testResolver resolves conns running requests by type: "fail" fails,
+// "block" blocks until signalled, anything else succeeds. "missing" has
+// no agents.
+type testResolver struct {
+	mu  sync.Mutex
+	ran []string
+}
+
+func (r *testResolver) Resolve(request quantum.ResolveRequest) (quantum.ClientConn, error) {
+	if request.Type == "missing" {
+		return nil, quantum.NoAgentsFromRequest(request)
+	}
+	conn := quantumtest.NewClientConn("", nil)
+	conn.RunFunc = func(request quantum.Request) error {
+		r.record(request.Type)
+		conn.LogCh <- "ran " + request.Type
+
+		switch request.Type {
+		case "fail":
+			return errors.New("failed")
+		case "block":
+			<-conn.SigCh
+			return quantum.ErrSigReceived
+		}
+		return nil
+	}
+	return conn, nil
+}
+
+func (r *testResolver) record(t string) {
+	r.mu.Lock()
+	r.ran = append(r.ran, t)
+	r.mu.Unlock()
+}
+
+func task(name, t string, deps ...string) Task {
+	return Task{
+		Name:      name,
+		Resolve:   quantum.ResolveRequest{Type: t},
+		Request:   quantum.NewRequest(t, ""),
+		DependsOn: deps,
+	}
+}
+
+func TestRun(t *testing.T) {
+	resolver := &testResolver{}
+	w := New(resolver,
+		task("deploy", "deploy", "test-b", "test-c"),
+		task("build", "build"),
+		task("test-b", "test", "build"),
+		task("test-c", "test", "build"),
+	)
+
+	conn := quantumtest.NewAgentConn()
+	outcome, err := w.Run(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, r := range outcome {
+		if r.Status != Succeeded {
+			t.Fatalf("expected %s to succeed, got %s", r.Task, r.Status)
+		}
+	}
+
+	if resolver.ran[0] != "build" || resolver.ran[3] != "deploy" {
+		t.Fatalf("expected build first and deploy last, got %v", resolver.ran)
+	}
+
+	close(conn.LogCh)
+	var logs []string
+	for log := range conn.LogCh {
+		logs = append(logs, log)
+	}
+	if len(logs) != 4 || !strings.Contains(strings.Join(logs, "\n"), "[deploy] ran deploy") {
+		t.Fatalf("expected prefixed logs, got %v", logs)
+	}
+}
+
+func TestRunFailure(t *testing.T) {
+	w := New(&testResolver{},
+		task("build", "fail"),
+		task("deploy", "deploy", "build"),
+		task("lint", "missing"),
+	)
+
+	outcome, err := w.Run(quantumtest.NewAgentConn())
+	if err == nil {
+		t.Fatal("expected err")
+	}
+
+	if outcome[0].Status != Failed || outcome[1].Status != Skipped || outcome[2].Status != Failed {
+		t.Fatalf("expected failed, skipped, failed, got %v", outcome)
+	}
+	if !errors.Is(outcome[1].Err, ErrDependencyFailed) {
+		t.Fatalf("expected dependency failed, got %v", outcome[1].Err)
+	}
+	if !quantum.IsNoAgentsErr(outcome[2].Err) {
+		t.Fatalf("expected no agents, got %v", outcome[2].Err)
+	}
+}
+
+func TestRunCancel(t *testing.T) {
+	resolver := &testResolver{}
+	w := New(resolver,
+		task("a", "block"),
+		task("b", "block"),
+		task("after", "deploy", "a", "b"),
+	)
+
+	conn := quantumtest.NewAgentConn()
+	go func() {
+		// Wait for both tasks to run before signalling
+		for {
+			resolver.mu.Lock()
+			n := len(resolver.ran)
+			resolver.mu.Unlock()
+			if n == 2 {
+				break
+			}
+		}
+		conn.SigCh <- os.Interrupt
+	}()
+
+	outcome, err := w.Run(conn)
+	if err == nil {
+		t.Fatal("expected err")
+	}
+
+	for _, r := range outcome[:2] {
+		if r.Status != Failed || !errors.Is(r.Err, quantum.ErrSigReceived) {
+			t.Fatalf("expected %s to be signalled, got %s: %v", r.Task, r.Status, r.Err)
+		}
+	}
+	if outcome[2].Status != Cancelled {
+		t.Fatalf("expected after to be cancelled, got %s", outcome[2].Status)
+	}
+}
+
+func TestRunSignalsClosed(t *testing.T) {
+	resolver := &testResolver{}
+	w := New(resolver,
+		task("build", "build"),
+		task("test", "test", "build"),
+		task("deploy", "deploy", "test"),
+	)
+
+	// Signals are closed while the connection shuts down
+	conn := quantumtest.NewAgentConn()
+	close(conn.SigCh)
+
+	outcome, err := w.Run(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range outcome {
+		if r.Status != Succeeded {
+			t.Fatalf("expected %s to succeed, got %s", r.Task, r.Status)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		tasks []Task
+		err   error
+	}{
+		{[]Task{task("a", "a"), task("a", "a")}, ErrDuplicateTask},
+		{[]Task{task("a", "a", "b")}, ErrUnknownDependency},
+		{[]Task{task("a", "a", "c"), task("b", "b", "a"), task("c", "c", "b")}, ErrCycle},
+		{[]Task{task("a", "a"), task("b", "b", "a")}, nil},
+	}
+
+	for _, test := range tests {
+		if err := New(nil, test.tasks...).Validate(); !errors.Is(err, test.err) {
+			t.Fatalf("expected %v, got %v", test.err, err)
+		}
+	}
+}
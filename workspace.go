@@ -0,0 +1,216 @@
+package quantum
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-multierror"
+)
+
+const (
+	// DefaultWorkspaceMaxAge is the age after which workspaces are collected
+	DefaultWorkspaceMaxAge = 24 * time.Hour
+	// DefaultWorkspaceMaxSize is the total size of workspaces above which
+	// the oldest are collected
+	DefaultWorkspaceMaxSize = int64(10 << 30)
+	// DefaultWorkspaceCollectInterval is how often agents collect workspaces
+	DefaultWorkspaceCollectInterval = 10 * time.Minute
+)
+
+// Workspaces allocates a unique directory for each job, so concurrent jobs
+// don't share a working directory. Released workspaces are removed, unless
+// the job failed and PreserveOnFailure is set, in which case they're kept
+// for debugging until collected.
+//
+// Active workspaces hold a lock on a ".lock" file next to them, so agents
+// sharing a Root never collect the workspaces of each other's jobs.
+type Workspaces struct {
+	// Root is the directory holding the workspaces
+	Root string
+	// PreserveOnFailure keeps the workspaces of failed jobs
+	PreserveOnFailure bool
+	// MaxAge is the age after which Collect removes workspaces, 0 is unlimited
+	MaxAge time.Duration
+	// MaxSize is the total size above which Collect removes the oldest
+	// workspaces, 0 is unlimited
+	MaxSize int64
+
+	mu sync.Mutex
+	// active maps active workspaces to the func unlocking them
+	active map[string]func()
+}
+
+// NewWorkspaces creates Workspaces in root, defaulting to a directory in
+// os.TempDir()
+func NewWorkspaces(root string) *Workspaces {
+	if root == "" {
+		root = filepath.Join(os.TempDir(), "quantum-workspaces")
+	}
+
+	return &Workspaces{
+		Root:    root,
+		MaxAge:  DefaultWorkspaceMaxAge,
+		MaxSize: DefaultWorkspaceMaxSize,
+		active:  make(map[string]func()),
+	}
+}
+
+// Create creates a workspace for a job of type t, returning its path
+func (w *Workspaces) Create(t string) (string, error) {
+	if err := os.MkdirAll(w.Root, 0755); err != nil {
+		return "", err
+	}
+
+	// The workspace is locked before it's created, so it's never collected
+	for i := 0; i < 10000; i++ {
+		f, err := ioutil.TempFile(w.Root, workspacePrefix(t)+"*"+lockExt)
+		if err != nil {
+			return "", err
+		}
+		dir := strings.TrimSuffix(f.Name(), lockExt)
+
+		unlock, err := lockWorkspace(f)
+		if err != nil {
+			f.Close()
+			os.Remove(f.Name())
+			return "", err
+		}
+
+		if err := os.Mkdir(dir, 0700); err != nil {
+			unlock()
+			if os.IsExist(err) {
+				continue
+			}
+			return "", err
+		}
+
+		w.mu.Lock()
+		if w.active == nil {
+			w.active = make(map[string]func())
+		}
+		w.active[dir] = unlock
+		w.mu.Unlock()
+		return dir, nil
+	}
+	return "", os.ErrExist
+}
+
+// Release releases the workspace at dir once its job completes with err,
+// removing it unless it's preserved.
+func (w *Workspaces) Release(dir string, err error) error {
+	w.mu.Lock()
+	unlock := w.active[dir]
+	delete(w.active, dir)
+	w.mu.Unlock()
+
+	if unlock != nil {
+		unlock()
+	}
+
+	if err != nil && w.PreserveOnFailure {
+		return nil
+	}
+	return os.RemoveAll(dir)
+}
+
+// Collect removes workspaces older than MaxAge, then the oldest workspaces
+// until their total size is within MaxSize. Active workspaces are kept,
+// including those of other agents sharing Root.
+func (w *Workspaces) Collect() error {
+	infos, err := ioutil.ReadDir(w.Root)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	type workspace struct {
+		dir     string
+		modTime time.Time
+		size    int64
+	}
+
+	w.mu.Lock()
+	var workspaces []workspace
+	for _, info := range infos {
+		dir := filepath.Join(w.Root, info.Name())
+		if !info.IsDir() || w.active[dir] != nil || workspaceLocked(dir+lockExt) {
+			continue
+		}
+		workspaces = append(workspaces, workspace{dir: dir, modTime: info.ModTime()})
+	}
+	w.mu.Unlock()
+
+	sort.Slice(workspaces, func(i, j int) bool {
+		return workspaces[i].modTime.Before(workspaces[j].modTime)
+	})
+
+	var result error
+	var total int64
+	kept := workspaces[:0]
+	for _, ws := range workspaces {
+		if w.MaxAge > 0 && time.Since(ws.modTime) > w.MaxAge {
+			if err := removeWorkspace(ws.dir); err != nil {
+				result = multierror.Append(result, err)
+			}
+			continue
+		}
+
+		ws.size = dirSize(ws.dir)
+		total += ws.size
+		kept = append(kept, ws)
+	}
+
+	for _, ws := range kept {
+		if w.MaxSize <= 0 || total <= w.MaxSize {
+			break
+		}
+		if err := removeWorkspace(ws.dir); err != nil {
+			result = multierror.Append(result, err)
+			continue
+		}
+		total -= ws.size
+	}
+	return result
+}
+
+// removeWorkspace removes the workspace at dir, along with the lock file
+// left by a crashed agent
+func removeWorkspace(dir string) error {
+	os.Remove(dir + lockExt)
+	return os.RemoveAll(dir)
+}
+
+// lockExt is the extension of the lock files of workspaces
+const lockExt = ".lock"
+
+// workspacePrefix returns the prefix of workspace names for jobs of type t
+func workspacePrefix(t string) string {
+	t = strings.Map(func(r rune) rune {
+		if r == filepath.Separator || r == '/' || r == '.' {
+			return '-'
+		}
+		return r
+	}, t)
+	if t == "" {
+		t = "job"
+	}
+	return t + "-"
+}
+
+// dirSize returns the total size of the files in dir
+func dirSize(dir string) int64 {
+	var size int64
+	filepath.Walk(dir, func(_ string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size
+}
@@ -0,0 +1,147 @@
+package quantum
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestWorkspaces(t *testing.T) *Workspaces {
+	root, err := ioutil.TempDir("", "workspaces")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewWorkspaces(root)
+}
+
+func TestWorkspaces(t *testing.T) {
+	w := newTestWorkspaces(t)
+	defer os.RemoveAll(w.Root)
+
+	a, err := w.Create("build/../test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := w.Create("build")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if a == b || filepath.Dir(a) != w.Root {
+		t.Fatalf("expected unique workspaces in root, got %s and %s", a, b)
+	}
+
+	if err := w.Release(a, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(a); !os.IsNotExist(err) {
+		t.Fatal("expected workspace to be removed")
+	}
+}
+
+func TestWorkspacesPreserveOnFailure(t *testing.T) {
+	w := newTestWorkspaces(t)
+	defer os.RemoveAll(w.Root)
+	w.PreserveOnFailure = true
+
+	dir, err := w.Create("build")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := w.Release(dir, errors.New("failed")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(dir); err != nil {
+		t.Fatalf("expected workspace to be preserved: %s", err)
+	}
+}
+
+func TestWorkspacesCollect(t *testing.T) {
+	w := newTestWorkspaces(t)
+	defer os.RemoveAll(w.Root)
+	w.PreserveOnFailure = true
+	w.MaxAge = time.Hour
+	w.MaxSize = 15
+
+	failed := errors.New("failed")
+	create := func(age time.Duration, size int) string {
+		dir, err := w.Create("build")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, "data"), make([]byte, size), 0644); err != nil {
+			t.Fatal(err)
+		}
+		modTime := time.Now().Add(-age)
+		if err := os.Chtimes(dir, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+		return dir
+	}
+
+	expired := create(2*time.Hour, 1)
+	oldest := create(3*time.Minute, 10)
+	newest := create(time.Minute, 10)
+	active := create(4*time.Minute, 10)
+	for _, dir := range []string{expired, oldest, newest} {
+		w.Release(dir, failed)
+	}
+
+	if err := w.Collect(); err != nil {
+		t.Fatal(err)
+	}
+
+	for dir, exists := range map[string]bool{
+		expired: false,
+		oldest:  false,
+		newest:  true,
+		active:  true,
+	} {
+		if _, err := os.Stat(dir); (err == nil) != exists {
+			t.Fatalf("expected %s to exist: %v, got %v", dir, exists, err)
+		}
+	}
+}
+
+func TestWorkspacesCollectShared(t *testing.T) {
+	w := newTestWorkspaces(t)
+	defer os.RemoveAll(w.Root)
+
+	// Another agent sharing the root collects every inactive workspace
+	other := NewWorkspaces(w.Root)
+	other.MaxAge = time.Nanosecond
+
+	active, err := w.Create("build")
+	if err != nil {
+		t.Fatal(err)
+	}
+	released, err := w.Create("build")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.PreserveOnFailure = true
+	w.Release(released, errors.New("failed"))
+	time.Sleep(time.Millisecond)
+
+	if err := other.Collect(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(active); err != nil {
+		t.Fatalf("expected active workspace of another agent to be kept: %s", err)
+	}
+	if _, err := os.Stat(released); !os.IsNotExist(err) {
+		t.Fatal("expected released workspace to be collected")
+	}
+
+	if err := w.Release(active, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(active + lockExt); !os.IsNotExist(err) {
+		t.Fatal("expected lock file to be removed")
+	}
+}
@@ -0,0 +1,39 @@
+//go:build !windows
+// +build !windows
+
+package quantum
+
+import (
+	"os"
+	"syscall"
+)
+
+// lockWorkspace flocks the lock file f of a workspace, returning a func
+// unlocking and removing it
+func lockWorkspace(f *os.File) (func(), error) {
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		return nil, err
+	}
+
+	return func() {
+		os.Remove(f.Name())
+		syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+		f.Close()
+	}, nil
+}
+
+// workspaceLocked returns whether the lock file at path is flocked by a
+// running job. Locks of crashed agents are released with their process.
+func workspaceLocked(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		return err == syscall.EWOULDBLOCK
+	}
+	syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+	return false
+}
@@ -0,0 +1,23 @@
+//go:build windows
+// +build windows
+
+package quantum
+
+import "os"
+
+// lockWorkspace keeps the lock file f of a workspace open, since open files
+// can't be removed, returning a func closing and removing it
+func lockWorkspace(f *os.File) (func(), error) {
+	return func() {
+		f.Close()
+		os.Remove(f.Name())
+	}, nil
+}
+
+// workspaceLocked returns whether the lock file at path is held open by a
+// running job, by removing it. Files of crashed agents are closed with
+// their process, so their lock files are removed.
+func workspaceLocked(path string) bool {
+	err := os.Remove(path)
+	return err != nil && !os.IsNotExist(err)
+}